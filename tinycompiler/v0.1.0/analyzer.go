@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 )
 
 var counter = 0
@@ -17,15 +18,30 @@ func newLabel() string {
 
 func buildSymtable(ast any) {
 	fun, ok := ast.(Function)
-	if !ok || fun.name != "main" || fun.deco["type"] != VOID || len(fun.args) > 0 {
+	if !ok || fun.deco["library"] != true && (fun.name != "main" || fun.deco["type"] != VOID || len(fun.args) > 0) {
 		panic("Cannot find a valid entry point")
 	}
+	if _, ok := fun.deco["module"]; !ok && fun.deco["library"] == true {
+		fun.deco["module"] = "wend" // the driver names the module after its file
+	}
 	symtable := newSymbolTable()
 	symtable.addFun(fun.name, []Type{}, fun.deco)
+	imported, _ := fun.deco["imported"].([]Function) // exported functions of the imported modules
+	externs, _ := fun.deco["externs"].([]Function)   // C functions
+	for _, f := range append(append([]Function{}, imported...), externs...) {
+		f.deco["argtypes"] = argTypes(f)
+		f.deco["ancestors"] = []int{}
+		symtable.addFun(f.name, f.deco["argtypes"].([]Type), f.deco)
+	}
+	addBuiltins(symtable)
+	fun.deco["argtypes"] = []Type{}
+	fun.deco["ancestors"] = []int{}
 	fun.deco["strings"] = make(map[string]string)
 	processScope(&fun, symtable)
 	processScope(&fun, symtable)
 	fun.deco["scopeCnt"] = symtable.scopeCnt
+	fun.deco["levelCnt"] = symtable.levelCnt
+	fun.deco["callgraph"] = newCallGraph(fun)
 }
 
 func processScope(fun *Function, symtable *SymbolTable) {
@@ -38,13 +54,28 @@ func processScope(fun *Function, symtable *SymbolTable) {
 	for _, v := range fun.vars { // process local variables
 		symtable.addVar(v.name, &v.deco)
 	}
+	declareConsts(fun.deco, symtable)
+
+	ancestors := []int{} // display levels of the enclosing functions, a closure must capture their frames
+	for _, deco := range symtable.retStack[1:] {
+		if (*deco)["library"] != true { // the root of a library has no frame
+			ancestors = append(ancestors, (*deco)["level"].(int))
+		}
+	}
 
 	for _, f := range fun.fun { // process nested functions: first add function symbols to the table
-		argtypes := []Type{}
-		for _, arg := range f.args {
-			argtypes = append(argtypes, arg.deco["type"].(Type))
+		if f.name == "main" && fun.deco["library"] == true {
+			panic(fmt.Sprintf("main must be the only top-level function of a program, line %d", f.deco["lineno"]))
 		}
+		argtypes := argTypes(f)
 		symtable.addFun(f.name, argtypes, f.deco)
+		f.deco["argtypes"] = argtypes
+		f.deco["ancestors"] = ancestors
+		f.deco["parent"] = fun.deco["scope"]
+		if f.deco["export"] == true { // exported functions are called from other objects through their thunk
+			f.deco["thunk"] = true
+			f.deco["global"] = mangle(fun.deco["module"].(string), f.name, argtypes)
+		}
 	}
 
 	for i := range fun.fun { // then process nested function bodies
@@ -54,45 +85,179 @@ func processScope(fun *Function, symtable *SymbolTable) {
 	for i := range fun.body { // process the list of statements
 		fun.body[i] = processStat(fun.body[i], symtable)
 	}
+	markTailCalls(fun.body, fun.deco, true)
 
 	symtable.popScope()
 }
 
+// The value of a constant is computed by the interpreter, with the semantics of the running program: the int
+// arithmetic wraps around at 32 bits. The uses of the constant are replaced by a literal of its value, so that
+// a constant may appear wherever a literal may.
+func declareConsts(fundeco map[string]any, symtable *SymbolTable) {
+	consts, _ := fundeco["consts"].([]Var)
+	for _, c := range consts {
+		t := c.deco["type"].(Type)
+		if t != INT && t != LONG && t != BOOL {
+			panic(fmt.Sprintf("A constant must be of type int, long or bool, line %d", c.deco["lineno"]))
+		}
+		value := adaptLiteral(processExpr(c.deco["value"].(Expression), symtable), t)
+		if value.getDeco()["type"] != t {
+			panic(fmt.Sprintf("Incompatible types in the declaration of the constant %s, line %d", c.name, c.deco["lineno"]))
+		}
+		c.deco["value"] = constValue("the constant "+c.name, value, c.deco["lineno"].(int))
+		symtable.addConst(c.name, c.deco)
+	}
+}
+
+// evaluate an expression made of literals, what names it in the errors
+func constValue(what string, e Expression, lineno int) Expression {
+	visitExpr(e, func(e Expression) {
+		switch e.(type) {
+		case Var, FunRef, FunCall, String, New:
+			panic(fmt.Sprintf("The value of %s is not a constant expression, line %d", what, lineno))
+		}
+	})
+	defer func() {
+		r := recover()
+		if err, ok := r.(runtimeError); ok {
+			panic(fmt.Sprintf("Cannot evaluate %s: %s, line %d", what, err.msg, lineno))
+		} else if r != nil {
+			panic(r)
+		}
+	}()
+	return constLiteral(newMachine(io.Discard).expr(e), lineno)
+}
+
+// a fresh literal for a use of a constant, adaptLiteral may change its type
+func constLiteral(value any, lineno int) Expression {
+	switch v := value.(type) {
+	case int32:
+		return Integer{int(v), map[string]any{"type": INT, "lineno": lineno}}
+	case int64:
+		return Integer{int(v), map[string]any{"type": LONG, "lineno": lineno}}
+	case bool:
+		return Boolean{v, map[string]any{"type": BOOL, "lineno": lineno}}
+	}
+	panic(fmt.Sprintf("Invalid constant value %v, line %d", value, lineno))
+}
+
+// A call is in tail position if the function returns right after it. A tail call to the function itself or to
+// a sibling reuses the frame of the caller: siblings share the display level, so the display entry saved
+// by the caller's caller is restored correctly when the callee returns. The hidden slots of the frame may hold
+// closure records passed to the callee, so a function that creates closures does not reuse its frame.
+func markTailCalls(ss []Statement, fundeco map[string]any, last bool) {
+	mark := func(e FunCall) {
+		callee, ok := e.deco["fundeco"].(map[string]any)
+		if !ok || callee["import"] == true || callee["extern"] == true || callee["builtin"] == true || fundeco["closures"] == true {
+			return
+		}
+		if callee["scope"] == fundeco["scope"] || callee["parent"] != nil && callee["parent"] == fundeco["parent"] {
+			e.deco["tail"] = fundeco
+		}
+	}
+	for i, s := range ss {
+		switch e := s.(type) {
+		case Return:
+			if call, ok := e.expr.(FunCall); ok {
+				mark(call)
+			}
+		case FunCall: // a procedure call followed by the end of the procedure
+			if fundeco["type"] != VOID {
+				continue
+			}
+			if i+1 == len(ss) {
+				if last {
+					mark(e)
+				}
+			} else if r, ok := ss[i+1].(Return); ok && r.expr == nil {
+				mark(e)
+			}
+		case IfThenElse:
+			markTailCalls(e.ibody, fundeco, last && i+1 == len(ss))
+			markTailCalls(e.ebody, fundeco, last && i+1 == len(ss))
+		case Switch:
+			for _, c := range e.cases {
+				markTailCalls(c.body, fundeco, last && i+1 == len(ss))
+			}
+		case While:
+			markTailCalls(e.body, fundeco, false)
+		}
+	}
+}
+
+func argTypes(f Function) []Type {
+	argtypes := []Type{}
+	for _, arg := range f.args {
+		argtypes = append(argtypes, arg.deco["type"].(Type))
+	}
+	return argtypes
+}
+
 func processStat(n Statement, symtable *SymbolTable) Statement {
 	switch e := n.(type) {
 	case Print:
 		e.expr = processExpr(e.expr, symtable)
+		if e.expr.getDeco()["type"].(Type).isFun() {
+			panic(fmt.Sprintf("Cannot print a function value, line %d", e.deco["lineno"]))
+		}
+		if e.expr.getDeco()["type"].(Type).isArray() {
+			panic(fmt.Sprintf("Cannot print an array, line %d", e.deco["lineno"]))
+		}
 		return e
 	case Return:
 		if e.expr == nil {
-			return nil
+			if (*symtable.retStack[len(symtable.retStack)-1])["type"] != VOID {
+				panic(fmt.Sprintf("Missing return value, line %d", e.deco["lineno"]))
+			}
+			return e
 		}
-		e.expr = processExpr(e.expr, symtable)
+		e.expr = adaptLiteral(processExpr(e.expr, symtable), (*symtable.retStack[len(symtable.retStack)-1])["type"].(Type))
 		if (*symtable.retStack[len(symtable.retStack)-1])["type"] != e.expr.getDeco()["type"] {
-			panic(fmt.Sprintf("Incompatible types in return statement, line %s", e.deco["lineno"]))
+			panic(fmt.Sprintf("Incompatible types in return statement, line %d", e.deco["lineno"]))
+		}
+		if recordLevel(e.expr, symtable) >= (*symtable.retStack[len(symtable.retStack)-1])["level"].(int) {
+			panic(fmt.Sprintf("Cannot return a closure created in the frame of the function, line %d", e.deco["lineno"]))
 		}
 		return e
 	case Assign:
 		e.expr = processExpr(e.expr, symtable)
 		deco := symtable.findVar(e.name)
+		if deco["const"] == true {
+			panic(fmt.Sprintf("Cannot assign to the constant %s, line %d", e.name, e.deco["lineno"]))
+		}
 		if len(e.deco) == 0 {
 			e.deco = make(map[string]any)
 		}
-		for k := range deco {
-			e.deco[k] = deco[k]
-		}
+		copyDeco(e.deco, deco)
+		e.expr = adaptLiteral(e.expr, e.deco["type"].(Type))
 
 		if e.deco["type"] != e.expr.getDeco()["type"] {
-			panic(fmt.Sprintf("Incompatible types in assignment statement, line %s", e.deco["lineno"]))
+			panic(fmt.Sprintf("Incompatible types in assignment statement, line %d", e.deco["lineno"]))
+		}
+		if recordLevel(e.expr, symtable) > e.deco["level"].(int) {
+			panic(fmt.Sprintf("Cannot assign a closure to the variable %s that outlives its frame, line %d", e.name, e.deco["lineno"]))
 		}
 		return e
+	case Store:
+		e.array = processExpr(e.array, symtable)
+		if _, ok := e.array.(Var); !ok || !e.array.getDeco()["type"].(Type).isArray() {
+			panic(fmt.Sprintf("Cannot index a non-array value, line %d", e.deco["lineno"]))
+		}
+		e.index = processIndex(e.index, e.deco["lineno"], symtable)
+		elem := e.array.getDeco()["type"].(Type).elem()
+		e.expr = adaptLiteral(processExpr(e.expr, symtable), elem)
+		if e.expr.getDeco()["type"] != elem {
+			panic(fmt.Sprintf("Incompatible types in assignment statement, line %d", e.deco["lineno"]))
+		}
+		e.deco["type"] = elem
+		return e
 	case FunCall: // no type checking is necessary
 		e = processExpr(e, symtable).(FunCall)
 		return e
 	case While:
 		e.expr = processExpr(e.expr, symtable)
 		if e.expr.getDeco()["type"].(Type) != BOOL {
-			panic(fmt.Sprintf("Non-boolean expression in while statement, line %s", e.deco["lineno"]))
+			panic(fmt.Sprintf("Non-boolean expression in while statement, line %d", e.deco["lineno"]))
 		}
 		for i := range e.body {
 			e.body[i] = processStat(e.body[i], symtable)
@@ -101,11 +266,43 @@ func processStat(n Statement, symtable *SymbolTable) Statement {
 	case IfThenElse:
 		e.expr = processExpr(e.expr, symtable)
 		if e.expr.getDeco()["type"].(Type) != BOOL {
-			panic(fmt.Sprintf("Non-boolean expression in if statement, line %s", e.deco["lineno"]))
+			panic(fmt.Sprintf("Non-boolean expression in if statement, line %d", e.deco["lineno"]))
+		}
+		for i := range e.ibody {
+			e.ibody[i] = processStat(e.ibody[i], symtable)
+		}
+		for i := range e.ebody {
+			e.ebody[i] = processStat(e.ebody[i], symtable)
+		}
+		return e
+	case Switch:
+		e.expr = processExpr(e.expr, symtable)
+		if e.expr.getDeco()["type"].(Type) != INT {
+			panic(fmt.Sprintf("Non-integer expression in switch statement, line %d", e.deco["lineno"]))
 		}
-		ss := append(e.ibody, e.ebody...)
-		for i := range ss {
-			ss[i] = processStat(ss[i], symtable)
+		seen, hasDefault := map[int]bool{}, false
+		for _, c := range e.cases {
+			if len(c.values) == 0 {
+				if hasDefault {
+					panic(fmt.Sprintf("Duplicate default case in switch statement, line %d", c.deco["lineno"]))
+				}
+				hasDefault = true
+			}
+			for i := range c.values { // the values are folded to literals, the constants included
+				c.values[i] = processExpr(c.values[i], symtable)
+				if c.values[i].getDeco()["type"].(Type) != INT {
+					panic(fmt.Sprintf("Non-integer case value in switch statement, line %d", c.deco["lineno"]))
+				}
+				c.values[i] = constValue("the case", c.values[i], c.deco["lineno"].(int))
+				value := c.values[i].(Integer).value
+				if seen[value] {
+					panic(fmt.Sprintf("Duplicate case %d in switch statement, line %d", value, c.deco["lineno"]))
+				}
+				seen[value] = true
+			}
+			for i := range c.body {
+				c.body[i] = processStat(c.body[i], symtable)
+			}
 		}
 		return e
 	default:
@@ -113,6 +310,37 @@ func processStat(n Statement, symtable *SymbolTable) Statement {
 	}
 }
 
+// an int literal takes the type of the other operand, so that 1 can be written instead of 1L in long arithmetic;
+// the same holds for arithmetic over literals only, e.g. the negative literal -1 is parsed as 0-1
+func adaptLiteral(n Expression, typ Type) Expression {
+	if typ != LONG || n.getDeco()["type"] != INT || !literalOnly(n) {
+		return n
+	}
+	visitExpr(n, func(e Expression) {
+		e.getDeco()["type"] = LONG
+	})
+	return n
+}
+
+func literalOnly(n Expression) bool {
+	switch e := n.(type) {
+	case Integer:
+		return true
+	case ArithOp:
+		return literalOnly(e.left) && literalOnly(e.right)
+	}
+	return false
+}
+
+// copy the symbol decoration to a node referring to the symbol, the node keeps its own line number
+func copyDeco(dst, src map[string]any) {
+	for k := range src {
+		if k != "lineno" {
+			dst[k] = src[k]
+		}
+	}
+}
+
 func processExpr(n Expression, symtable *SymbolTable) Expression {
 	switch e := n.(type) {
 	case ArithOp:
@@ -121,9 +349,18 @@ func processExpr(n Expression, symtable *SymbolTable) Expression {
 		}
 		e.left = processExpr(e.left, symtable)
 		e.right = processExpr(e.right, symtable)
-		if e.left.getDeco()["type"].(Type) != INT || e.right.getDeco()["type"].(Type) != INT {
-			panic(fmt.Sprintf("Arithmetic operation over non-integer type in line %s", e.deco["lineno"]))
+		if e.deco["unary"] == true && e.right.getDeco()["type"] == FLOAT { // -x is parsed as 0-x
+			e.left = Float{0, map[string]any{"type": FLOAT}}
+		}
+		e.left = adaptLiteral(e.left, e.right.getDeco()["type"].(Type))
+		e.right = adaptLiteral(e.right, e.left.getDeco()["type"].(Type))
+		if e.left.getDeco()["type"].(Type) != e.right.getDeco()["type"] || !e.left.getDeco()["type"].(Type).isNumeric() {
+			panic(fmt.Sprintf("Arithmetic operation over non-integer type in line %d", e.deco["lineno"]))
 		}
+		if e.left.getDeco()["type"] == FLOAT && e.op != "+" && e.op != "-" && e.op != "*" && e.op != "/" {
+			panic(fmt.Sprintf("Operation %s is not defined for float values, line %d", e.op, e.deco["lineno"]))
+		}
+		e.deco["type"] = e.left.getDeco()["type"]
 		return e
 	case LogicOp:
 		if len(e.deco) == 0 {
@@ -131,29 +368,41 @@ func processExpr(n Expression, symtable *SymbolTable) Expression {
 		}
 		e.left = processExpr(e.left, symtable)
 		e.right = processExpr(e.right, symtable)
+		e.left = adaptLiteral(e.left, e.right.getDeco()["type"].(Type))
+		e.right = adaptLiteral(e.right, e.left.getDeco()["type"].(Type))
 		if e.left.getDeco()["type"].(Type) != e.right.getDeco()["type"] {
-			panic(fmt.Sprintf("Arithmetic operation over non-integer type in line %s", e.deco["lineno"]))
+			panic(fmt.Sprintf("Arithmetic operation over non-integer type in line %d", e.deco["lineno"]))
+		}
+		if e.left.getDeco()["type"].(Type).isFun() {
+			panic(fmt.Sprintf("Cannot compare function values, line %d", e.deco["lineno"]))
+		}
+		if e.left.getDeco()["type"].(Type).isArray() {
+			panic(fmt.Sprintf("Cannot compare arrays, line %d", e.deco["lineno"]))
 		}
 		switch e.op {
 		case "<=", "<", ">=", ">":
-			if e.left.getDeco()["type"].(Type) != INT {
-				panic(fmt.Sprintf("Arithmetic operation over non-integer type in line %#v", e.deco["lineno"]))
+			if !e.left.getDeco()["type"].(Type).isNumeric() {
+				panic(fmt.Sprintf("Arithmetic operation over non-integer type in line %d", e.deco["lineno"]))
 			}
 		case "&&", "||":
 			if e.left.getDeco()["type"].(Type) != BOOL {
-				panic(fmt.Sprintf("Arithmetic operation over non-integer type in line %s", e.deco["lineno"]))
+				panic(fmt.Sprintf("Arithmetic operation over non-integer type in line %d", e.deco["lineno"]))
 			}
 		}
 		return e
 	case Var: // no type checking is necessary
-		deco := symtable.findVar(e.name)
+		deco, ok := symtable.lookupVar(e.name)
+		if !ok { // not a variable, must be a function used as a value
+			return processFunRef(e, symtable)
+		}
+		if deco["const"] == true {
+			return constLiteral(newMachine(io.Discard).expr(deco["value"].(Expression)), e.deco["lineno"].(int))
+		}
 		if len(e.deco) == 0 {
 			e.deco = make(map[string]any)
 		}
 
-		for k := range deco {
-			e.deco[k] = deco[k]
-		}
+		copyDeco(e.deco, deco)
 		return e
 	case FunCall:
 		for i := range e.args {
@@ -163,14 +412,43 @@ func processExpr(n Expression, symtable *SymbolTable) Expression {
 		for _, arg := range e.args {
 			argtypes = append(argtypes, arg.getDeco()["type"].(Type))
 		}
-		deco := symtable.findFun(e.name, argtypes)
+		deco, indirect := symtable.findCallee(e.name, argtypes)
 		if len(e.deco) == 0 {
 			e.deco = make(map[string]any)
 		}
+		if indirect { // call through a variable of function type
+			ft := deco["type"].(Type).funType()
+			if !sameTypes(ft.args, argtypes) {
+				panic(fmt.Sprintf("Incompatible arguments in the call of %s, line %d", e.name, e.deco["lineno"]))
+			}
+			closure := Var{e.name, map[string]any{"lineno": e.deco["lineno"]}}
+			copyDeco(closure.deco, deco)
+			e.deco["type"] = ft.ret
+			e.deco["indirect"] = true
+			e.deco["closure"] = closure
+			return e
+		}
 
-		for k := range deco {
-			e.deco[k] = deco[k]
+		copyDeco(e.deco, deco)
+		e.deco["fundeco"] = deco // the decoration is copied before the callee is fully processed, keep the reference
+		if deco["builtin"] == true {
+			(*symtable.retStack[1])[deco["runtime"].(string)] = true // the runtime of the builtins is linked with the program
+		}
+		return e
+	case New:
+		e.size = processExpr(e.size, symtable)
+		if e.size.getDeco()["type"] != INT {
+			panic(fmt.Sprintf("Non-integer array size, line %d", e.deco["lineno"]))
+		}
+		(*symtable.retStack[1])["heap"] = true
+		return e
+	case Index:
+		e.array = processExpr(e.array, symtable)
+		if !e.array.getDeco()["type"].(Type).isArray() {
+			panic(fmt.Sprintf("Cannot index a non-array value, line %d", e.deco["lineno"]))
 		}
+		e.index = processIndex(e.index, e.deco["lineno"], symtable)
+		e.deco["type"] = e.array.getDeco()["type"].(Type).elem()
 		return e
 	case String:
 		if _, ok := (*symtable.retStack[1])["strings"]; !ok {
@@ -178,11 +456,72 @@ func processExpr(n Expression, symtable *SymbolTable) Expression {
 		}
 		(*symtable.retStack[1])["strings"].(map[string]string)[e.deco["label"].(string)] = e.value
 		return e
+	case Convert:
+		e.expr = processExpr(e.expr, symtable)
+		if from, to := e.expr.getDeco()["type"].(Type), e.deco["type"].(Type); !from.isNumeric() || !to.isNumeric() {
+			panic(fmt.Sprintf("Invalid conversion from %s to %s, line %d", from, to, e.deco["lineno"]))
+		}
+		return e
+	case FunRef: // resolved by the first pass, but the hidden slots must be allocated again
+		return processFunRef(Var{e.name, e.deco}, symtable)
 	case Integer: // no type checking is necessary
 		return e
+	case Float: // no type checking is necessary
+		return e
 	case Boolean: // no type checking is necessary
 		return e
 	default:
 		panic(fmt.Sprintln("Unknown expression type", e))
 	}
 }
+
+// the index of an element, the bounds are checked by the runtime of the heap
+func processIndex(n Expression, lineno any, symtable *SymbolTable) Expression {
+	n = processExpr(n, symtable)
+	if n.getDeco()["type"] != INT {
+		panic(fmt.Sprintf("Non-integer array index, line %d", lineno))
+	}
+	(*symtable.retStack[1])["heap"] = true
+	return n
+}
+
+// the deepest frame holding the closure record a function value may refer to, -1 for the other values; the records
+// live in the frames that create them, so a closure can be passed down the calls but must not outlive its frame
+func recordLevel(e Expression, symtable *SymbolTable) int {
+	if !e.getDeco()["type"].(Type).isFun() {
+		return -1
+	}
+	switch e := e.(type) {
+	case Var:
+		return e.deco["level"].(int)
+	case FunRef:
+		return e.deco["level"].(int)
+	}
+	// no call returns a function, the grammar has no such return type; any other value is taken as a record of
+	// the current frame
+	return (*symtable.retStack[len(symtable.retStack)-1])["level"].(int)
+}
+
+// A closure is a record [thunk, display entries of the callee's ancestors] stored in hidden slots of the current frame,
+// so it is valid as long as the function that took the reference is alive (downward funargs, like in Pascal).
+func processFunRef(e Var, symtable *SymbolTable) Expression {
+	fundeco := symtable.findFunRef(e.name)
+	if fundeco["extern"] == true {
+		panic(fmt.Sprintf("Cannot use the extern function %s as a value, line %d", e.name, e.deco["lineno"]))
+	}
+	if fundeco["builtin"] == true {
+		panic(fmt.Sprintf("Cannot use the builtin function %s as a value, line %d", e.name, e.deco["lineno"]))
+	}
+	fundeco["thunk"] = true // the callee needs an entry point for indirect calls
+	frame := *symtable.retStack[len(symtable.retStack)-1]
+	deco := map[string]any{
+		"lineno":  e.deco["lineno"],
+		"type":    newFunType(fundeco["argtypes"].([]Type), fundeco["type"].(Type)),
+		"fundeco": fundeco,
+		"level":   frame["level"],
+		"slot":    frame["varCnt"],
+	}
+	frame["varCnt"] = frame["varCnt"].(int) + 1 + len(fundeco["ancestors"].([]int))
+	frame["closures"] = true
+	return FunRef{e.name, deco}
+}
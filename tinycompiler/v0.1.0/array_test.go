@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestArrays(t *testing.T) {
+	tests := []struct{ source, err string }{
+		{"main() {\n    int[] a;\n    println a;\n}\n", "Cannot print an array, line 2"},
+		{"main() {\n    int[] a;\n    a = new int[3];\n    a[true] = 1;\n}\n", "Non-integer array index, line 3"},
+		{"main() {\n    int[] a;\n    a = new int[1.5];\n}\n", "Non-integer array size, line 2"},
+		{"main() {\n    int a;\n    a[0] = 1;\n}\n", "Cannot index a non-array value, line 2"},
+		{"main() {\n    int[] a;\n    a = new int[1];\n    a[0] = true;\n}\n", "Incompatible types in assignment statement, line 3"},
+		{"main() {\n    int[] a;\n    long[] b;\n    a = new long[1];\n}\n", "Incompatible types in assignment statement, line 3"},
+		{"main() {\n    int[] a;\n    println a == a;\n}\n", "Cannot compare arrays, line 2"},
+	}
+	for _, test := range tests {
+		if err := analyzeError(test.source); err == nil || err.Error() != test.err {
+			t.Errorf("expected the error %q, got %v", test.err, err)
+		}
+	}
+
+	// an index out of range stops the program, the interpreter tells the line
+	source := "main() {\n    int[] a;\n    a = new int[3];\n    println len(a);\n    a[3] = 1;\n}\n"
+	dir := t.TempDir()
+	var out bytes.Buffer
+	err := func() (err error) {
+		defer catch(&err, nil)
+		newMachine(&out).run(loadProgram(writeSource(t, dir, "bounds", source)))
+		return nil
+	}()
+	if err == nil || err.Error() != "index out of range, line 4" || out.String() != "3\n" {
+		t.Errorf("interpreter: expected the output 3 and the error at line 4, got %q and %v", out.String(), err)
+	}
+
+	if _, err := exec.LookPath("as"); err != nil {
+		t.Skip("GNU as is not available")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(buildExe(t, dir, "bounds", source))
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	var exit *exec.ExitError
+	if err := cmd.Run(); !errors.As(err, &exit) || exit.ExitCode() != 1 {
+		t.Errorf("expected the exit code 1, got %v", err)
+	}
+	if stdout.String() != "3\n" || stderr.String() != errBounds+"\n" {
+		t.Errorf("expected the output 3 and the error %q, got %q and %q", errBounds, stdout.String(), stderr.String())
+	}
+}
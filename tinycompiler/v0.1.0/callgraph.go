@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// The call graph links every function of a module to the functions it calls, the overloads are told apart by
+// the labels of the resolved signatures. Creating the closure of a function is an edge as well: the closure may be
+// called from anywhere, the callee is reachable from the function creating it. The roots are main, or the exported
+// functions of a library; the functions that cannot be reached from the roots are not compiled. The recursive
+// functions are grouped by cycles, the strongly connected components of the direct calls.
+type callEdge struct {
+	to  string // the label of the callee
+	ref bool   // a closure of the callee is created, the calls through it are indirect
+}
+
+type callGraph struct {
+	funs      map[string]Function   // the functions of the module by label
+	order     []string              // the labels in the order of the declarations
+	parent    map[string]string     // the label of the enclosing function, none for the top-level ones of a library
+	edges     map[string][]callEdge // caller -> callees, every callee once
+	external  map[string]string     // the imported, extern and builtin callees: label -> signature
+	indirect  map[string]bool       // the functions calling closures
+	roots     []string
+	reachable map[string]bool
+	cycles    [][]string     // the recursive cycles in the order of the declarations
+	cycle     map[string]int // label -> index of its cycle, for the recursive functions only
+}
+
+func newCallGraph(root Function) *callGraph {
+	g := &callGraph{funs: map[string]Function{}, parent: map[string]string{}, edges: map[string][]callEdge{},
+		external: map[string]string{}, indirect: map[string]bool{}, reachable: map[string]bool{}, cycle: map[string]int{}}
+	var collect func(f Function, parent string)
+	collect = func(f Function, parent string) {
+		label := f.deco["label"].(string)
+		if f.deco["library"] == true { // the root of a library has no code of its own
+			label = ""
+		} else {
+			g.funs[label] = f
+			g.order = append(g.order, label)
+			g.parent[label] = parent
+		}
+		for _, nested := range f.fun {
+			if label == "" && nested.deco["export"] == true {
+				g.roots = append(g.roots, nested.deco["label"].(string))
+			}
+			collect(nested, label)
+		}
+	}
+	collect(root, "")
+	if root.deco["library"] != true {
+		g.roots = []string{root.deco["label"].(string)}
+	}
+
+	for _, label := range g.order {
+		add := func(fundeco map[string]any, name string, ref bool) {
+			to := fundeco["label"].(string)
+			if _, ok := g.funs[to]; !ok {
+				g.external[to] = signatureOf(name, fundeco)
+			}
+			for _, e := range g.edges[label] {
+				if e == (callEdge{to, ref}) {
+					return
+				}
+			}
+			g.edges[label] = append(g.edges[label], callEdge{to, ref})
+		}
+		visitExprs(g.funs[label].body, func(e Expression) {
+			switch e := e.(type) {
+			case FunCall:
+				if e.deco["indirect"] == true {
+					g.indirect[label] = true
+				} else {
+					add(e.deco["fundeco"].(map[string]any), e.name, false)
+				}
+			case FunRef:
+				add(e.deco["fundeco"].(map[string]any), e.name, true)
+			}
+		})
+	}
+
+	var visit func(label string)
+	visit = func(label string) {
+		if g.reachable[label] {
+			return
+		}
+		g.reachable[label] = true
+		for _, e := range g.edges[label] {
+			if _, ok := g.funs[e.to]; ok {
+				visit(e.to)
+			}
+		}
+	}
+	for _, label := range g.roots {
+		visit(label)
+	}
+	g.findCycles()
+	return g
+}
+
+// the strongly connected components of a graph by Tarjan's algorithm, a component comes after the components it
+// leads to: the callees before their callers
+func components(nodes []string, successors func(string) []string) [][]string {
+	index, low, onStack := map[string]int{}, map[string]int{}, map[string]bool{}
+	stack := []string{}
+	result := [][]string{}
+	var connect func(node string)
+	connect = func(node string) {
+		index[node], low[node] = len(index), len(index)
+		stack = append(stack, node)
+		onStack[node] = true
+		for _, next := range successors(node) {
+			if _, seen := index[next]; !seen {
+				connect(next)
+				low[node] = min(low[node], low[next])
+			} else if onStack[next] {
+				low[node] = min(low[node], index[next])
+			}
+		}
+		if low[node] != index[node] {
+			return
+		}
+		component := []string{}
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == node {
+				break
+			}
+		}
+		result = append(result, component)
+	}
+	for _, node := range nodes {
+		if _, seen := index[node]; !seen {
+			connect(node)
+		}
+	}
+	return result
+}
+
+// the cycles are the components of the direct calls made of several functions, or of a single function calling
+// itself; the functions of the cycles get the "recursive" decoration
+func (g *callGraph) findCycles() {
+	components := components(g.order, func(label string) []string {
+		callees := []string{}
+		for _, e := range g.edges[label] {
+			if _, ok := g.funs[e.to]; ok && !e.ref {
+				callees = append(callees, e.to)
+			}
+		}
+		return callees
+	})
+	components = slices.DeleteFunc(components, func(c []string) bool { return len(c) == 1 && !g.calls(c[0], c[0]) })
+	position := map[string]int{}
+	for i, label := range g.order {
+		position[label] = i
+	}
+	for _, component := range components { // list the cycles and their functions in the order of the declarations
+		sort.Slice(component, func(i, j int) bool { return position[component[i]] < position[component[j]] })
+		g.cycles = append(g.cycles, component)
+	}
+	sort.Slice(g.cycles, func(i, j int) bool { return position[g.cycles[i][0]] < position[g.cycles[j][0]] })
+	for i, cycle := range g.cycles {
+		for _, label := range cycle {
+			g.cycle[label] = i
+			g.funs[label].deco["recursive"] = true
+		}
+	}
+}
+
+// tell whether the caller calls the callee directly
+func (g *callGraph) calls(caller, callee string) bool {
+	for _, e := range g.edges[caller] {
+		if e.to == callee && !e.ref {
+			return true
+		}
+	}
+	return false
+}
+
+// drop the functions that cannot be reached from the roots, the result lists the removed functions; the nested
+// functions of a removed function are not listed
+func removeUnused(f *Function, g *callGraph) []Function {
+	removed := []Function{}
+	kept := []Function{}
+	for i := range f.fun {
+		if !g.reachable[f.fun[i].deco["label"].(string)] {
+			removed = append(removed, f.fun[i])
+			continue
+		}
+		removed = append(removed, removeUnused(&f.fun[i], g)...)
+		kept = append(kept, f.fun[i])
+	}
+	f.fun = kept
+	return removed
+}
+
+// the warnings for the unused functions, a function inside an unused one is not reported
+func (g *callGraph) unused() []Warning {
+	warnings := []Warning{}
+	for _, label := range g.order {
+		if parent := g.parent[label]; g.reachable[label] || parent != "" && !g.reachable[parent] {
+			continue
+		}
+		f := g.funs[label]
+		msg := fmt.Sprintf("function %s is never called", f.name)
+		for _, caller := range g.order {
+			for _, e := range g.edges[caller] {
+				if e.to == label && caller != label {
+					msg = fmt.Sprintf("function %s is called only from unused functions", f.name)
+				}
+			}
+		}
+		warnings = append(warnings, Warning{f.deco["lineno"].(int), msg})
+	}
+	return warnings
+}
+
+// one box per function with its signature and line, the recursive cycles are clusters and the unused functions
+// are dashed; a dashed edge creates a closure, the imported, extern and builtin callees are ellipses
+func dumpCallGraph(root Function) string {
+	g := root.deco["callgraph"].(*callGraph)
+	var out strings.Builder
+	out.WriteString("digraph calls {\n\tnode [shape=box, fontname=\"monospace\"];\n")
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	node := func(label, indent string) {
+		f := g.funs[label]
+		text := fmt.Sprintf("%s\nline %d", signatureOf(f.name, f.deco), f.deco["lineno"].(int)+1)
+		attrs := ""
+		if g.indirect[label] {
+			text += "\ncalls closures"
+		}
+		if !g.reachable[label] {
+			attrs = ", style=dashed, color=gray"
+		}
+		fmt.Fprintf(&out, "%s\"%s\" [label=\"%s\"%s];\n", indent, label, quote.Replace(text), attrs)
+	}
+	for i, cycle := range g.cycles {
+		fmt.Fprintf(&out, "\tsubgraph cluster_%d {\n\t\tlabel=\"cycle %d\";\n\t\tcolor=red;\n", i, i)
+		for _, label := range cycle {
+			node(label, "\t\t")
+		}
+		out.WriteString("\t}\n")
+	}
+	for _, label := range g.order {
+		if _, ok := g.cycle[label]; !ok {
+			node(label, "\t")
+		}
+	}
+	externals := []string{}
+	for _, label := range g.order {
+		for _, e := range g.edges[label] {
+			if _, ok := g.funs[e.to]; !ok && !slices.Contains(externals, e.to) {
+				externals = append(externals, e.to)
+			}
+		}
+	}
+	for _, label := range externals {
+		fmt.Fprintf(&out, "\t\"%s\" [label=\"%s\", shape=ellipse];\n", label, quote.Replace(g.external[label]))
+	}
+	cycleOf := func(label string) int {
+		if c, ok := g.cycle[label]; ok {
+			return c
+		}
+		return -1
+	}
+	for _, label := range g.order {
+		for _, e := range g.edges[label] {
+			attrs := ""
+			if e.ref {
+				attrs = " [style=dashed]"
+			} else if c, ok := g.cycle[label]; ok && c == cycleOf(e.to) {
+				attrs = " [color=red]"
+			}
+			fmt.Fprintf(&out, "\t\"%s\" -> \"%s\"%s;\n", label, e.to, attrs)
+		}
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCallGraph(t *testing.T) {
+	source := `main() {
+    int twice(int a) {
+        return a * 2;
+    }
+
+    int twice(long a) {
+        return int(a) * 2;
+    }
+
+    bool even(int n) {
+        if n == 0 {
+            return true;
+        }
+        return odd(n - 1);
+    }
+
+    bool odd(int n) {
+        if n == 0 {
+            return false;
+        }
+        return even(n - 1);
+    }
+
+    int fact(int n) {
+        if n < 2 {
+            return 1;
+        }
+        return n * fact(n - 1);
+    }
+
+    unused() {
+        helper() {
+            println 1;
+        }
+        helper();
+        println fact(3);
+    }
+
+    int spin(int n) {
+        return spin(n);
+    }
+
+    println twice(3);
+    println odd(7);
+}
+`
+	ast := (&WendParser{}).Parse(tokenize(source)).(Function)
+	buildSymtable(ast)
+	g := ast.deco["callgraph"].(*callGraph)
+	names := func(labels []string) string {
+		result := []string{}
+		for _, label := range labels {
+			f := g.funs[label]
+			result = append(result, signatureOf(f.name, f.deco))
+		}
+		return strings.Join(result, " ")
+	}
+
+	reachable := []string{}
+	for _, label := range g.order {
+		if g.reachable[label] {
+			reachable = append(reachable, label)
+		}
+	}
+	if got, expected := names(reachable), "main() twice(int): int even(int): bool odd(int): bool"; got != expected {
+		t.Errorf("reachable: expected %s, got %s", expected, got)
+	}
+	cycles := []string{}
+	for _, cycle := range g.cycles {
+		cycles = append(cycles, names(cycle))
+	}
+	expected := "even(int): bool odd(int): bool; fact(int): int; spin(int): int"
+	if got := strings.Join(cycles, "; "); got != expected {
+		t.Errorf("cycles: expected %s, got %s", expected, got)
+	}
+	if ast.fun[4].deco["recursive"] != true || ast.fun[0].deco["recursive"] != nil {
+		t.Errorf("the recursive functions are not marked")
+	}
+
+	warnings := []string{}
+	for _, w := range vet(ast) {
+		if strings.Contains(w.msg, "function") {
+			warnings = append(warnings, fmt.Sprintf("%d: %s", w.lineno+1, w.msg))
+		}
+	}
+	expected = "6: function twice is never called; 24: function fact is called only from unused functions; " +
+		"31: function unused is never called; 39: function spin is never called"
+	if got := strings.Join(warnings, "; "); got != expected {
+		t.Errorf("warnings: expected %s, got %s", expected, got)
+	}
+
+	dot := dumpCallGraph(ast)
+	for _, s := range []string{"subgraph cluster_0 {", `"` + ast.fun[2].deco["label"].(string) + `" -> "` + ast.fun[3].deco["label"].(string) + `" [color=red];`, "style=dashed, color=gray"} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("the call graph lacks %s:\n%s", s, dot)
+		}
+	}
+
+	removed := []string{}
+	for _, f := range removeUnused(&ast, g) {
+		removed = append(removed, f.name)
+	}
+	if got := strings.Join(removed, " "); got != "twice fact unused spin" {
+		t.Errorf("removed: expected twice fact unused spin, got %s", got)
+	}
+	asm := transasm(ast)
+	for _, f := range ast.fun {
+		if !strings.Contains(asm, f.deco["label"].(string)+":") {
+			t.Errorf("the function %s is not compiled", f.name)
+		}
+	}
+	if len(ast.fun) != 3 {
+		t.Errorf("expected 3 functions left, got %d", len(ast.fun))
+	}
+}
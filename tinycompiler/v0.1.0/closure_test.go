@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+// a closure record lives in the frame that creates it, the closure must not outlive the frame
+func TestClosureEscape(t *testing.T) {
+	tests := []struct{ source, err string }{
+		{"main() {\n    fun(int):int g;\n    mk() {\n        int k;\n        int times(int x) {\n            return x*k;\n        }\n        g = times;\n    }\n}\n",
+			"Cannot assign a closure to the variable g that outlives its frame, line 7"},
+		{"main() {\n    fun(int):int g;\n    mk(fun(int):int f) {\n        g = f;\n    }\n}\n",
+			"Cannot assign a closure to the variable g that outlives its frame, line 3"},
+	}
+	for _, test := range tests {
+		if err := analyzeError(test.source); err == nil || err.Error() != test.err {
+			t.Errorf("expected the error %q, got %v", test.err, err)
+		}
+	}
+
+	// the record of a closure made by a nested function lives in its frame, a local variable may hold it
+	source := "main() {\n    int square(int x) {\n        return x*x;\n    }\n    show() {\n        fun(int):int h;\n        h = square;\n        println h(2);\n    }\n    show();\n}\n"
+	if err := analyzeError(source); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConst(t *testing.T) {
+	tests := []struct{ source, err string }{
+		{"main() {\n    const int A = 1 / 0;\n}\n", "Cannot evaluate the constant A: division by zero, line 1"},
+		{"main() {\n    int y;\n    const int A = y + 1;\n}\n", "The value of the constant A is not a constant expression, line 2"},
+		{"main() {\n    const int A = 2;\n    A = 3;\n}\n", "Cannot assign to the constant A, line 2"},
+		{"main() {\n    const float A = 2.0;\n}\n", "A constant must be of type int, long or bool, line 1"},
+		{"main() {\n    const bool A = 1;\n}\n", "Incompatible types in the declaration of the constant A, line 1"},
+		{"main() {\n    int A;\n    const int A = 2;\n}\n", "Double declaration of the variable A"},
+	}
+	for _, test := range tests {
+		if err := analyzeError(test.source); err == nil || err.Error() != test.err {
+			t.Errorf("expected the error %q, got %v", test.err, err)
+		}
+	}
+
+	// the constants take no slot, a nested function sees the constants of its ancestors
+	ast := (&WendParser{}).Parse(tokenize(`main() {
+    const int N = 3 * 4;
+    const long L = N;
+    int x;
+    int f() {
+        return N + 1;
+    }
+    x = f();
+}
+`)).(Function)
+	buildSymtable(ast)
+	if ast.deco["varCnt"] != 1 {
+		t.Errorf("expected 1 slot, got %v", ast.deco["varCnt"])
+	}
+	symbols := dumpSymbols(ast)
+	for _, s := range []string{"const N int value 12", "const L long value 12L"} {
+		if !strings.Contains(symbols, s) {
+			t.Errorf("the symbols lack %s:\n%s", s, symbols)
+		}
+	}
+	if ret := ast.fun[0].body[0].(Return).expr.(ArithOp); ret.left.(Integer).value != 12 {
+		t.Errorf("the constant is not replaced by its value: %#v", ret.left)
+	}
+
+	var out bytes.Buffer
+	if code := repl(strings.NewReader("const int K = 1 << 4;\nK * 2\nK = 1;\n"), &out, false); code != 0 {
+		t.Errorf("exit code %d", code)
+	}
+	if expected := "32\nerror: Cannot assign to the constant K, line 1\n"; out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
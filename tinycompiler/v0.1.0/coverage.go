@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Coverage: a 64-bit counter is incremented before every statement, at the entry of every function, at the start
+// of both branches of an if, at the start of a loop body, at the exit of a loop and at the start of every case of a
+// switch. The counters of all the objects are gathered by the linker in the "coverage" section, each one with the
+// text "path:line kind " that names it. At the exit the runtime writes the counters to the coverage file of the
+// program, one line per counter.
+// The kinds of the counters:
+//
+//	f  function entry
+//	s  statement
+//	t  then branch
+//	e  else branch
+//	b  loop body
+//	x  loop exit
+//	c  case of a switch, the default case included
+//	n  no case of a switch without a default case
+type coverPoint struct {
+	Label string
+	Text  string
+}
+
+func coverFileName(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".cov"
+}
+
+// attach the counters to the decorations, the root gets the list of the counters of the module
+func coverInstrument(root Function, path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	points := []coverPoint{}
+	point := func(deco map[string]any, key, kind string) {
+		label := newLabel() + "cov"
+		deco[key] = label
+		points = append(points, coverPoint{label, fmt.Sprintf("%s:%d %s ", escape.Replace(path), deco["lineno"].(int)+1, kind)})
+	}
+	var stats func(ss []Statement)
+	stats = func(ss []Statement) {
+		for _, s := range ss {
+			point(statDeco(s), "cover", "s")
+			switch e := s.(type) {
+			case IfThenElse:
+				point(e.deco, "cover_then", "t")
+				point(e.deco, "cover_else", "e")
+				stats(e.ibody)
+				stats(e.ebody)
+			case While:
+				point(e.deco, "cover_body", "b")
+				point(e.deco, "cover_exit", "x")
+				stats(e.body)
+			case Switch:
+				for _, c := range e.cases {
+					point(c.deco, "cover_case", "c")
+					stats(c.body)
+				}
+				if !e.hasDefault() {
+					point(e.deco, "cover_none", "n")
+				}
+			}
+		}
+	}
+	visitFuns(root, func(f Function) {
+		if f.deco["library"] != true {
+			point(f.deco, "cover", "f")
+		}
+		stats(f.body)
+	})
+	root.deco["coverage"] = points
+	if root.deco["library"] != true {
+		root.deco["coverfile"] = escape.Replace(coverFileName(path))
+	}
+}
+
+// increment the counter attached to the decoration under key, if any
+func coverasm(deco map[string]any, key string) string {
+	if label, ok := deco[key].(string); ok {
+		return fmt.Sprintf("\taddl $1, %s\n\tadcl $0, %s+4\n", label, label)
+	}
+	return ""
+}
+
+// a line of the coverage file
+type coverRecord struct {
+	path   string
+	lineno int
+	kind   string
+	count  int64
+}
+
+func readCoverage(name string) ([]coverRecord, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records := []coverRecord{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line) // the path may hold spaces, the line is split from the end
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("malformed coverage line %q", line)
+		}
+		count, err1 := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		location := strings.TrimSuffix(line, " "+fields[len(fields)-2]+" "+fields[len(fields)-1])
+		colon := strings.LastIndex(location, ":")
+		if err1 != nil || colon < 0 {
+			return nil, fmt.Errorf("malformed coverage line %q", line)
+		}
+		lineno, err2 := strconv.Atoi(location[colon+1:])
+		if err2 != nil {
+			return nil, fmt.Errorf("malformed coverage line %q", line)
+		}
+		records = append(records, coverRecord{location[:colon], lineno, fields[len(fields)-2], count})
+	}
+	return records, scanner.Err()
+}
+
+// the counters of a source line
+type coverLine struct {
+	stats    []int64 // statements and function entries
+	branches []string
+	missed   int // branches never taken
+}
+
+// render the coverage file of the program as an annotated listing of every source file, or as an HTML page
+func coverReport(path string, asHTML bool) (string, error) {
+	records, err := readCoverage(coverFileName(path))
+	if err != nil {
+		return "", err
+	}
+	files := map[string]map[int]*coverLine{}
+	for _, r := range records {
+		if files[r.path] == nil {
+			files[r.path] = map[int]*coverLine{}
+		}
+		line := files[r.path][r.lineno]
+		if line == nil {
+			line = &coverLine{}
+			files[r.path][r.lineno] = line
+		}
+		switch r.kind {
+		case "f", "s":
+			line.stats = append(line.stats, r.count)
+			continue
+		case "t":
+			line.branches = append(line.branches, fmt.Sprintf("then %d", r.count))
+		case "e":
+			line.branches = append(line.branches, fmt.Sprintf("else %d", r.count))
+		case "b":
+			line.branches = append(line.branches, fmt.Sprintf("body %d", r.count))
+		case "x":
+			line.branches = append(line.branches, fmt.Sprintf("exit %d", r.count))
+		case "c": // the line of a case holds no statement of its own
+			line.stats = append(line.stats, r.count)
+			line.branches = append(line.branches, fmt.Sprintf("case %d", r.count))
+		case "n":
+			line.branches = append(line.branches, fmt.Sprintf("no case %d", r.count))
+		}
+		if r.count == 0 {
+			line.missed++
+		}
+	}
+	paths := []string{}
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var out strings.Builder
+	if asHTML {
+		out.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Coverage</title><style>\n" +
+			".hit { background: #dfd; } .miss { background: #fdd; } .partial { background: #ffd; } .note { color: #888; }\n" +
+			"</style></head><body>\n")
+	}
+	linesTotal, linesHit, branchesTotal, branchesHit := 0, 0, 0, 0
+	for _, p := range paths {
+		source, err := os.ReadFile(p)
+		if err != nil {
+			return "", err
+		}
+		if asHTML {
+			fmt.Fprintf(&out, "<h2>%s</h2>\n<pre>\n", html.EscapeString(p))
+		} else {
+			fmt.Fprintf(&out, "%s\n", p)
+		}
+		for i, text := range strings.Split(strings.TrimSuffix(string(source), "\n"), "\n") {
+			count, class, note := "-", "", ""
+			if line := files[p][i+1]; line != nil {
+				linesTotal++
+				executed := int64(0)
+				for _, c := range line.stats {
+					executed = max(executed, c)
+				}
+				count, class = strconv.FormatInt(executed, 10), "hit"
+				if executed == 0 {
+					count, class = "#####", "miss"
+				} else {
+					linesHit++
+				}
+				if len(line.branches) > 0 {
+					branchesTotal += len(line.branches)
+					branchesHit += len(line.branches) - line.missed
+					note = strings.Join(line.branches, ", ")
+					if line.missed > 0 && executed > 0 {
+						class = "partial"
+					}
+				}
+			}
+			if asHTML {
+				if note != "" {
+					note = fmt.Sprintf(" <span class=\"note\">[%s]</span>", note)
+				}
+				fmt.Fprintf(&out, "<span class=\"%s\">%9s:%5d: %s</span>%s\n", class, count, i+1, html.EscapeString(text), note)
+				continue
+			}
+			fmt.Fprintf(&out, "%9s:%5d: %s\n", count, i+1, text)
+			if note != "" {
+				fmt.Fprintf(&out, "%9s %5s  [%s]\n", "", "", note)
+			}
+		}
+		if asHTML {
+			out.WriteString("</pre>\n")
+		}
+	}
+	percent := func(a, b int) float64 {
+		if b == 0 {
+			return 100
+		}
+		return float64(a) * 100 / float64(b)
+	}
+	summary := fmt.Sprintf("lines executed: %d of %d (%.1f%%), branches taken: %d of %d (%.1f%%)\n",
+		linesHit, linesTotal, percent(linesHit, linesTotal), branchesHit, branchesTotal, percent(branchesHit, branchesTotal))
+	if asHTML {
+		fmt.Fprintf(&out, "<p>%s</p>\n</body></html>\n", strings.TrimSuffix(summary, "\n"))
+	} else {
+		out.WriteString(summary)
+	}
+	return out.String(), nil
+}
+
+// print the coverage report of a program that was compiled with --coverage and run
+func coverFile(path string, asHTML bool) int {
+	report, err := coverReport(path, asHTML)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Print(report)
+	return 0
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	if _, err := exec.LookPath("as"); err != nil {
+		t.Skip("GNU as is not available")
+	}
+	source := `main() {
+    int i;
+
+    int sign(int x) {
+        if x < 0 {
+            return -1;
+        }
+        if x == 0 {
+            return 0;
+        }
+        return 1;
+    }
+
+    never() {
+        println 0;
+    }
+
+    i = 1;
+    while i < 4 {
+        print sign(i);
+        i = i + 1;
+    }
+    println sign(-i);
+}
+`
+	expected := `PATH
+        1:    1: main() {
+        -:    2:     int i;
+        -:    3: 
+        4:    4:     int sign(int x) {
+        4:    5:         if x < 0 {
+                 [then 1, else 3]
+        1:    6:             return -1;
+        -:    7:         }
+        3:    8:         if x == 0 {
+                 [then 0, else 3]
+    #####:    9:             return 0;
+        -:   10:         }
+        3:   11:         return 1;
+        -:   12:     }
+        -:   13: 
+    #####:   14:     never() {
+    #####:   15:         println 0;
+        -:   16:     }
+        -:   17: 
+        1:   18:     i = 1;
+        1:   19:     while i < 4 {
+                 [body 3, exit 1]
+        3:   20:         print sign(i);
+        3:   21:         i = i + 1;
+        -:   22:     }
+        1:   23:     println sign(-i);
+        -:   24: }
+lines executed: 11 of 14 (78.6%), branches taken: 5 of 6 (83.3%)
+`
+	dir := t.TempDir()
+	path := writeSource(t, dir, "cover", source)
+	exename, _, err := build(path, dir, buildOptions{coverage: true})
+	if err != nil {
+		t.Fatalf("build failed: %s", err)
+	}
+	out, err := exec.Command(exename).Output()
+	if err != nil {
+		t.Fatalf("fail to exec program: %s", err)
+	}
+	if string(out) != "111-1\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+	report, err := coverReport(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report = strings.Replace(report, path, "PATH", 1); report != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, report)
+	}
+	page, err := coverReport(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page, `<span class="partial">        3:    8:         if x == 0 {</span> <span class="note">[then 0, else 3]</span>`) {
+		t.Errorf("unexpected HTML report:\n%s", page)
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The debugger runs the program in the interpreter and takes control before every statement. A breakpoint stops
+// at the first statement of its line; step stops at the next statement, next does not stop inside the calls, finish
+// stops when the selected function has returned. The variables are resolved like the analyzer does, through a symbol
+// table holding the scopes of the enclosing functions, and their values are found by following the static links.
+// The commands are read line by line, so the debugger can be driven by a script; the end of the input quits.
+type debugger struct {
+	in       *bufio.Scanner
+	out      io.Writer
+	prompt   bool
+	path     string
+	root     Function
+	libs     map[string]Function
+	sources  map[string][]string // the lines of the source files, by path
+	files    map[string]string   // the source file of every function, by label
+	parents  map[string]Function // the enclosing function of every nested function, by label
+	first    map[uintptr]bool    // the statements that begin their line, by decoration
+	breaks   []*breakpoint
+	m        *machine
+	mode     string // how the program was resumed: continue, step, next or finish
+	depth    int    // the call depth when the program was resumed
+	frames   []*frame
+	selected int
+}
+
+type breakpoint struct {
+	id   int
+	file string
+	line int // counted from 1
+	hits int
+}
+
+// quit while the program runs
+type quitDebugger struct{}
+
+const debugHelp = `run              run the program from the start
+break [file:]N   stop at line N of the program, or of one of its libraries
+delete N         delete the breakpoint N
+info breakpoints list the breakpoints
+continue         resume until the next breakpoint
+step             stop at the next statement, entering the calls
+next             stop at the next statement of this function or of its callers
+finish           stop when the selected function has returned
+backtrace        list the frames with their static and dynamic links
+frame N, up, down  select a frame
+print name       print a visible variable of the selected frame
+locals           print the variables of the selected frame
+list [N]         print the source around the current line or line N
+quit             leave
+`
+
+func debugFile(path string, in io.Reader, out io.Writer, prompt bool) (code int) {
+	d := &debugger{in: bufio.NewScanner(in), out: out, prompt: prompt, path: filepath.Clean(path),
+		sources: map[string][]string{}, files: map[string]string{}, parents: map[string]Function{}, first: map[uintptr]bool{}}
+	if err := func() (err error) {
+		defer catch(&err, nil)
+		d.root, d.libs = loadProgram(path)
+		return nil
+	}(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return exitCompile
+	}
+	roots := map[string]Function{d.path: d.root}
+	for file, lib := range d.libs {
+		roots[file] = lib
+	}
+	for file, root := range roots {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		d.sources[file] = strings.Split(string(source), "\n")
+		lines := map[int]bool{}
+		visitFuns(root, func(f Function) {
+			d.files[f.deco["label"].(string)] = file
+			for _, g := range f.fun {
+				d.parents[g.deco["label"].(string)] = f
+			}
+			visitStats(f.body, func(s Statement) {
+				if lineno := statDeco(s)["lineno"].(int); !lines[lineno] {
+					lines[lineno] = true
+					d.first[reflect.ValueOf(statDeco(s)).Pointer()] = true
+				}
+			})
+		})
+	}
+
+	for {
+		command, ok := d.read()
+		if !ok {
+			return 0
+		}
+		switch name, _, _ := strings.Cut(command, " "); name {
+		case "run", "r":
+			if !d.run() {
+				return 0
+			}
+		case "quit", "q":
+			return 0
+		case "continue", "c", "step", "s", "next", "n", "finish":
+			fmt.Fprintln(d.out, "The program is not being run.")
+		default:
+			d.command(command)
+		}
+	}
+}
+
+func (d *debugger) read() (string, bool) {
+	for {
+		if d.prompt {
+			fmt.Fprint(d.out, "(wdb) ")
+		}
+		if !d.in.Scan() {
+			return "", false
+		}
+		if command := strings.TrimSpace(d.in.Text()); command != "" {
+			return command, true
+		}
+	}
+}
+
+// run the program from the start, false if the debugger was asked to quit
+func (d *debugger) run() bool {
+	d.m = newMachine(d.out)
+	d.m.step = d.stop
+	d.mode = "continue"
+	for _, b := range d.breaks {
+		b.hits = 0
+	}
+	quit := false
+	err := func() (err error) {
+		defer catch(&err, nil)
+		defer func() {
+			r := recover()
+			if _, quit = r.(quitDebugger); !quit && r != nil {
+				panic(r)
+			}
+		}()
+		d.m.run(d.root, d.libs)
+		return nil
+	}()
+	d.m, d.frames = nil, nil
+	if quit {
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(d.out, "[program terminated: %v]\n", err)
+	} else {
+		fmt.Fprintln(d.out, "[program exited]")
+	}
+	return true
+}
+
+// called by the interpreter before every statement
+func (d *debugger) stop(s Statement) {
+	deco := statDeco(s)
+	var hit *breakpoint
+	if d.first[reflect.ValueOf(deco).Pointer()] {
+		for _, b := range d.breaks {
+			if b.file == d.files[d.m.cur.fun.deco["label"].(string)] && b.line == deco["lineno"].(int)+1 {
+				hit = b
+			}
+		}
+	}
+	switch {
+	case hit != nil:
+		hit.hits++
+		fmt.Fprintf(d.out, "Breakpoint %d, ", hit.id)
+	case d.mode == "step":
+	case d.mode == "next" && d.m.depth <= d.depth:
+	case d.mode == "finish" && d.m.depth < d.depth:
+	default:
+		return
+	}
+	d.frames = nil
+	for fr := d.m.cur; fr != nil; fr = fr.caller {
+		d.frames = append(d.frames, fr)
+	}
+	d.selected = 0
+	fmt.Fprintf(d.out, "%s at %s\n", d.describe(d.m.cur), d.location(d.m.cur))
+	d.listLine(d.m.cur, deco["lineno"].(int)+1)
+
+	for {
+		command, ok := d.read()
+		if !ok {
+			panic(quitDebugger{})
+		}
+		switch name, _, _ := strings.Cut(command, " "); name {
+		case "continue", "c":
+			d.mode = "continue"
+			return
+		case "step", "s":
+			d.mode = "step"
+			return
+		case "next", "n":
+			d.mode, d.depth = "next", d.m.depth
+			return
+		case "finish":
+			if d.frames[d.selected].caller == nil {
+				fmt.Fprintln(d.out, `"finish" not meaningful in the outermost frame.`)
+				continue
+			}
+			fmt.Fprintf(d.out, "Run till exit from #%d %s\n", d.selected, d.describe(d.frames[d.selected]))
+			d.mode, d.depth = "finish", d.m.depth-d.selected
+			return
+		case "run", "r":
+			fmt.Fprintln(d.out, "The program is already running.")
+		case "quit", "q":
+			panic(quitDebugger{})
+		default:
+			d.command(command)
+		}
+	}
+}
+
+// the commands that do not resume the program
+func (d *debugger) command(command string) {
+	name, arg, _ := strings.Cut(command, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "break", "b":
+		d.addBreak(arg)
+	case "delete", "d":
+		for i, b := range d.breaks {
+			if strconv.Itoa(b.id) == arg {
+				d.breaks = append(d.breaks[:i], d.breaks[i+1:]...)
+				return
+			}
+		}
+		fmt.Fprintf(d.out, "No breakpoint number %s.\n", arg)
+	case "info":
+		if arg == "locals" {
+			d.command("locals")
+			return
+		}
+		if len(d.breaks) == 0 {
+			fmt.Fprintln(d.out, "No breakpoints.")
+		}
+		for _, b := range d.breaks {
+			fmt.Fprintf(d.out, "%d\tat %s:%d, hit %d times\n", b.id, filepath.Base(b.file), b.line, b.hits)
+		}
+	case "list", "l":
+		if line, err := strconv.Atoi(arg); err == nil {
+			d.list(d.path, line)
+		} else if d.frames != nil {
+			fr := d.frames[d.selected]
+			d.list(d.files[fr.fun.deco["label"].(string)], fr.lineno+1)
+		} else {
+			d.list(d.path, d.root.deco["lineno"].(int)+1)
+		}
+	case "help", "h":
+		fmt.Fprint(d.out, debugHelp)
+	case "backtrace", "bt", "where":
+		d.needFrames(func() {
+			for i, fr := range d.frames {
+				fmt.Fprintf(d.out, "#%-3d%s at %s%s\n", i, d.describe(fr), d.location(fr), d.links(fr))
+			}
+		})
+	case "frame", "f", "up", "down":
+		d.needFrames(func() {
+			n := d.selected
+			switch name {
+			case "up":
+				n++
+			case "down":
+				n--
+			default:
+				var err error
+				if n, err = strconv.Atoi(arg); err != nil && arg != "" {
+					n = -1
+				} else if arg == "" {
+					n = d.selected
+				}
+			}
+			if n < 0 || n >= len(d.frames) {
+				fmt.Fprintln(d.out, "No such frame.")
+				return
+			}
+			d.selected = n
+			fmt.Fprintf(d.out, "#%-3d%s at %s\n", n, d.describe(d.frames[n]), d.location(d.frames[n]))
+		})
+	case "print", "p":
+		d.needFrames(func() {
+			if v, ok := d.lookup(d.frames[d.selected], arg); ok {
+				fmt.Fprintf(d.out, "%s = %s\n", arg, valueString(v))
+			} else {
+				fmt.Fprintf(d.out, "No symbol \"%s\" in the current context.\n", arg)
+			}
+		})
+	case "locals":
+		d.needFrames(func() {
+			fr := d.frames[d.selected]
+			for _, v := range append(append([]Var{}, fr.fun.args...), fr.fun.vars...) {
+				fmt.Fprintf(d.out, "%s = %s\n", v.name, valueString(fr.slots[v.deco["offset"].(int)]))
+			}
+		})
+	default:
+		fmt.Fprintf(d.out, "Undefined command: \"%s\". Try \"help\".\n", name)
+	}
+}
+
+func (d *debugger) needFrames(f func()) {
+	if d.frames == nil {
+		fmt.Fprintln(d.out, "No stack.")
+		return
+	}
+	f()
+}
+
+func (d *debugger) addBreak(arg string) {
+	file, line := d.path, arg
+	if colon := strings.LastIndex(arg, ":"); colon >= 0 {
+		file, line = "", arg[colon+1:]
+		for path := range d.sources {
+			if path == filepath.Clean(arg[:colon]) || filepath.Base(path) == arg[:colon] {
+				file = path
+			}
+		}
+		if file == "" {
+			fmt.Fprintf(d.out, "No source file named %s.\n", arg[:colon])
+			return
+		}
+	}
+	n, err := strconv.Atoi(line)
+	if err != nil || n < 1 || n > len(d.sources[file]) {
+		fmt.Fprintf(d.out, "Bad line number %q.\n", line)
+		return
+	}
+	id := 1
+	if len(d.breaks) > 0 {
+		id = d.breaks[len(d.breaks)-1].id + 1
+	}
+	d.breaks = append(d.breaks, &breakpoint{id: id, file: file, line: n})
+	fmt.Fprintf(d.out, "Breakpoint %d at %s:%d\n", id, filepath.Base(file), n)
+}
+
+// the name of the function of the frame with the values of its arguments
+func (d *debugger) describe(fr *frame) string {
+	args := []string{}
+	for _, arg := range fr.fun.args {
+		args = append(args, arg.name+"="+valueString(fr.slots[arg.deco["offset"].(int)]))
+	}
+	return fmt.Sprintf("%s(%s)", fr.fun.name, strings.Join(args, ", "))
+}
+
+func (d *debugger) location(fr *frame) string {
+	return fmt.Sprintf("%s:%d", filepath.Base(d.files[fr.fun.deco["label"].(string)]), fr.lineno+1)
+}
+
+// the static link points to the frame of the enclosing function, the dynamic link to the frame of the caller
+func (d *debugger) links(fr *frame) string {
+	index := func(link *frame) string {
+		for i, f := range d.frames {
+			if f == link {
+				return fmt.Sprintf("#%d", i)
+			}
+		}
+		return "none"
+	}
+	if fr.caller == nil {
+		return ""
+	}
+	static := "none"
+	if fr.static != nil {
+		static = index(fr.static) + " " + fr.static.fun.name
+	}
+	return fmt.Sprintf(", static link %s, dynamic link %s %s", static, index(fr.caller), fr.caller.fun.name)
+}
+
+// resolve the name in the scopes of the function of the frame and of the enclosing functions, the frame of
+// the function declaring the variable is reached through the static links
+func (d *debugger) lookup(fr *frame, name string) (any, bool) {
+	scopes := []Function{}
+	for f, ok := fr.fun, true; ok && f.deco["library"] != true; f, ok = d.parents[f.deco["label"].(string)] {
+		scopes = append([]Function{f}, scopes...)
+	}
+	symtable := newSymbolTable()
+	for _, f := range scopes {
+		vars := map[string]map[string]any{}
+		for _, v := range append(append([]Var{}, f.args...), f.vars...) {
+			vars[v.name] = v.deco
+		}
+		symtable.variables = append(symtable.variables, vars)
+	}
+	deco, ok := symtable.lookupVar(name)
+	if !ok {
+		return nil, false
+	}
+	for fr != nil && fr.fun.deco["level"] != deco["level"] {
+		fr = fr.static
+	}
+	if fr == nil {
+		return nil, false
+	}
+	return fr.slots[deco["offset"].(int)], true
+}
+
+func (d *debugger) listLine(fr *frame, line int) {
+	if lines := d.sources[d.files[fr.fun.deco["label"].(string)]]; line <= len(lines) {
+		fmt.Fprintf(d.out, "%d\t%s\n", line, lines[line-1])
+	}
+}
+
+func (d *debugger) list(file string, line int) {
+	lines := d.sources[file]
+	for i := max(1, line-5); i <= min(len(lines), line+4); i++ {
+		fmt.Fprintf(d.out, "%d\t%s\n", i, lines[i-1])
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebug(t *testing.T) {
+	source := `main() {
+    int total;
+
+    sum(int n) {
+        int acc;
+
+        add(int k) {
+            if k > 0 {
+                add(k - 1);
+                acc = acc + k;
+                total = total + k;
+            }
+        }
+
+        acc = 0;
+        add(n);
+        println acc;
+    }
+
+    total = 100;
+    sum(2);
+    println total;
+}
+`
+	script := `break 10
+break 30
+run
+bt
+print acc
+up
+print k
+print n
+print nope
+step
+next
+finish
+info locals
+next
+info breakpoints
+continue
+`
+	expected := `Breakpoint 1 at prog.wend:10
+Bad line number "30".
+Breakpoint 1, add(k=1) at prog.wend:10
+10	                acc = acc + k;
+#0  add(k=1) at prog.wend:10, static link #2 sum, dynamic link #1 add
+#1  add(k=2) at prog.wend:9, static link #2 sum, dynamic link #2 sum
+#2  sum(n=2) at prog.wend:16, static link #3 main, dynamic link #3 main
+#3  main() at prog.wend:21
+acc = 0
+#1  add(k=2) at prog.wend:9
+k = 2
+n = 2
+No symbol "nope" in the current context.
+add(k=1) at prog.wend:11
+11	                total = total + k;
+Breakpoint 1, add(k=2) at prog.wend:10
+10	                acc = acc + k;
+Run till exit from #0 add(k=2)
+sum(n=2) at prog.wend:17
+17	        println acc;
+n = 2
+acc = 3
+3
+main() at prog.wend:22
+22	    println total;
+1	at prog.wend:10, hit 2 times
+103
+[program exited]
+`
+	path := filepath.Join(t.TempDir(), "prog.wend")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if code := debugFile(path, strings.NewReader(script), &out, false); code != 0 {
+		t.Errorf("exit code %d", code)
+	}
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
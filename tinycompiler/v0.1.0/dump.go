@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Dumps of the decorated syntax tree for external tools. The lexer tracks lines only, so the span of a node
+// is the range of the lines of the node and its descendants, up to the closing brace of a block, counted from 1.
+// The decorations referring to other nodes are replaced by a name: the called function by its label, the closure
+// of an indirect call by its variable.
+type astNode struct {
+	Kind    string         `json:"kind"`
+	Name    string         `json:"name,omitempty"`
+	Op      string         `json:"op,omitempty"`
+	Value   any            `json:"value,omitempty"`
+	Newline *bool          `json:"newline,omitempty"`
+	Span    [2]int         `json:"span"`
+	Deco    map[string]any `json:"deco,omitempty"`
+	Args    []*astNode     `json:"args,omitempty"`
+	Vars    []*astNode     `json:"vars,omitempty"`
+	Fun     []*astNode     `json:"fun,omitempty"`
+	Body    []*astNode     `json:"body,omitempty"`
+	Else    []*astNode     `json:"else,omitempty"`
+	Cases   []*astNode     `json:"cases,omitempty"`
+	Values  []*astNode     `json:"values,omitempty"`
+	Expr    *astNode       `json:"expr,omitempty"`
+	Left    *astNode       `json:"left,omitempty"`
+	Right   *astNode       `json:"right,omitempty"`
+}
+
+func decoValues(deco map[string]any) map[string]any {
+	out := map[string]any{}
+	for k, v := range deco {
+		switch v := v.(type) {
+		case Type:
+			out[k] = v.String()
+		case []Type:
+			types := []string{}
+			for _, t := range v {
+				types = append(types, t.String())
+			}
+			out[k] = types
+		case int, int64, bool, string, []int, []string, map[string]string:
+			if k != "lineno" && k != "endline" { // in the span
+				out[k] = v
+			}
+		case map[string]any:
+			if k == "fundeco" {
+				out["callee"] = v["label"]
+			} else if k == "tail" {
+				out[k] = true
+			}
+		case Var:
+			out[k] = v.name
+		}
+	}
+	return out
+}
+
+func newAstNode(kind string, deco map[string]any) *astNode {
+	n := &astNode{Kind: kind, Deco: decoValues(deco)}
+	if lineno, ok := deco["lineno"].(int); ok {
+		n.Span = [2]int{lineno + 1, lineno + 1}
+	}
+	if endline, ok := deco["endline"].(int); ok { // the closing brace of a block
+		n.Span[1] = endline + 1
+	}
+	return n
+}
+
+// extend the span of the node to its children
+func (n *astNode) add(children ...*astNode) []*astNode {
+	for _, c := range children {
+		if c.Span[0] == 0 {
+			continue
+		}
+		if n.Span[0] == 0 || c.Span[0] < n.Span[0] {
+			n.Span[0] = c.Span[0]
+		}
+		n.Span[1] = max(n.Span[1], c.Span[1])
+	}
+	return children
+}
+
+func funNode(f Function) *astNode {
+	n := newAstNode("Function", f.deco)
+	n.Name = f.name
+	for _, v := range f.args {
+		n.Args = append(n.Args, n.add(exprNode(v))...)
+	}
+	for _, v := range f.vars {
+		n.Vars = append(n.Vars, n.add(exprNode(v))...)
+	}
+	for _, g := range f.fun {
+		n.Fun = append(n.Fun, n.add(funNode(g))...)
+	}
+	n.Body = n.add(statNodes(f.body)...)
+	return n
+}
+
+func statNodes(ss []Statement) []*astNode {
+	nodes := []*astNode{}
+	for _, s := range ss {
+		nodes = append(nodes, statNode(s))
+	}
+	return nodes
+}
+
+func statNode(s Statement) *astNode {
+	switch e := s.(type) {
+	case Print:
+		n := newAstNode("Print", e.deco)
+		n.Newline = &e.newline
+		n.Expr = n.add(exprNode(e.expr))[0]
+		return n
+	case Return:
+		n := newAstNode("Return", e.deco)
+		if e.expr != nil {
+			n.Expr = n.add(exprNode(e.expr))[0]
+		}
+		return n
+	case Assign:
+		n := newAstNode("Assign", e.deco)
+		n.Name = e.name
+		n.Expr = n.add(exprNode(e.expr))[0]
+		return n
+	case Store:
+		n := newAstNode("Store", e.deco)
+		n.Left, n.Right = n.add(exprNode(e.array))[0], n.add(exprNode(e.index))[0]
+		n.Expr = n.add(exprNode(e.expr))[0]
+		return n
+	case While:
+		n := newAstNode("While", e.deco)
+		n.Expr = n.add(exprNode(e.expr))[0]
+		n.Body = n.add(statNodes(e.body)...)
+		return n
+	case IfThenElse:
+		n := newAstNode("IfThenElse", e.deco)
+		n.Expr = n.add(exprNode(e.expr))[0]
+		n.Body = n.add(statNodes(e.ibody)...)
+		n.Else = n.add(statNodes(e.ebody)...)
+		return n
+	case Switch:
+		n := newAstNode("Switch", e.deco)
+		n.Expr = n.add(exprNode(e.expr))[0]
+		for _, c := range e.cases {
+			cn := newAstNode("Case", c.deco)
+			for _, v := range c.values {
+				cn.Values = append(cn.Values, cn.add(exprNode(v))...)
+			}
+			cn.Body = cn.add(statNodes(c.body)...)
+			n.Cases = append(n.Cases, n.add(cn)...)
+		}
+		return n
+	case FunCall, Inline:
+		return exprNode(e.(Expression))
+	}
+	panic(fmt.Sprint("Unknown statement type", s))
+}
+
+func exprNode(e Expression) *astNode {
+	switch e := e.(type) {
+	case ArithOp:
+		n := newAstNode("ArithOp", e.deco)
+		n.Op, n.Left, n.Right = e.op, n.add(exprNode(e.left))[0], n.add(exprNode(e.right))[0]
+		return n
+	case LogicOp:
+		n := newAstNode("LogicOp", e.deco)
+		n.Op, n.Left, n.Right = e.op, n.add(exprNode(e.left))[0], n.add(exprNode(e.right))[0]
+		return n
+	case Integer:
+		n := newAstNode("Integer", e.deco)
+		n.Value = e.value
+		return n
+	case Float:
+		n := newAstNode("Float", e.deco)
+		n.Value = e.value
+		return n
+	case Boolean:
+		n := newAstNode("Boolean", e.deco)
+		n.Value = e.value
+		return n
+	case String:
+		n := newAstNode("String", e.deco)
+		n.Value = e.value
+		return n
+	case Var:
+		n := newAstNode("Var", e.deco)
+		n.Name = e.name
+		return n
+	case Convert:
+		n := newAstNode("Convert", e.deco)
+		n.Expr = n.add(exprNode(e.expr))[0]
+		return n
+	case New:
+		n := newAstNode("New", e.deco)
+		n.Expr = n.add(exprNode(e.size))[0]
+		return n
+	case Index:
+		n := newAstNode("Index", e.deco)
+		n.Left, n.Right = n.add(exprNode(e.array))[0], n.add(exprNode(e.index))[0]
+		return n
+	case FunRef:
+		n := newAstNode("FunRef", e.deco)
+		n.Name = e.name
+		return n
+	case FunCall:
+		n := newAstNode("FunCall", e.deco)
+		n.Name = e.name
+		for _, arg := range e.args {
+			n.Args = append(n.Args, n.add(exprNode(arg))...)
+		}
+		return n
+	case Inline:
+		n := newAstNode("Inline", e.deco)
+		n.Body = n.add(statNodes(e.body)...)
+		if e.expr != nil {
+			n.Expr = n.add(exprNode(e.expr))[0]
+		}
+		return n
+	}
+	panic(fmt.Sprint("Unknown expression type", e))
+}
+
+func dumpJSON(f Function) string {
+	out, err := json.MarshalIndent(funNode(f), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return string(out) + "\n"
+}
+
+// one box per node with its kind, lines and main decorations, the edges are labeled with the role of the child
+func dumpDOT(f Function) string {
+	var out strings.Builder
+	out.WriteString("digraph ast {\n\tnode [shape=box, fontname=\"monospace\"];\n")
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	count := 0
+	var node func(n *astNode) int
+	node = func(n *astNode) int {
+		id := count
+		count++
+		label := n.Kind
+		for _, s := range []string{n.Name, n.Op} {
+			if s != "" {
+				label += " " + s
+			}
+		}
+		if n.Value != nil {
+			label += fmt.Sprintf(" %v", n.Value)
+		}
+		label += fmt.Sprintf("\nlines %d-%d", n.Span[0], n.Span[1])
+		for _, key := range []string{"type", "scope", "level", "offset", "label", "callee"} {
+			if v, ok := n.Deco[key]; ok {
+				label += fmt.Sprintf("\n%s: %v", key, v)
+			}
+		}
+		fmt.Fprintf(&out, "\tn%d [label=\"%s\"];\n", id, quote.Replace(label))
+		edges := func(role string, children ...*astNode) {
+			for _, c := range children {
+				if c != nil {
+					fmt.Fprintf(&out, "\tn%d -> n%d [label=\"%s\"];\n", id, node(c), role)
+				}
+			}
+		}
+		edges("arg", n.Args...)
+		edges("var", n.Vars...)
+		edges("fun", n.Fun...)
+		edges("expr", n.Expr)
+		edges("left", n.Left)
+		edges("right", n.Right)
+		edges("body", n.Body...)
+		edges("else", n.Else...)
+		edges("case", n.Cases...)
+		edges("value", n.Values...)
+		return id
+	}
+	node(funNode(f))
+	out.WriteString("}\n")
+	return out.String()
+}
+
+// the signature of a function as written in the source, e.g. f(int, bool): float
+func signatureOf(name string, deco map[string]any) string {
+	args := []string{}
+	for _, t := range deco["argtypes"].([]Type) {
+		args = append(args, strings.ToLower(t.String()))
+	}
+	s := fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+	if t := deco["type"].(Type); t != VOID {
+		s += ": " + strings.ToLower(t.String())
+	}
+	return s
+}
+
+// every scope with its variables and the functions declared in it, the overloads are listed one per signature
+func dumpSymbols(root Function) string {
+	var out strings.Builder
+	signature := func(f Function) string { return signatureOf(f.name, f.deco) }
+	for _, kind := range [][2]string{{"imported", "import"}, {"externs", "extern"}} {
+		funs, _ := root.deco[kind[0]].([]Function)
+		funs = append([]Function{}, funs...)
+		sort.SliceStable(funs, func(i, j int) bool { return funs[i].name < funs[j].name })
+		for _, f := range funs {
+			fmt.Fprintf(&out, "%s %s label %s\n", kind[1], signature(f), f.deco["label"])
+		}
+	}
+	visitFuns(root, func(f Function) {
+		name := signature(f)
+		if f.deco["library"] == true {
+			name = "module " + f.deco["module"].(string)
+		}
+		fmt.Fprintf(&out, "scope %d %s level %d line %d\n", f.deco["scope"], name, f.deco["level"], f.deco["lineno"].(int)+1)
+		for _, kind := range []string{"arg", "var"} {
+			vars := f.args
+			if kind == "var" {
+				vars = f.vars
+			}
+			for _, v := range vars {
+				fmt.Fprintf(&out, "    %s %s %s offset %d\n", kind, v.name, strings.ToLower(v.deco["type"].(Type).String()), v.deco["offset"])
+			}
+		}
+		consts, _ := f.deco["consts"].([]Var)
+		for _, c := range consts {
+			fmt.Fprintf(&out, "    const %s %s value %s\n", c.name, strings.ToLower(c.deco["type"].(Type).String()), formatExpr(c.deco["value"].(Expression)))
+		}
+		for _, g := range f.fun {
+			fmt.Fprintf(&out, "    fun %s label %s line %d\n", signature(g), g.deco["label"], g.deco["lineno"].(int)+1)
+		}
+	})
+	return out.String()
+}
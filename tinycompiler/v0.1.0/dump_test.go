@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	source := `main() {
+    int x;
+    long y;
+
+    int twice(int a) {
+        return a * 2;
+    }
+
+    int twice(long a) {
+        return int(a) * 2;
+    }
+
+    x = twice(3);
+    y = 4L;
+    if x > 5 {
+        println twice(y);
+    }
+}
+`
+	ast := (&WendParser{}).Parse(tokenize(source)).(Function)
+	buildSymtable(ast)
+
+	symbols := `scope 0 main() level 0 line 1
+    var x int offset 0
+    var y long offset 1
+    fun twice(int): int label twice#1 line 5
+    fun twice(long): int label twice#2 line 9
+scope 3 twice(int): int level 1 line 5
+    arg a int offset 0
+scope 4 twice(long): int level 1 line 9
+    arg a long offset 0
+`
+	got := dumpSymbols(ast)
+	for i, f := range ast.fun { // the labels depend on the tests run before
+		got = strings.Replace(got, f.deco["label"].(string), fmt.Sprintf("%s#%d", f.name, i+1), 1)
+	}
+	if got != symbols {
+		t.Errorf("expected:\n%s\ngot:\n%s", symbols, got)
+	}
+
+	var root map[string]any
+	if err := json.Unmarshal([]byte(dumpJSON(ast)), &root); err != nil {
+		t.Fatal(err)
+	}
+	if root["kind"] != "Function" || root["span"].([]any)[0] != 1.0 || root["span"].([]any)[1] != 18.0 {
+		t.Errorf("unexpected root %v %v", root["kind"], root["span"])
+	}
+	if twice := root["fun"].([]any)[0].(map[string]any); fmt.Sprint(twice["span"]) != "[5 7]" { // up to the closing brace
+		t.Errorf("unexpected span of twice %v", twice["span"])
+	}
+	body := root["body"].([]any)
+	assign := body[0].(map[string]any)
+	call := assign["expr"].(map[string]any)
+	if assign["kind"] != "Assign" || call["kind"] != "FunCall" || call["deco"].(map[string]any)["callee"] != ast.fun[0].deco["label"] {
+		t.Errorf("unexpected assignment %v", assign)
+	}
+	branch := body[2].(map[string]any)
+	if branch["kind"] != "IfThenElse" || branch["span"].([]any)[1] != 17.0 || branch["expr"].(map[string]any)["op"] != ">" {
+		t.Errorf("unexpected if %v", branch)
+	}
+
+	dot := dumpDOT(ast)
+	for _, s := range []string{"digraph ast {", `n0 [label="Function main\nlines 1-18\ntype: VOID`, `n0 -> n1 [label="var"];`,
+		`[label="FunCall twice\nlines 16-16\ntype: INT\nscope: 4\nlevel: 1\nlabel: `} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("%q not found in\n%s", s, dot)
+		}
+	}
+}
@@ -0,0 +1,722 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The fuzzer generates random well-typed programs, runs every one of them with all the backends available and
+// compares the outputs. A generated program has a defined behaviour on every backend: the local variables are assigned
+// at the top of their function, a loop runs at most a few times on a counter that no other statement assigns,
+// a function calls only the functions generated before it so there is no recursion, its parent calls it at least
+// once so that it is compiled, the divisors are odd and positive, the shift counts are masked, and a float is
+// printed only once converted to a long. No function hides a function of an enclosing scope, so every call goes to
+// the function the generator has chosen.
+type fuzzVar struct {
+	name       string
+	typ        Type
+	assignable bool // the loop counters are not
+}
+
+type fuzzFun struct {
+	name     string
+	argtypes []Type
+	ret      Type
+	called   bool
+}
+
+type fuzzScope struct {
+	vars       []fuzzVar
+	funs       []*fuzzFun      // the functions that may be called, i.e. the complete ones
+	signatures map[string]bool // all the functions declared in the scope
+}
+
+type generator struct {
+	rnd    *rand.Rand
+	scopes []*fuzzScope
+	locals *[]Var // the variables of the function being generated, the loop counters are added to them
+	names  int    // the variables are numbered across the program
+	budget int    // the number of statements left
+}
+
+var fuzzFunNames = []string{"f", "g", "h", "k"}
+
+var fuzzInts = []int{0, 1, 2, 3, 5, 7, 10, 100, 255, 256, 46340, 46341, 65535, 65536, 1 << 30, math.MaxInt32,
+	math.MinInt32, -1, -2, -255}
+
+var fuzzLongs = []int{0, 1, 2, 3, 7, 255, math.MaxInt32, 1 << 31, 1<<32 - 1, 1 << 32, 3037000499, 3037000500,
+	math.MaxInt64, math.MinInt64, -1, -2, -(1 << 32)}
+
+var fuzzFloats = []float64{0, 0.1, 0.5, 1, 1.5, 2, 3.25, 100, 1000000, 123.456}
+
+func generateProgram(seed int64) Function {
+	g := &generator{rnd: rand.New(rand.NewSource(seed)), budget: 60}
+	g.scopes = []*fuzzScope{{signatures: map[string]bool{Signature{"main", []Type{}}.String(): true}}}
+	return g.function("main", []Type{}, VOID, 0)
+}
+
+func (g *generator) valueType() Type {
+	return []Type{INT, INT, LONG, BOOL, FLOAT}[g.rnd.Intn(5)]
+}
+
+func (g *generator) newName(prefix string) string {
+	g.names++
+	return fmt.Sprintf("%s%d", prefix, g.names)
+}
+
+func (g *generator) visible(signature string) bool {
+	for _, scope := range g.scopes {
+		if scope.signatures[signature] {
+			return true
+		}
+	}
+	return false
+}
+
+// a name for a new function of the current scope: an overload of a name in use if possible, never hiding a function
+func (g *generator) funName(argtypes []Type) string {
+	for range 4 {
+		name := fuzzFunNames[g.rnd.Intn(len(fuzzFunNames))]
+		if !g.visible(Signature{name, argtypes}.String()) {
+			return name
+		}
+	}
+	return g.newName("f")
+}
+
+func (g *generator) function(name string, argtypes []Type, ret Type, depth int) Function {
+	scope := &fuzzScope{signatures: map[string]bool{}}
+	args, vars := []Var{}, []Var{}
+	for _, t := range argtypes {
+		arg := Var{g.newName("a"), map[string]any{"type": t}}
+		args = append(args, arg)
+		scope.vars = append(scope.vars, fuzzVar{arg.name, t, true})
+	}
+	for range g.rnd.Intn(4) {
+		v := Var{g.newName("v"), map[string]any{"type": g.valueType()}}
+		vars = append(vars, v)
+		scope.vars = append(scope.vars, fuzzVar{v.name, v.deco["type"].(Type), true})
+	}
+	g.scopes = append(g.scopes, scope)
+	saved := g.locals
+	g.locals = &vars
+
+	funs := []Function{}
+	if depth < 3 {
+		for range g.rnd.Intn(3) {
+			argtypes := []Type{}
+			for range g.rnd.Intn(3) {
+				argtypes = append(argtypes, g.valueType())
+			}
+			ret := VOID
+			if g.rnd.Intn(3) > 0 {
+				ret = g.valueType()
+			}
+			name := g.funName(argtypes)
+			scope.signatures[Signature{name, argtypes}.String()] = true
+			funs = append(funs, g.function(name, argtypes, ret, depth+1))
+			scope.funs = append(scope.funs, &fuzzFun{name, argtypes, ret, false})
+		}
+	}
+
+	body := g.block(ret, 0)
+	for _, f := range scope.funs { // a function never called would not be compiled, nor tested
+		if !f.called {
+			body = append(body, g.callStat(f))
+		}
+	}
+	if ret != VOID {
+		body = append(body, Return{g.expr(ret, 0), map[string]any{}})
+	}
+	init := []Statement{}
+	for _, v := range vars {
+		init = append(init, Assign{v.name, g.literal(v.deco["type"].(Type)), map[string]any{"init": true}})
+	}
+	g.locals = saved
+	g.scopes = g.scopes[:len(g.scopes)-1]
+	return Function{name, args, vars, funs, append(init, body...), map[string]any{"type": ret}}
+}
+
+func (g *generator) block(ret Type, depth int) []Statement {
+	ss := []Statement{}
+	for range 1 + g.rnd.Intn(4) {
+		if g.budget <= 0 {
+			break
+		}
+		g.budget--
+		ss = append(ss, g.statement(ret, depth)...)
+	}
+	return ss
+}
+
+func (g *generator) statement(ret Type, depth int) []Statement {
+	switch g.rnd.Intn(10) {
+	case 0, 1, 2:
+		return []Statement{g.print()}
+	case 3, 4, 5:
+		vars := g.vars(func(v fuzzVar) bool { return v.assignable })
+		if len(vars) == 0 {
+			return []Statement{g.print()}
+		}
+		v := vars[g.rnd.Intn(len(vars))]
+		return []Statement{Assign{v.name, g.expr(v.typ, 0), map[string]any{}}}
+	case 6:
+		if depth < 3 && g.rnd.Intn(3) == 0 {
+			return []Statement{g.switchStat(ret, depth)}
+		}
+		if depth < 3 {
+			var ebody []Statement
+			if g.rnd.Intn(2) == 0 {
+				ebody = g.block(ret, depth+1)
+			}
+			return []Statement{IfThenElse{g.expr(BOOL, 0), g.block(ret, depth+1), ebody, map[string]any{}}}
+		}
+	case 7:
+		if depth < 3 {
+			counter := g.newName("i")
+			*g.locals = append(*g.locals, Var{counter, map[string]any{"type": INT}})
+			scope := g.scopes[len(g.scopes)-1]
+			scope.vars = append(scope.vars, fuzzVar{counter, INT, false})
+			count := Var{counter, map[string]any{"type": INT}}
+			step := Assign{counter, ArithOp{"+", count, Integer{1, map[string]any{"type": INT}}, map[string]any{"type": INT}},
+				map[string]any{"step": true}}
+			limit := Integer{1 + g.rnd.Intn(4), map[string]any{"type": INT}}
+			return []Statement{
+				Assign{counter, Integer{0, map[string]any{"type": INT}}, map[string]any{}},
+				While{LogicOp{"<", count, limit, map[string]any{"type": BOOL}}, append(g.block(ret, depth+1), step), map[string]any{}},
+			}
+		}
+	case 8:
+		if call, ok := g.call(VOID, 0); ok {
+			return []Statement{call}
+		}
+	case 9:
+		if depth > 0 { // an early return
+			var expr Expression
+			if ret != VOID {
+				expr = g.expr(ret, 0)
+			}
+			return []Statement{Return{expr, map[string]any{}}}
+		}
+	}
+	return []Statement{g.print()}
+}
+
+// the value is masked so that the cases are hit, the values are dense or sparse to get both lowerings
+func (g *generator) switchStat(ret Type, depth int) Statement {
+	mask := []int{3, 7, 63}[g.rnd.Intn(3)]
+	value := ArithOp{"&", g.expr(INT, 0), Integer{mask, map[string]any{"type": INT}}, map[string]any{"type": INT}}
+	cases := []Case{}
+	seen := map[int]bool{}
+	for range 1 + g.rnd.Intn(5) {
+		values := []Expression{}
+		for range 1 + g.rnd.Intn(2) {
+			if v := g.rnd.Intn(mask + 1); !seen[v] {
+				seen[v] = true
+				values = append(values, Integer{v, map[string]any{"type": INT}})
+			}
+		}
+		if len(values) > 0 {
+			cases = append(cases, Case{values, g.block(ret, depth+1), map[string]any{}})
+		}
+	}
+	if g.rnd.Intn(2) == 0 {
+		cases = append(cases, Case{nil, g.block(ret, depth+1), map[string]any{}})
+	}
+	return Switch{value, cases, map[string]any{}}
+}
+
+func (g *generator) print() Statement {
+	t := g.valueType()
+	expr := g.expr(t, 0)
+	if t == FLOAT {
+		expr = Convert{expr, map[string]any{"type": LONG}}
+	}
+	return Print{expr, g.rnd.Intn(4) > 0, map[string]any{}}
+}
+
+func (g *generator) vars(accept func(fuzzVar) bool) []fuzzVar {
+	result := []fuzzVar{}
+	for _, scope := range g.scopes {
+		for _, v := range scope.vars {
+			if accept(v) {
+				result = append(result, v)
+			}
+		}
+	}
+	return result
+}
+
+// a call of a function returning the type, the arguments are generated at the next depth
+func (g *generator) call(t Type, depth int) (FunCall, bool) {
+	candidates := []*fuzzFun{}
+	for _, scope := range g.scopes {
+		for _, f := range scope.funs {
+			if f.ret == t {
+				candidates = append(candidates, f)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return FunCall{}, false
+	}
+	return g.callOf(candidates[g.rnd.Intn(len(candidates))], depth), true
+}
+
+func (g *generator) callOf(f *fuzzFun, depth int) FunCall {
+	f.called = true
+	args := []Expression{}
+	for _, at := range f.argtypes {
+		args = append(args, g.expr(at, depth+1))
+	}
+	return FunCall{f.name, args, map[string]any{"type": f.ret}}
+}
+
+// a statement calling the function, the value returned is printed
+func (g *generator) callStat(f *fuzzFun) Statement {
+	call := g.callOf(f, 0)
+	switch f.ret {
+	case VOID:
+		return call
+	case FLOAT:
+		return Print{Convert{call, map[string]any{"type": LONG}}, true, map[string]any{}}
+	}
+	return Print{call, true, map[string]any{}}
+}
+
+func (g *generator) literal(t Type) Expression {
+	switch t {
+	case INT:
+		if g.rnd.Intn(3) == 0 {
+			return Integer{g.rnd.Intn(1000), map[string]any{"type": INT}}
+		}
+		return Integer{fuzzInts[g.rnd.Intn(len(fuzzInts))], map[string]any{"type": INT}}
+	case LONG:
+		return Integer{fuzzLongs[g.rnd.Intn(len(fuzzLongs))], map[string]any{"type": LONG}}
+	case FLOAT:
+		return Float{fuzzFloats[g.rnd.Intn(len(fuzzFloats))], map[string]any{"type": FLOAT}}
+	}
+	return Boolean{g.rnd.Intn(2) == 0, map[string]any{"type": BOOL}}
+}
+
+func (g *generator) expr(t Type, depth int) Expression {
+	deco := func() map[string]any { return map[string]any{"type": t} }
+	if depth >= 3 || g.rnd.Intn(4) == 0 {
+		vars := g.vars(func(v fuzzVar) bool { return v.typ == t })
+		if len(vars) > 0 && g.rnd.Intn(2) == 0 {
+			return Var{vars[g.rnd.Intn(len(vars))].name, deco()}
+		}
+		return g.literal(t)
+	}
+	sub := func(t Type) Expression { return g.expr(t, depth+1) }
+	lit := func(n int) Expression { return Integer{n, deco()} }
+	switch t {
+	case INT, LONG:
+		bits := 31
+		if t == LONG {
+			bits = 63
+		}
+		switch op := []string{"+", "-", "*", "&", "|", "^", "/", "%", "<<", ">>", ">>>", "-x", "~", "conv", "call"}[g.rnd.Intn(15)]; op {
+		case "/", "%": // the divisor is positive and odd
+			divisor := ArithOp{"|", ArithOp{"&", sub(t), lit(255), deco()}, lit(1), deco()}
+			return ArithOp{op, sub(t), divisor, deco()}
+		case "<<", ">>", ">>>":
+			return ArithOp{op, sub(t), ArithOp{"&", sub(t), lit(bits), deco()}, deco()}
+		case "-x":
+			return ArithOp{"-", lit(0), sub(t), map[string]any{"type": t, "unary": true}}
+		case "~":
+			return ArithOp{"^", sub(t), lit(-1), map[string]any{"type": t, "bitnot": true}}
+		case "conv":
+			from := []Type{INT, LONG, FLOAT}[g.rnd.Intn(3)]
+			return Convert{sub(from), deco()}
+		case "call":
+			if call, ok := g.call(t, depth); ok {
+				return call
+			}
+			return sub(t)
+		default:
+			return ArithOp{op, sub(t), sub(t), deco()}
+		}
+	case FLOAT:
+		switch g.rnd.Intn(6) {
+		case 0:
+			return Convert{sub([]Type{INT, LONG}[g.rnd.Intn(2)]), deco()}
+		case 1: // the divisor is not zero
+			return ArithOp{"/", sub(FLOAT), Float{fuzzFloats[1+g.rnd.Intn(len(fuzzFloats)-1)], deco()}, deco()}
+		case 2:
+			if call, ok := g.call(t, depth); ok {
+				return call
+			}
+			return ArithOp{"-", Float{0, deco()}, sub(FLOAT), map[string]any{"type": t, "unary": true}}
+		default:
+			return ArithOp{[]string{"+", "-", "*"}[g.rnd.Intn(3)], sub(FLOAT), sub(FLOAT), deco()}
+		}
+	case BOOL:
+		switch g.rnd.Intn(5) {
+		case 0, 1:
+			operands := []Type{INT, LONG, FLOAT, BOOL}[g.rnd.Intn(4)]
+			ops := []string{"<", "<=", ">", ">=", "==", "!="}
+			if operands == BOOL {
+				ops = ops[4:]
+			}
+			return LogicOp{ops[g.rnd.Intn(len(ops))], sub(operands), sub(operands), deco()}
+		case 2:
+			return LogicOp{[]string{"&&", "||"}[g.rnd.Intn(2)], sub(BOOL), sub(BOOL), deco()}
+		case 3:
+			return LogicOp{"==", Boolean{false, deco()}, sub(BOOL), map[string]any{"type": t, "unary": true}}
+		default:
+			if call, ok := g.call(t, depth); ok {
+				return call
+			}
+			return sub(BOOL)
+		}
+	}
+	panic(fmt.Sprint("Unknown type ", t))
+}
+
+// the source of a program built by the generator or the reducer: the binary operations are parenthesized, the
+// negative literals are written as negated positive ones
+func formatProgram(f Function) string {
+	var out strings.Builder
+	formatFun(&out, f, "")
+	return out.String()
+}
+
+func formatType(t Type) string {
+	return strings.ToLower(t.String())
+}
+
+func formatFun(out *strings.Builder, f Function, indent string) {
+	args := []string{}
+	for _, arg := range f.args {
+		args = append(args, formatType(arg.deco["type"].(Type))+" "+arg.name)
+	}
+	header := fmt.Sprintf("%s(%s) {\n", f.name, strings.Join(args, ", "))
+	if t := f.deco["type"].(Type); t != VOID {
+		header = formatType(t) + " " + header
+	}
+	out.WriteString(indent + header)
+	for _, v := range f.vars {
+		fmt.Fprintf(out, "%s    %s %s;\n", indent, formatType(v.deco["type"].(Type)), v.name)
+	}
+	consts, _ := f.deco["consts"].([]Var)
+	for _, c := range consts {
+		fmt.Fprintf(out, "%s    const %s %s = %s;\n", indent, formatType(c.deco["type"].(Type)), c.name, formatExpr(c.deco["value"].(Expression)))
+	}
+	for _, nested := range f.fun {
+		formatFun(out, nested, indent+"    ")
+	}
+	formatStats(out, f.body, indent+"    ")
+	out.WriteString(indent + "}\n")
+}
+
+func formatStats(out *strings.Builder, ss []Statement, indent string) {
+	for _, s := range ss {
+		switch e := s.(type) {
+		case Print:
+			keyword := "print"
+			if e.newline {
+				keyword = "println"
+			}
+			fmt.Fprintf(out, "%s%s %s;\n", indent, keyword, formatExpr(e.expr))
+		case Return:
+			if e.expr == nil {
+				out.WriteString(indent + "return;\n")
+			} else {
+				fmt.Fprintf(out, "%sreturn %s;\n", indent, formatExpr(e.expr))
+			}
+		case Assign:
+			fmt.Fprintf(out, "%s%s = %s;\n", indent, e.name, formatExpr(e.expr))
+		case Store:
+			fmt.Fprintf(out, "%s%s[%s] = %s;\n", indent, formatExpr(e.array), formatExpr(e.index), formatExpr(e.expr))
+		case FunCall:
+			fmt.Fprintf(out, "%s%s;\n", indent, formatExpr(e))
+		case While:
+			fmt.Fprintf(out, "%swhile %s {\n", indent, formatExpr(e.expr))
+			formatStats(out, e.body, indent+"    ")
+			out.WriteString(indent + "}\n")
+		case IfThenElse:
+			fmt.Fprintf(out, "%sif %s {\n", indent, formatExpr(e.expr))
+			formatStats(out, e.ibody, indent+"    ")
+			if len(e.ebody) > 0 {
+				out.WriteString(indent + "} else {\n")
+				formatStats(out, e.ebody, indent+"    ")
+			}
+			out.WriteString(indent + "}\n")
+		case Switch:
+			fmt.Fprintf(out, "%sswitch %s {\n", indent, formatExpr(e.expr))
+			for _, c := range e.cases {
+				values := []string{}
+				for _, v := range c.values {
+					values = append(values, formatExpr(v))
+				}
+				if len(values) == 0 {
+					out.WriteString(indent + "    default:\n")
+				} else {
+					fmt.Fprintf(out, "%s    case %s:\n", indent, strings.Join(values, ", "))
+				}
+				formatStats(out, c.body, indent+"        ")
+			}
+			out.WriteString(indent + "}\n")
+		default:
+			panic(fmt.Sprint("Unknown statement type", e))
+		}
+	}
+}
+
+func formatExpr(n Expression) string {
+	switch e := n.(type) {
+	case ArithOp:
+		switch {
+		case e.deco["unary"] == true:
+			return "(-" + formatExpr(e.right) + ")"
+		case e.deco["bitnot"] == true:
+			return "(~" + formatExpr(e.left) + ")"
+		}
+		return fmt.Sprintf("(%s %s %s)", formatExpr(e.left), e.op, formatExpr(e.right))
+	case LogicOp:
+		if e.deco["unary"] == true {
+			return "(!" + formatExpr(e.right) + ")"
+		}
+		return fmt.Sprintf("(%s %s %s)", formatExpr(e.left), e.op, formatExpr(e.right))
+	case Integer:
+		suffix := ""
+		if e.deco["type"] == LONG {
+			suffix = "L"
+		}
+		switch {
+		case e.value == math.MinInt32 && e.deco["type"] == INT || e.value == math.MinInt64:
+			return fmt.Sprintf("(-%d%s - 1%s)", -(e.value + 1), suffix, suffix)
+		case e.value < 0:
+			return fmt.Sprintf("(-%d%s)", -e.value, suffix)
+		}
+		return fmt.Sprintf("%d%s", e.value, suffix)
+	case Float:
+		s := strconv.FormatFloat(math.Abs(e.value), 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		if e.value < 0 {
+			return "(-" + s + ")"
+		}
+		return s
+	case Boolean:
+		return strconv.FormatBool(e.value)
+	case Var:
+		return e.name
+	case Convert:
+		return fmt.Sprintf("%s(%s)", formatType(e.deco["type"].(Type)), formatExpr(e.expr))
+	case New:
+		return fmt.Sprintf("new %s[%s]", formatType(e.deco["type"].(Type).elem()), formatExpr(e.size))
+	case Index:
+		return fmt.Sprintf("%s[%s]", formatExpr(e.array), formatExpr(e.index))
+	case FunCall:
+		args := []string{}
+		for _, arg := range e.args {
+			args = append(args, formatExpr(arg))
+		}
+		return fmt.Sprintf("%s(%s)", e.name, strings.Join(args, ", "))
+	default:
+		panic(fmt.Sprint("Unknown expression type", e))
+	}
+}
+
+// the interpreter stops a program that runs too long, the other backends are killed after fuzzTimeout
+const (
+	fuzzSteps   = 10000000
+	fuzzTimeout = 20 * time.Second
+)
+
+// a backend runs the program, the result is its output followed by the error, if any
+type fuzzBackend struct {
+	name string
+	run  func(path, dir string) string
+}
+
+// the backends found on this machine, the interpreter first: the others are compared with it
+func fuzzBackends() []fuzzBackend {
+	backends := []fuzzBackend{{"interp", func(path, _ string) string {
+		var out bytes.Buffer
+		err := func() (err error) {
+			defer catch(&err, nil)
+			m := newMachine(&out)
+			steps := 0
+			m.step = func(Statement) {
+				if steps++; steps > fuzzSteps {
+					panic(runtimeError{"step limit exceeded", m.cur.lineno})
+				}
+			}
+			m.run(loadProgram(path))
+			return nil
+		}()
+		return fuzzResult(out.String(), err)
+	}}}
+	native := func(opts buildOptions) func(path, dir string) string {
+		return func(path, dir string) string {
+			exe, err := fuzzBuild(path, dir, opts)
+			if err != nil {
+				return fuzzResult("", err)
+			}
+			return fuzzExec(exe)
+		}
+	}
+	python := func(emit string) func(path, dir string) string {
+		return func(path, dir string) string {
+			program, err := fuzzBuild(path, dir, buildOptions{emit: emit})
+			if err != nil {
+				return fuzzResult("", err)
+			}
+			return fuzzExec("python3", program)
+		}
+	}
+	_, asErr := exec.LookPath("as")
+	_, ldErr := exec.LookPath("ld")
+	if asErr == nil && ldErr == nil {
+		backends = append(backends, fuzzBackend{"asm", native(buildOptions{})},
+			fuzzBackend{"asm-unoptimized", native(buildOptions{noInline: true, noPeephole: true})})
+	}
+	if _, err := exec.LookPath("python3"); err == nil {
+		backends = append(backends, fuzzBackend{"py", python("py")}, fuzzBackend{"py-novars", python("py-novars")})
+	}
+	return backends
+}
+
+func fuzzResult(out string, err error) string {
+	if err != nil {
+		return out + "\n[" + err.Error() + "]"
+	}
+	return out
+}
+
+// the warnings are dropped, a generated program may leave functions unused
+func fuzzBuild(path, dir string, opts buildOptions) (result string, err error) {
+	defer catch(&err, nil)
+	result, _, err = build(path, dir, opts)
+	return result, err
+}
+
+func fuzzExec(name string, args ...string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), fuzzTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	return fuzzResult(string(out), err)
+}
+
+// run the program with every backend; the verdict tells which backends agree: the backend i gets the index of the
+// first backend with the same result, so a program without mismatch gets all zeros
+func runBackends(source, dir string, backends []fuzzBackend) (results []string, verdict string) {
+	path := filepath.Join(dir, "prog.wend")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		panic(err)
+	}
+	groups := []string{}
+	for i, b := range backends {
+		sub := filepath.Join(dir, b.name)
+		results = append(results, b.run(path, sub))
+		os.RemoveAll(sub)
+		for j := range i + 1 {
+			if results[j] == results[i] {
+				groups = append(groups, strconv.Itoa(j))
+				break
+			}
+		}
+	}
+	return results, strings.Join(groups, " ")
+}
+
+// the reducer may produce a program that is rejected or whose behaviour is undefined
+func wellDefined(source, dir string) bool {
+	path := filepath.Join(dir, "check.wend")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		panic(err)
+	}
+	var warnings []Warning
+	if err := func() (err error) {
+		defer catch(&err, nil)
+		root, _ := loadProgram(path)
+		warnings = vet(root)
+		return nil
+	}(); err != nil {
+		return false
+	}
+	for _, w := range warnings {
+		if strings.Contains(w.msg, "without a return") || strings.Contains(w.msg, "before assignment") {
+			return false
+		}
+	}
+	return true
+}
+
+// generate and run the programs, the mismatches are reduced and saved; the exit code is 1 if there are any
+func fuzz(args []string) int {
+	flags := flag.NewFlagSet("fuzz", flag.ContinueOnError)
+	count := flags.Int("n", 100, "the number of programs to generate")
+	seed := flags.Int64("seed", time.Now().UnixNano()%1000000, "the seed of the first program, the next ones are numbered from it")
+	dir := flags.String("o", ".", "write the failing programs to `dir`")
+	noReduce := flags.Bool("no-reduce", false, "save the failing programs as generated")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return exitUsage
+	}
+	tmp, err := os.MkdirTemp("", "wendfuzz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	defer os.RemoveAll(tmp)
+
+	backends := fuzzBackends()
+	names := []string{}
+	for _, b := range backends {
+		names = append(names, b.name)
+	}
+	fmt.Printf("backends: %s\n", strings.Join(names, ", "))
+	failures := 0
+	for i := range int64(*count) {
+		program := generateProgram(*seed + i)
+		results, verdict := runBackends(formatProgram(program), tmp, backends)
+		if strings.Trim(verdict, "0 ") == "" {
+			continue
+		}
+		failures++
+		fmt.Printf("seed %d: the backends disagree (%s)\n", *seed+i, verdict)
+		if !*noReduce {
+			program = reduce(program, func(f Function) bool {
+				source := formatProgram(f)
+				if !wellDefined(source, tmp) {
+					return false
+				}
+				_, v := runBackends(source, tmp, backends)
+				return v == verdict
+			})
+			results, _ = runBackends(formatProgram(program), tmp, backends)
+		}
+		var header strings.Builder
+		for j, b := range backends {
+			fmt.Fprintf(&header, "// %s:\n//\t%s\n", b.name, strings.ReplaceAll(strings.TrimSuffix(results[j], "\n"), "\n", "\n//\t"))
+		}
+		name := filepath.Join(*dir, fmt.Sprintf("fuzz-%d.wend", *seed+i))
+		if err := os.WriteFile(name, []byte(header.String()+formatProgram(program)), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		fmt.Printf("\tsaved to %s\n", name)
+	}
+	fmt.Printf("%d programs, %d mismatches\n", *count, failures)
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
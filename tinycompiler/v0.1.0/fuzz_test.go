@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFuzzGenerate(t *testing.T) {
+	dir := t.TempDir()
+	for seed := range int64(10) {
+		source := formatProgram(generateProgram(seed))
+		if source != formatProgram(generateProgram(seed)) {
+			t.Fatalf("seed %d: the generator is not deterministic", seed)
+		}
+		if !wellDefined(source, dir) {
+			t.Fatalf("seed %d: the program is rejected:\n%s", seed, source)
+		}
+		fun := (&WendParser{}).Parse(tokenize(source)).(Function)
+		buildSymtable(fun)
+		if unused := fun.deco["callgraph"].(*callGraph).unused(); len(unused) > 0 {
+			t.Fatalf("seed %d: the functions are not all called %v:\n%s", seed, unused, source)
+		}
+		results, _ := runBackends(source, dir, fuzzBackends()[:1])
+		if strings.Contains(results[0], "\n[") {
+			t.Fatalf("seed %d: the interpreter failed: %s", seed, results[0])
+		}
+	}
+}
+
+func TestFuzzBackends(t *testing.T) {
+	backends := fuzzBackends()
+	if len(backends) == 1 {
+		t.Skip("no backend to compare with the interpreter")
+	}
+	dir := t.TempDir()
+	for seed := range int64(3) {
+		results, verdict := runBackends(formatProgram(generateProgram(seed)), dir, backends)
+		if strings.Trim(verdict, "0 ") != "" {
+			t.Errorf("seed %d: the backends disagree (%s): %q", seed, verdict, results)
+		}
+	}
+}
+
+func TestReduce(t *testing.T) {
+	dir := t.TempDir()
+	program := generateProgram(3)
+	// a fake failure: the program prints a negative number
+	failing := func(f Function) bool {
+		source := formatProgram(f)
+		if !wellDefined(source, dir) {
+			return false
+		}
+		results, _ := runBackends(source, dir, fuzzBackends()[:1])
+		return strings.Contains(results[0], "-")
+	}
+	if !failing(program) {
+		t.Fatal("the program does not fail")
+	}
+	before := formatProgram(program)
+	program = reduce(program, failing)
+	reduced := formatProgram(program)
+	if !failing(program) {
+		t.Fatalf("the reduced program does not fail:\n%s", reduced)
+	}
+	if len(reduced) >= len(before)/4 {
+		t.Fatalf("the program is not reduced:\n%s", reduced)
+	}
+}
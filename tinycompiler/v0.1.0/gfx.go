@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The graphics builtins draw to a back buffer of gfxWidth x gfxHeight pixels, a pixel is a space with a true colour
+// background. present() writes the pixels that differ from the front buffer, the picture on the terminal, in a single
+// write; the cursor is moved only when the next pixel to draw is not the next one on the line, the colour is set only
+// when it changes. A pixel is stored as 0x01rrggbb, 0 is a pixel never drawn: it is not written by present(). The
+// terminal is cleared by the first present(), the cursor is shown again below the picture when the program exits.
+// rand() is a xorshift32 generator with a fixed seed, so every backend produces the same sequence.
+const (
+	gfxWidth  = 320
+	gfxHeight = 200
+	gfxSeed   = 2463534242
+)
+
+// the signatures of the builtins, their labels are the names of the runtime routines
+var builtinSignatures = []struct {
+	name     string
+	argtypes []Type
+	ret      Type
+	runtime  string // the part of the runtime linked with a program calling the builtin
+}{
+	{"set_pixel", []Type{INT, INT, INT, INT, INT}, VOID, "gfx"},
+	{"present", []Type{}, VOID, "gfx"},
+	{"clear", []Type{}, VOID, "gfx"},
+	{"sleep_ms", []Type{INT}, VOID, "gfx"},
+	{"rand", []Type{}, INT, "gfx"},
+	{"len", []Type{INTARRAY}, INT, "heap"},
+	{"len", []Type{BOOLARRAY}, INT, "heap"},
+	{"len", []Type{LONGARRAY}, INT, "heap"},
+	{"len", []Type{FLOATARRAY}, INT, "heap"},
+}
+
+// declare the builtins in the outermost scope, any function of the program hides them. They take no scope id, an
+// imported function with the same signature wins.
+func addBuiltins(symtable *SymbolTable) {
+	for _, b := range builtinSignatures {
+		signature := Signature{b.name, b.argtypes}.String()
+		if _, ok := symtable.functions[0][signature]; ok {
+			continue
+		}
+		symtable.functions[0][signature] = map[string]any{"type": b.ret, "label": "wend_" + b.name, "builtin": true,
+			"argtypes": b.argtypes, "ancestors": []int{}, "runtime": b.runtime}
+	}
+}
+
+// the state of the graphics runtime of the interpreter, it writes what the assembly runtime writes
+type screen struct {
+	back, front   []uint32
+	width, height int // the bounding box of the pixels drawn so far
+	presented     bool
+	seed          uint32
+}
+
+func newScreen() *screen {
+	return &screen{back: make([]uint32, gfxWidth*gfxHeight), front: make([]uint32, gfxWidth*gfxHeight), seed: gfxSeed}
+}
+
+func (s *screen) setPixel(x, y, r, g, b int32) {
+	if x < 0 || x >= gfxWidth || y < 0 || y >= gfxHeight {
+		return
+	}
+	s.back[int(y)*gfxWidth+int(x)] = 1<<24 | uint32(r&255)<<16 | uint32(g&255)<<8 | uint32(b&255)
+	s.width, s.height = max(s.width, int(x)+1), max(s.height, int(y)+1)
+}
+
+func (s *screen) clear() {
+	for i := range s.back {
+		s.back[i] = 1 << 24
+	}
+}
+
+func (s *screen) present() string {
+	var out strings.Builder
+	if !s.presented {
+		s.presented = true
+		out.WriteString("\033[2J\033[?25l")
+	}
+	last, cursor := uint32(0), -1
+	for y := range s.height {
+		for x := range s.width {
+			i := y*gfxWidth + x
+			if s.back[i] == s.front[i] {
+				continue
+			}
+			s.front[i] = s.back[i]
+			if cursor != i {
+				fmt.Fprintf(&out, "\033[%d;%dH", y+1, x+1)
+			}
+			if s.front[i] != last {
+				last = s.front[i]
+				fmt.Fprintf(&out, "\033[48;2;%d;%d;%dm", last>>16&255, last>>8&255, last&255)
+			}
+			out.WriteString(" ")
+			cursor = i + 1
+		}
+	}
+	if out.Len() > 0 {
+		out.WriteString("\033[0m")
+	}
+	return out.String()
+}
+
+func (s *screen) exit() string {
+	if !s.presented {
+		return ""
+	}
+	return fmt.Sprintf("\033[?25h\033[%d;1H", s.height+1)
+}
+
+func (s *screen) rand() int32 {
+	if s.seed == 0 {
+		s.seed = gfxSeed
+	}
+	s.seed ^= s.seed << 13
+	s.seed ^= s.seed >> 17
+	s.seed ^= s.seed << 5
+	return int32(s.seed >> 1)
+}
+
+// the same runtime for the Python backends, the state is kept in the sys module to be shared by all the modules
+const pyGfxRuntime = `import time
+wend_screen = sys.__dict__.setdefault("wend_screen", {"back": [0] * (%[1]d * %[2]d), "front": [0] * (%[1]d * %[2]d),
+	"width": 0, "height": 0, "presented": False, "seed": %[3]d})
+
+def wend_set_pixel(x, y, r, g, b):
+	s = wend_screen
+	if 0 <= x < %[1]d and 0 <= y < %[2]d:
+		s["back"][y * %[1]d + x] = 1 << 24 | (r & 255) << 16 | (g & 255) << 8 | b & 255
+		s["width"], s["height"] = max(s["width"], x + 1), max(s["height"], y + 1)
+
+def wend_clear():
+	wend_screen["back"][:] = [1 << 24] * (%[1]d * %[2]d)
+
+def wend_present():
+	s, out = wend_screen, []
+	if not s["presented"]:
+		s["presented"] = True
+		out.append("\033[2J\033[?25l")
+	last, cursor = 0, -1
+	for y in range(s["height"]):
+		for x in range(s["width"]):
+			i = y * %[1]d + x
+			if s["back"][i] == s["front"][i]:
+				continue
+			s["front"][i] = p = s["back"][i]
+			if cursor != i:
+				out.append("\033[%%d;%%dH" %% (y + 1, x + 1))
+			if p != last:
+				last = p
+				out.append("\033[48;2;%%d;%%d;%%dm" %% (p >> 16 & 255, p >> 8 & 255, p & 255))
+			out.append(" ")
+			cursor = i + 1
+	if out:
+		sys.stdout.write("".join(out) + "\033[0m")
+		sys.stdout.flush()
+
+def wend_sleep_ms(n):
+	if n > 0:
+		time.sleep(n / 1000)
+
+def wend_rand():
+	s = wend_screen
+	x = s["seed"] or %[3]d
+	x ^= x << 13 & 0xffffffff
+	x ^= x >> 17
+	x ^= x << 5 & 0xffffffff
+	s["seed"] = x
+	return x >> 1
+
+def wend_gfx_exit():
+	if wend_screen["presented"]:
+		sys.stdout.write("\033[?25h\033[%%d;1H" %% (wend_screen["height"] + 1))
+
+`
+
+func pyGfx() string {
+	return fmt.Sprintf(pyGfxRuntime, gfxWidth, gfxHeight, gfxSeed)
+}
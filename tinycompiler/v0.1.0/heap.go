@@ -0,0 +1,77 @@
+package main
+
+import "fmt"
+
+// The arrays live in the heap, a variable of an array type holds a reference to the length of its block, between a
+// header word with the size of the block and the elements. The assembly runtime collects the blocks itself (see
+// runtime_heap), the other backends use the collector of their host: an array is a slice or a list.
+const (
+	heapSize    = 256 << 20 // the largest heap of a module
+	heapInitial = 1 << 20   // the bytes allocated before the first collection
+)
+
+// the runtime errors of the arrays, the same for every backend
+const (
+	errBounds   = "index out of range"
+	errNegative = "negative array size"
+	errMemory   = "out of memory"
+)
+
+// the bytes taken by an element of the array type
+func elemSize(t Type) int {
+	return t.elem().size() * 4
+}
+
+func newArray(t Type, n int32, lineno int) []any {
+	if n < 0 {
+		panic(runtimeError{errNegative, lineno})
+	}
+	if int64(n)*int64(elemSize(t)) > heapSize {
+		panic(runtimeError{errMemory, lineno})
+	}
+	a := make([]any, n)
+	for i := range a {
+		a[i] = zeroValue(t.elem())
+	}
+	return a
+}
+
+// the index checked against the bounds of the array, an unassigned array variable has none
+func checkIndex(a []any, i int32, lineno int) int32 {
+	if i < 0 || int(i) >= len(a) {
+		panic(runtimeError{errBounds, lineno})
+	}
+	return i
+}
+
+// the same runtime for the Python backends, the errors are reported like the assembly runtime does
+const pyHeapRuntime = `def wend_error(msg):
+	sys.stdout.flush()
+	sys.stderr.write(msg + "\n")
+	sys.exit(1)
+
+def wend_new(n, zero, size):
+	if n < 0:
+		wend_error("%[1]s")
+	if n * size > %[4]d:
+		wend_error("%[3]s")
+	return [zero] * n
+
+def wend_get(a, i):
+	if a is None or not 0 <= i < len(a):
+		wend_error("%[2]s")
+	return a[i]
+
+def wend_set(a, i, x):
+	if a is None or not 0 <= i < len(a):
+		wend_error("%[2]s")
+	a[i] = x
+
+def wend_len(a):
+	return 0 if a is None else len(a)
+
+`
+
+func pyHeap() string {
+	return fmt.Sprintf(pyHeapRuntime, errNegative, errBounds, errMemory, heapSize)
+}
@@ -0,0 +1,466 @@
+package main
+
+import "fmt"
+
+// Inlining replaces a call to a small function by a copy of its body running in the frame of the caller.
+// The parameters and the locals of the callee get fresh slots in the caller's frame, the arguments are assigned
+// to the parameters in the order of evaluation, and the returns become assignments to a result slot.
+// The variables of the enclosing scopes keep their decoration: the callee is visible from the caller,
+// so the display entries of the callee's ancestors are the same at the call site.
+// The callee is identified by the decoration of its resolved signature, so overloads need no special care.
+
+const inlineBudget = 40 // the largest body worth inlining, in syntax tree nodes
+
+type inliner struct {
+	funs   map[int]*Function // all functions of the module by scope id
+	caller map[string]any    // decoration of the function being rewritten
+	count  int               // number of inlined calls
+}
+
+// the functions are processed callees first, so that the calls inlined into a callee are inlined transitively;
+// the result is the number of inlined calls
+func inline(root *Function) int {
+	in := &inliner{funs: map[int]*Function{}}
+	var collect func(f *Function)
+	collect = func(f *Function) {
+		in.funs[f.deco["scope"].(int)] = f
+		for i := range f.fun {
+			collect(&f.fun[i])
+		}
+	}
+	collect(root)
+
+	done := map[int]bool{} // a function is marked when it is entered, recursion cycles are cut there
+	var process func(f *Function)
+	process = func(f *Function) {
+		if done[f.deco["scope"].(int)] {
+			return
+		}
+		done[f.deco["scope"].(int)] = true
+		for _, nested := range f.fun {
+			process(in.funs[nested.deco["scope"].(int)])
+		}
+		for _, scope := range callees(f.body) {
+			if g, ok := in.funs[scope]; ok {
+				process(g)
+			}
+		}
+		in.caller = f.deco
+		for i := range f.body {
+			f.body[i] = in.stat(f.body[i])
+		}
+	}
+	process(root)
+	return in.count
+}
+
+// scope ids of the functions called directly from the statement list
+func callees(ss []Statement) []int {
+	result := []int{}
+	visitExprs(ss, func(e Expression) {
+		if call, ok := e.(FunCall); ok {
+			if fundeco, ok := call.deco["fundeco"].(map[string]any); ok && fundeco["import"] == nil && fundeco["extern"] == nil && fundeco["builtin"] == nil {
+				result = append(result, fundeco["scope"].(int))
+			}
+		}
+	})
+	return result
+}
+
+func (in *inliner) inlinable(call FunCall) (*Function, bool) {
+	fundeco, ok := call.deco["fundeco"].(map[string]any)
+	if !ok || fundeco["import"] != nil || fundeco["extern"] != nil || fundeco["builtin"] != nil {
+		return nil, false
+	}
+	g := in.funs[fundeco["scope"].(int)]
+	if g == nil || g.deco["level"] == 0 || len(g.fun) > 0 || g.deco["closures"] == true || g.deco["scope"] == in.caller["scope"] {
+		return nil, false // nested functions and closure records need the callee's own frame
+	}
+	size := 0
+	visitStats(g.body, func(Statement) { size++ })
+	visitExprs(g.body, func(Expression) { size++ })
+	if size > inlineBudget {
+		return nil, false
+	}
+	for _, scope := range callees(g.body) {
+		if scope == g.deco["scope"] {
+			return nil, false
+		}
+	}
+	tail := false // the copy would lose the tail calls, the stack must not grow in mutual recursion
+	visitExprs(g.body, func(e Expression) {
+		if call, ok := e.(FunCall); ok && call.deco["tail"] != nil {
+			tail = true
+		}
+	})
+	if tail {
+		return nil, false
+	}
+	return g, tailReturns(normalize(copier{remap: clone}.stats(g.body)), true)
+}
+
+func (in *inliner) stat(n Statement) Statement {
+	switch e := n.(type) {
+	case Print:
+		e.expr = in.expr(e.expr)
+		return e
+	case Return:
+		if e.expr != nil {
+			e.expr = in.expr(e.expr)
+		}
+		return e
+	case Assign:
+		e.expr = in.expr(e.expr)
+		return e
+	case Store:
+		e.index = in.expr(e.index)
+		e.expr = in.expr(e.expr)
+		return e
+	case FunCall:
+		return in.expr(e).(Statement)
+	case While:
+		e.expr = in.expr(e.expr)
+		for i := range e.body {
+			e.body[i] = in.stat(e.body[i])
+		}
+		return e
+	case IfThenElse:
+		e.expr = in.expr(e.expr)
+		for i := range e.ibody {
+			e.ibody[i] = in.stat(e.ibody[i])
+		}
+		for i := range e.ebody {
+			e.ebody[i] = in.stat(e.ebody[i])
+		}
+		return e
+	case Switch:
+		e.expr = in.expr(e.expr)
+		for _, c := range e.cases {
+			for i := range c.body {
+				c.body[i] = in.stat(c.body[i])
+			}
+		}
+		return e
+	case Inline:
+		return e
+	}
+	panic(fmt.Sprint("Unknown statement type", n))
+}
+
+func (in *inliner) expr(n Expression) Expression {
+	switch e := n.(type) {
+	case ArithOp:
+		e.left = in.expr(e.left)
+		e.right = in.expr(e.right)
+		return e
+	case LogicOp:
+		e.left = in.expr(e.left)
+		e.right = in.expr(e.right)
+		return e
+	case Convert:
+		e.expr = in.expr(e.expr)
+		return e
+	case New:
+		e.size = in.expr(e.size)
+		return e
+	case Index:
+		e.array = in.expr(e.array)
+		e.index = in.expr(e.index)
+		return e
+	case FunCall:
+		for i := range e.args {
+			e.args[i] = in.expr(e.args[i])
+		}
+		if g, ok := in.inlinable(e); ok {
+			return in.expand(e, g)
+		}
+		return e
+	}
+	return n
+}
+
+func (in *inliner) expand(call FunCall, g *Function) Inline {
+	base := in.caller["varCnt"].(int)
+	in.caller["varCnt"] = base + g.deco["varCnt"].(int)
+	remap := func(deco map[string]any) map[string]any {
+		result := clone(deco)
+		if _, ok := deco["offset"]; ok && deco["scope"] == g.deco["scope"] { // a variable of the callee
+			result["scope"] = in.caller["scope"]
+			result["level"] = in.caller["level"]
+			result["offset"] = base + deco["offset"].(int)
+		}
+		return result
+	}
+
+	// a parameter that is never assigned is replaced by a literal argument; a variable argument may be modified
+	// by the callee or by the evaluation of the other arguments, it is substituted only if there are no calls
+	assigned := map[varKey]bool{}
+	calls := false
+	visitStats(g.body, func(s Statement) {
+		if s, ok := s.(Assign); ok {
+			assigned[keyOf(s.deco)] = true
+		}
+	})
+	for _, e := range append(append([]Expression{}, call.args...), Inline{g.body, nil, nil}) {
+		visitExpr(e, func(e Expression) {
+			switch e.(type) {
+			case FunCall, Inline:
+				calls = true
+			}
+		})
+	}
+	subst := map[varKey]Expression{}
+	body := []Statement{}
+	for i, arg := range call.args {
+		key := keyOf(g.args[i].deco)
+		switch a := arg.(type) {
+		case Integer, Float, Boolean:
+			if !assigned[key] {
+				subst[key] = arg
+				continue
+			}
+		case Var:
+			if !assigned[key] && !assigned[keyOf(a.deco)] && !calls {
+				subst[key] = arg
+				continue
+			}
+		}
+		deco := remap(g.args[i].deco)
+		deco["lineno"] = call.deco["lineno"]
+		body = append(body, Assign{g.args[i].name, arg, deco})
+	}
+	stats := normalize(copier{remap, subst}.stats(g.body))
+	var result Expression
+	if r, ok := lastReturn(stats); ok && len(stats) == 1 && r.expr != nil { // a single expression
+		result, stats = r.expr, nil
+	} else if g.deco["type"] != VOID {
+		deco := map[string]any{
+			"type":   g.deco["type"],
+			"scope":  in.caller["scope"],
+			"level":  in.caller["level"],
+			"offset": in.caller["varCnt"],
+			"lineno": call.deco["lineno"],
+		}
+		in.caller["varCnt"] = in.caller["varCnt"].(int) + g.deco["type"].(Type).size()
+		result = Var{g.name, deco}
+	}
+	body = append(body, assignReturns(stats, result)...)
+	in.count++
+	return Inline{body, result, map[string]any{"type": g.deco["type"], "lineno": call.deco["lineno"], "callee": g.deco["label"]}}
+}
+
+// move the statements following an if statement with a returning branch into the other branch,
+// so that the returns end the body whenever possible
+func normalize(ss []Statement) []Statement {
+	for i, s := range ss {
+		e, ok := s.(IfThenElse)
+		if !ok {
+			continue
+		}
+		rest := ss[i+1:]
+		switch iret, eret := endsWithReturn(e.ibody), endsWithReturn(e.ebody); {
+		case iret && eret: // the rest is unreachable
+			rest = nil
+		case iret:
+			e.ebody, rest = append(append([]Statement{}, e.ebody...), rest...), nil
+		case eret:
+			e.ibody, rest = append(append([]Statement{}, e.ibody...), rest...), nil
+		}
+		if len(rest) == 0 {
+			e.ibody, e.ebody = normalize(e.ibody), normalize(e.ebody)
+			return append(append([]Statement{}, ss[:i]...), e)
+		}
+	}
+	return ss
+}
+
+func lastReturn(ss []Statement) (Return, bool) {
+	if len(ss) == 0 {
+		return Return{}, false
+	}
+	r, ok := ss[len(ss)-1].(Return)
+	return r, ok
+}
+
+func endsWithReturn(ss []Statement) bool {
+	if len(ss) == 0 {
+		return false
+	}
+	switch e := ss[len(ss)-1].(type) {
+	case Return:
+		return true
+	case IfThenElse:
+		return endsWithReturn(e.ibody) && endsWithReturn(e.ebody)
+	case Switch:
+		for _, c := range e.cases {
+			if !endsWithReturn(c.body) {
+				return false
+			}
+		}
+		return e.hasDefault()
+	}
+	return false
+}
+
+// check that no statement follows a return
+func tailReturns(ss []Statement, tail bool) bool {
+	for i, s := range ss {
+		last := tail && i == len(ss)-1
+		switch e := s.(type) {
+		case Return:
+			if !last {
+				return false
+			}
+		case IfThenElse:
+			if !tailReturns(e.ibody, last) || !tailReturns(e.ebody, last) {
+				return false
+			}
+		case Switch:
+			for _, c := range e.cases {
+				if !tailReturns(c.body, last) {
+					return false
+				}
+			}
+		case While:
+			if !tailReturns(e.body, false) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// the returns in tail position become assignments to the result
+func assignReturns(ss []Statement, result Expression) []Statement {
+	out := []Statement{}
+	for _, s := range ss {
+		switch e := s.(type) {
+		case Return:
+			if e.expr != nil && result != nil {
+				deco := map[string]any{}
+				copyDeco(deco, result.getDeco())
+				deco["lineno"] = e.deco["lineno"]
+				out = append(out, Assign{result.(Var).name, e.expr, deco})
+			}
+		case IfThenElse:
+			e.ibody, e.ebody = assignReturns(e.ibody, result), assignReturns(e.ebody, result)
+			out = append(out, e)
+		case Switch:
+			cases := []Case{}
+			for _, c := range e.cases {
+				cases = append(cases, Case{c.values, assignReturns(c.body, result), c.deco})
+			}
+			e.cases = cases
+			out = append(out, e)
+		default:
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// deep copy of the syntax tree: the decorations are copied by remap, the variables found in subst
+// (keyed by their original decoration) are replaced by a copy of the expression
+type copier struct {
+	remap func(map[string]any) map[string]any
+	subst map[varKey]Expression
+}
+
+func clone(deco map[string]any) map[string]any {
+	result := map[string]any{}
+	for k, v := range deco {
+		result[k] = v
+	}
+	return result
+}
+
+func (c copier) stats(ss []Statement) []Statement {
+	out := []Statement{}
+	for _, s := range ss {
+		out = append(out, c.stat(s))
+	}
+	return out
+}
+
+func (c copier) stat(n Statement) Statement {
+	switch e := n.(type) {
+	case Print:
+		return Print{c.expr(e.expr), e.newline, c.remap(e.deco)}
+	case Return:
+		if e.expr == nil {
+			return Return{nil, c.remap(e.deco)}
+		}
+		return Return{c.expr(e.expr), c.remap(e.deco)}
+	case Assign:
+		return Assign{e.name, c.expr(e.expr), c.remap(e.deco)}
+	case Store:
+		return Store{c.expr(e.array), c.expr(e.index), c.expr(e.expr), c.remap(e.deco)}
+	case FunCall:
+		return c.expr(e).(Statement)
+	case While:
+		return While{c.expr(e.expr), c.stats(e.body), c.remap(e.deco)}
+	case IfThenElse:
+		return IfThenElse{c.expr(e.expr), c.stats(e.ibody), c.stats(e.ebody), c.remap(e.deco)}
+	case Switch:
+		cases := []Case{}
+		for _, k := range e.cases {
+			values := []Expression{}
+			for _, v := range k.values {
+				values = append(values, c.expr(v))
+			}
+			cases = append(cases, Case{values, c.stats(k.body), c.remap(k.deco)})
+		}
+		return Switch{c.expr(e.expr), cases, c.remap(e.deco)}
+	case Inline:
+		return c.expr(e).(Statement)
+	}
+	panic(fmt.Sprint("Unknown statement type", n))
+}
+
+func (c copier) expr(n Expression) Expression {
+	switch e := n.(type) {
+	case ArithOp:
+		return ArithOp{e.op, c.expr(e.left), c.expr(e.right), c.remap(e.deco)}
+	case LogicOp:
+		return LogicOp{e.op, c.expr(e.left), c.expr(e.right), c.remap(e.deco)}
+	case Integer:
+		return Integer{e.value, c.remap(e.deco)}
+	case Float:
+		return Float{e.value, c.remap(e.deco)}
+	case Boolean:
+		return Boolean{e.value, c.remap(e.deco)}
+	case String:
+		return String{e.value, c.remap(e.deco)}
+	case Var:
+		if r, ok := c.subst[keyOf(e.deco)]; ok {
+			return copier{remap: clone}.expr(r)
+		}
+		return Var{e.name, c.remap(e.deco)}
+	case Convert:
+		return Convert{c.expr(e.expr), c.remap(e.deco)}
+	case New:
+		return New{c.expr(e.size), c.remap(e.deco)}
+	case Index:
+		return Index{c.expr(e.array), c.expr(e.index), c.remap(e.deco)}
+	case FunRef:
+		return FunRef{e.name, c.remap(e.deco)}
+	case FunCall:
+		args := []Expression{}
+		for _, arg := range e.args {
+			args = append(args, c.expr(arg))
+		}
+		deco := c.remap(e.deco)
+		delete(deco, "tail") // the copy does not end the function it was taken from
+		if closure, ok := deco["closure"].(Var); ok {
+			deco["closure"] = c.expr(closure)
+		}
+		return FunCall{e.name, args, deco}
+	case Inline:
+		var expr Expression
+		if e.expr != nil {
+			expr = c.expr(e.expr)
+		}
+		return Inline{c.stats(e.body), expr, c.remap(e.deco)}
+	}
+	panic(fmt.Sprint("Unknown expression type", n))
+}
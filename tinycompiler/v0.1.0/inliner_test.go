@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+// the inlined program must behave as the original one
+func TestInline(t *testing.T) {
+	source := `main() {
+    int x;
+    long y;
+
+    int sign(int v) {
+        if v < 0 {
+            return -1;
+        }
+        if v == 0 {
+            return 0;
+        }
+        return 1;
+    }
+
+    int twice(int a) {
+        a = a * 2;
+        return a;
+    }
+
+    int bump() {
+        x = x + 10;
+        return x;
+    }
+
+    int sub(int a, int b) {
+        return a - b;
+    }
+
+    long widen(int a) {
+        return long(a) << 32;
+    }
+
+    show(int a) {
+        print "show ";
+        println a;
+    }
+
+    int fact(int n) {
+        if n <= 1 {
+            return 1;
+        }
+        return n * fact(n - 1);
+    }
+
+    int f(int a) {
+        return a + 1;
+    }
+
+    int f(bool b) {
+        if b {
+            return 10;
+        }
+        return 20;
+    }
+
+    long f(long a) {
+        return a * 3L;
+    }
+
+    int outer() {
+        int inner(int n) { // recursive, so it keeps its own frame one level below the overloads
+            if n > 0 {
+                return inner(n - 1);
+            }
+            return f(x) + f(n == 0) + int(f(4L));
+        }
+        return inner(2);
+    }
+
+    x = 5;
+    println sign(-7) + sign(0) * 10 + sign(x) * 100;
+    println twice(x);
+    println x;
+    println sub(x, bump());
+    println sub(bump(), x);
+    y = widen(3);
+    println y;
+    show(sub(2, 3));
+    println fact(5);
+    println outer();
+}
+`
+	expected := "99\n10\n5\n-10\n0\n12884901888\nshow -1\n120\n48\n"
+
+	fun := (&WendParser{}).Parse(tokenize(source)).(Function)
+	buildSymtable(fun)
+	inline(&fun)
+	labels := map[string]string{} // the labels of the functions, the overloads by their types
+	visitFuns(fun, func(f Function) {
+		labels[signatureOf(f.name, f.deco)] = f.deco["label"].(string)
+	})
+	calls := map[string]int{} // the calls left and the labels inlined, by caller
+	record := func(caller string, body []Statement) {
+		visitExprs(body, func(e Expression) {
+			switch e := e.(type) {
+			case FunCall:
+				calls[caller+" calls "+e.name]++
+			case Inline:
+				for name, label := range labels {
+					if e.deco["callee"] == label {
+						calls[caller+" inlines "+name]++
+					}
+				}
+			}
+		})
+	}
+	record("main", fun.body)
+	visitFuns(fun, func(f Function) {
+		if f.name == "fact" || f.name == "inner" {
+			record(f.name, f.body)
+		}
+	})
+	for call, count := range map[string]int{
+		"main calls fact":             1,
+		"main calls outer":            1, // it has a nested function
+		"main calls sign":             0,
+		"main inlines sign(int): int": 3,
+		"fact calls fact":             1,
+		"inner calls inner":           1,
+		"inner calls f":               0,
+		"inner inlines f(int): int":   1,
+		"inner inlines f(bool): int":  1,
+		"inner inlines f(long): long": 1,
+	} {
+		if calls[call] != count {
+			t.Errorf("expected %q %d times, got %v", call, count, calls)
+		}
+	}
+
+	if _, err := exec.LookPath("as"); err != nil {
+		t.Skip("GNU as is not available")
+	}
+	for _, opts := range []buildOptions{{}, {noInline: true}} {
+		dir := t.TempDir()
+		exename, _, err := build(writeSource(t, dir, "inline", source), dir, opts)
+		if err != nil {
+			t.Fatalf("build failed: %s", err)
+		}
+		out, err := exec.Command(exename).Output()
+		if err != nil {
+			t.Fatalf("fail to exec program: %s", err)
+		}
+		if string(out) != expected {
+			t.Errorf("noInline=%t: expected: %q, got: %q", opts.noInline, expected, out)
+		}
+	}
+}
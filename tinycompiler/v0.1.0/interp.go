@@ -0,0 +1,551 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The interpreter executes the decorated tree without the assembler. It keeps the model of the compiled code: every
+// module has its own display, a frame holds one word per offset (a long or a float takes the first of its two words),
+// the variables are found through the display by their level and offset, a closure carries the display entries of
+// the ancestors of its function. The values are int32, int64, float64, bool, string, *closure and []any for an array.
+type frame struct {
+	fun    Function
+	mod    *module
+	slots  []any
+	static *frame // the frame of the enclosing function, found in the display when the call started
+	caller *frame // the frame of the calling function
+	saved  *frame // the display entry replaced by this frame, restored at the return
+	lineno int    // the line of the statement being executed
+}
+
+type module struct {
+	display []*frame
+}
+
+type closure struct {
+	fun      Function
+	mod      *module
+	captured map[int]*frame // display entries of the ancestors, by level
+}
+
+// an error of the running program, e.g. a division by zero
+type runtimeError struct {
+	msg    string
+	lineno int
+}
+
+func (e runtimeError) Error() string {
+	return fmt.Sprintf("%s, line %d", e.msg, e.lineno)
+}
+
+type machine struct {
+	out   io.Writer
+	funs  map[string]Function // the functions by label, the exported ones by their global label as well
+	mods  map[string]*module
+	cur   *frame // the innermost frame
+	depth int
+	step  func(s Statement) // called before every statement, the current frame is m.cur
+	gfx   *screen           // the state of the builtins, created by the first call
+}
+
+// the number of nested calls allowed before the program is stopped, the interpreter needs the Go stack for them
+const maxDepth = 100000
+
+func newMachine(out io.Writer) *machine {
+	return &machine{out: out, funs: map[string]Function{}, mods: map[string]*module{}}
+}
+
+// register the functions of a decorated tree, they belong to the module
+func (m *machine) load(root Function, mod *module) {
+	visitFuns(root, func(f Function) {
+		m.funs[f.deco["label"].(string)] = f
+		m.mods[f.deco["label"].(string)] = mod
+		if global, ok := f.deco["global"].(string); ok {
+			m.funs[global] = f
+			m.mods[global] = mod
+		}
+	})
+}
+
+func zeroValue(t Type) any {
+	switch t {
+	case INT:
+		return int32(0)
+	case LONG:
+		return int64(0)
+	case FLOAT:
+		return float64(0)
+	case BOOL:
+		return false
+	}
+	if t.isArray() { // no array, its length is 0
+		return []any(nil)
+	}
+	return nil
+}
+
+func newFrame(f Function, mod *module) *frame {
+	fr := &frame{fun: f, mod: mod, slots: make([]any, f.deco["varCnt"].(int)), lineno: f.deco["lineno"].(int)}
+	for _, v := range append(append([]Var{}, f.args...), f.vars...) {
+		fr.slots[v.deco["offset"].(int)] = zeroValue(v.deco["type"].(Type))
+	}
+	return fr
+}
+
+// install the frame in the display of its module
+func (m *machine) enter(fr *frame) {
+	level := fr.fun.deco["level"].(int)
+	for len(fr.mod.display) <= level {
+		fr.mod.display = append(fr.mod.display, nil)
+	}
+	if level > 0 {
+		fr.static = fr.mod.display[level-1]
+	}
+	fr.saved, fr.caller = fr.mod.display[level], m.cur
+	fr.mod.display[level] = fr
+	m.cur = fr
+}
+
+func (m *machine) leave(fr *frame) {
+	fr.mod.display[fr.fun.deco["level"].(int)] = fr.saved
+	m.cur = fr.caller
+}
+
+// analyze the program and the libraries it imports, directly or not; the libraries are returned by path
+func loadProgram(path string) (Function, map[string]Function) {
+	root, queue := loadModule(path)
+	buildSymtable(root)
+	libs := map[string]Function{}
+	for len(queue) > 0 {
+		dep := filepath.Clean(queue[0])
+		queue = queue[1:]
+		if _, ok := libs[dep]; ok || dep == filepath.Clean(path) {
+			continue
+		}
+		lib, deps := loadModule(dep)
+		buildSymtable(lib)
+		libs[dep] = lib
+		queue = append(queue, deps...)
+	}
+	return root, libs
+}
+
+// run the main function of a program, every library gets a module of its own
+func (m *machine) run(root Function, libs map[string]Function) {
+	for _, lib := range libs {
+		m.load(lib, &module{})
+	}
+	mod := &module{}
+	m.load(root, mod)
+	fr := newFrame(root, mod)
+	m.enter(fr)
+	defer m.leave(fr)
+	m.body(root.body)
+	if m.gfx != nil {
+		fmt.Fprint(m.out, m.gfx.exit())
+	}
+}
+
+type flow int
+
+const (
+	next flow = iota
+	returned
+	tail // the value is the tail call, the frame of the caller is replaced
+)
+
+type tailCall struct {
+	fun  Function
+	args []any
+}
+
+// call the function with the display entries of the closure, if any
+func (m *machine) call(f Function, mod *module, captured map[int]*frame, args []any) any {
+	if m.depth++; m.depth > maxDepth {
+		panic(runtimeError{"stack overflow", m.cur.lineno})
+	}
+	defer func() { m.depth-- }()
+	for level, entry := range captured { // restore the static context captured by the closure
+		defer func(level int, saved *frame) { mod.display[level] = saved }(level, mod.display[level])
+		mod.display[level] = entry
+	}
+	for {
+		fr := newFrame(f, mod)
+		for i, arg := range f.args {
+			fr.slots[arg.deco["offset"].(int)] = args[i]
+		}
+		m.enter(fr)
+		result, value := m.body(f.body)
+		m.leave(fr)
+		if result != tail {
+			return value
+		}
+		f, args = value.(tailCall).fun, value.(tailCall).args
+	}
+}
+
+func (m *machine) body(ss []Statement) (flow, any) {
+	for _, s := range ss {
+		if result, value := m.stat(s); result != next {
+			return result, value
+		}
+	}
+	return next, nil
+}
+
+func (m *machine) stat(n Statement) (flow, any) {
+	m.cur.lineno = statDeco(n)["lineno"].(int)
+	if m.step != nil {
+		m.step(n)
+	}
+	switch e := n.(type) {
+	case Print:
+		v := m.expr(e.expr)
+		fmt.Fprint(m.out, valueString(v))
+		if e.newline {
+			fmt.Fprintln(m.out)
+		}
+	case Return:
+		if e.expr == nil {
+			return returned, nil
+		}
+		if call, ok := e.expr.(FunCall); ok && call.deco["tail"] != nil {
+			return tail, m.tailCall(call)
+		}
+		return returned, m.expr(e.expr)
+	case Assign:
+		v := m.expr(e.expr)
+		m.cur.mod.display[e.deco["level"].(int)].slots[e.deco["offset"].(int)] = v
+	case Store:
+		a, i := m.expr(e.array).([]any), m.expr(e.index).(int32)
+		v := m.expr(e.expr)
+		a[checkIndex(a, i, e.deco["lineno"].(int))] = v
+	case FunCall:
+		if e.deco["tail"] != nil { // a procedure call followed by the end of the procedure
+			return tail, m.tailCall(e)
+		}
+		m.expr(e)
+	case While:
+		for m.expr(e.expr).(bool) {
+			if result, value := m.body(e.body); result != next {
+				return result, value
+			}
+		}
+	case IfThenElse:
+		if m.expr(e.expr).(bool) {
+			return m.body(e.ibody)
+		}
+		return m.body(e.ebody)
+	case Switch:
+		return m.body(caseBody(e, int(m.expr(e.expr).(int32))))
+	default:
+		panic(fmt.Sprint("Unknown statement type", e))
+	}
+	return next, nil
+}
+
+// the body of the case holding the value, or of the default case; none without a default case
+func caseBody(e Switch, value int) []Statement {
+	var body []Statement
+	for _, c := range e.cases {
+		if len(c.values) == 0 {
+			body = c.body
+		}
+		for _, v := range c.values {
+			if v.(Integer).value == value {
+				return c.body
+			}
+		}
+	}
+	return body
+}
+
+func (m *machine) args(e FunCall) []any {
+	args := []any{}
+	for _, arg := range e.args {
+		args = append(args, m.expr(arg))
+	}
+	return args
+}
+
+func (m *machine) tailCall(e FunCall) tailCall {
+	return tailCall{m.funs[e.deco["label"].(string)], m.args(e)}
+}
+
+func (m *machine) expr(n Expression) any {
+	switch e := n.(type) {
+	case ArithOp:
+		return m.binary(e.op, m.expr(e.left), m.expr(e.right), e.deco)
+	case LogicOp:
+		switch e.op {
+		case "&&":
+			return m.expr(e.left).(bool) && m.expr(e.right).(bool)
+		case "||":
+			return m.expr(e.left).(bool) || m.expr(e.right).(bool)
+		}
+		return m.binary(e.op, m.expr(e.left), m.expr(e.right), e.deco)
+	case Integer:
+		if e.deco["type"] == LONG {
+			return int64(e.value)
+		}
+		return int32(e.value)
+	case Float:
+		return e.value
+	case Boolean:
+		return e.value
+	case String:
+		if s, err := strconv.Unquote(`"` + e.value + `"`); err == nil {
+			return s
+		}
+		return e.value
+	case Var:
+		return m.cur.mod.display[e.deco["level"].(int)].slots[e.deco["offset"].(int)]
+	case Convert:
+		return convertValue(m.expr(e.expr), e.deco["type"].(Type))
+	case New:
+		return newArray(e.deco["type"].(Type), m.expr(e.size).(int32), e.deco["lineno"].(int))
+	case Index:
+		a, i := m.expr(e.array).([]any), m.expr(e.index).(int32)
+		return a[checkIndex(a, i, e.deco["lineno"].(int))]
+	case FunRef:
+		fundeco := e.deco["fundeco"].(map[string]any)
+		label := fundeco["label"].(string)
+		c := &closure{m.funs[label], m.mods[label], map[int]*frame{}}
+		if fundeco["import"] != true {
+			for _, level := range fundeco["ancestors"].([]int) {
+				c.captured[level] = m.cur.mod.display[level]
+			}
+		}
+		return c
+	case FunCall:
+		if e.deco["extern"] == true {
+			panic(runtimeError{"cannot call the extern function " + e.name, e.deco["lineno"].(int)})
+		}
+		if e.deco["builtin"] == true {
+			return m.builtin(e.name, m.args(e))
+		}
+		if e.deco["indirect"] == true {
+			c := m.expr(e.deco["closure"].(Var)).(*closure)
+			return m.call(c.fun, c.mod, c.captured, m.args(e))
+		}
+		label := e.deco["label"].(string)
+		if _, ok := m.funs[label]; !ok {
+			panic(runtimeError{"the function " + e.name + " is not loaded", e.deco["lineno"].(int)})
+		}
+		return m.call(m.funs[label], m.mods[label], nil, m.args(e))
+	default:
+		panic(fmt.Sprint("Unknown expression type", e))
+	}
+}
+
+func (m *machine) builtin(name string, args []any) any {
+	if name == "len" {
+		return int32(len(args[0].([]any)))
+	}
+	if m.gfx == nil {
+		m.gfx = newScreen()
+	}
+	switch name {
+	case "set_pixel":
+		m.gfx.setPixel(args[0].(int32), args[1].(int32), args[2].(int32), args[3].(int32), args[4].(int32))
+	case "present":
+		fmt.Fprint(m.out, m.gfx.present())
+	case "clear":
+		m.gfx.clear()
+	case "sleep_ms":
+		time.Sleep(time.Duration(args[0].(int32)) * time.Millisecond)
+	case "rand":
+		return m.gfx.rand()
+	}
+	return nil
+}
+
+func (m *machine) binary(op string, a, b any, deco map[string]any) any {
+	divide := func(zero bool) {
+		if zero && (op == "/" || op == "%") {
+			panic(runtimeError{"division by zero", deco["lineno"].(int)})
+		}
+	}
+	switch a := a.(type) {
+	case int32:
+		b := b.(int32)
+		divide(b == 0)
+		switch op {
+		case "+":
+			return a + b
+		case "-":
+			return a - b
+		case "*":
+			return a * b
+		case "/":
+			if b == -1 { // no overflow trap
+				return -a
+			}
+			return a / b
+		case "%":
+			if b == -1 {
+				return int32(0)
+			}
+			return a % b
+		case "&":
+			return a & b
+		case "|":
+			return a | b
+		case "^":
+			return a ^ b
+		case "<<": // the count is masked like the i386 does
+			return a << (b & 31)
+		case ">>":
+			return a >> (b & 31)
+		case ">>>":
+			return int32(uint32(a) >> (b & 31))
+		}
+		return compareValues(op, a < b, a == b)
+	case int64:
+		b := b.(int64)
+		divide(b == 0)
+		switch op {
+		case "+":
+			return a + b
+		case "-":
+			return a - b
+		case "*":
+			return a * b
+		case "/":
+			if b == -1 {
+				return -a
+			}
+			return a / b
+		case "%":
+			if b == -1 {
+				return int64(0)
+			}
+			return a % b
+		case "&":
+			return a & b
+		case "|":
+			return a | b
+		case "^":
+			return a ^ b
+		case "<<":
+			return a << (b & 63)
+		case ">>":
+			return a >> (b & 63)
+		case ">>>":
+			return int64(uint64(a) >> (b & 63))
+		}
+		return compareValues(op, a < b, a == b)
+	case float64:
+		b := b.(float64)
+		switch op {
+		case "+":
+			return a + b
+		case "-":
+			return a - b
+		case "*":
+			return a * b
+		case "/":
+			return a / b
+		}
+		return compareValues(op, a < b, a == b)
+	case bool:
+		return compareValues(op, false, a == b.(bool))
+	}
+	panic(fmt.Sprintf("Invalid operands %v %s %v", a, op, b))
+}
+
+func compareValues(op string, less, equal bool) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	case ">=":
+		return !less
+	case "==":
+		return equal
+	case "!=":
+		return !equal
+	}
+	panic("Unknown binary operation")
+}
+
+func convertValue(v any, to Type) any {
+	switch v := v.(type) {
+	case int32:
+		switch to {
+		case LONG:
+			return int64(v)
+		case FLOAT:
+			return float64(v)
+		}
+	case int64:
+		switch to {
+		case INT:
+			return int32(v)
+		case FLOAT:
+			return float64(v)
+		}
+	case float64: // saturated to the range of the integer type, NaN is 0
+		switch {
+		case math.IsNaN(v) && (to == INT || to == LONG):
+			return convertValue(int32(0), to)
+		case to == INT:
+			return int32(max(math.MinInt32, min(math.MaxInt32, v)))
+		case to == LONG && v >= math.MaxInt64: // 2^63, the largest int64 is not a float64
+			return int64(math.MaxInt64)
+		case to == LONG:
+			return int64(max(math.MinInt64, v))
+		}
+	}
+	return v
+}
+
+// the text printed for the value, the same as the runtime of the compiled programs prints
+func valueString(v any) string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case float64: // |x| rounded to 6 decimals, then the sign bit; from 10^12 on with an exponent
+		sign := ""
+		if math.Signbit(v) {
+			sign = "-"
+		}
+		switch {
+		case math.IsNaN(v):
+			return "nan"
+		case math.IsInf(v, 0):
+			return sign + "inf"
+		case math.Abs(v) >= 1e12:
+			return sign + strconv.FormatFloat(math.Abs(v), 'e', 6, 64)
+		}
+		ip, f := math.Modf(math.Abs(v)) // apart, the digits of the integer part are exact
+		n := int64(ip)*1000000 + int64(math.RoundToEven(f*1000000))
+		return fmt.Sprintf("%s%d.%06d", sign, n/1000000, n%1000000)
+	case *closure:
+		return "<fun " + v.fun.name + ">"
+	case []any:
+		if v == nil {
+			return "<undefined>"
+		}
+		elems := []string{}
+		for _, x := range v {
+			elems = append(elems, valueString(x))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case nil:
+		return "<undefined>"
+	}
+	return fmt.Sprint(v)
+}
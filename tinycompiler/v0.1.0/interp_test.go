@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the interpreter must print the same as the compiled programs
+func TestInterp(t *testing.T) {
+	expectedFiles, _ := filepath.Glob(filepath.Join(rootpath, "test-programs", "*", "*.expected"))
+	for _, expectedFile := range expectedFiles {
+		base := strings.TrimSuffix(filepath.Base(expectedFile), ".expected")
+		t.Run(base, func(t *testing.T) {
+			expected, err := os.ReadFile(expectedFile)
+			if err != nil {
+				t.Fatalf("Error read expected file: %s\n", err)
+			}
+			var out bytes.Buffer
+			m := newMachine(&out)
+			m.run(loadProgram(strings.TrimSuffix(expectedFile, ".expected") + ".wend"))
+			if out.String() != string(expected) {
+				t.Errorf("expected: %s, got: %s\n", expected, out.String())
+			}
+		})
+	}
+}
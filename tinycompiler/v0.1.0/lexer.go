@@ -2,20 +2,25 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"unicode"
 )
 
 var (
-	Keywords   = map[string]string{"true": "BOOLEAN", "false": "BOOLEAN", "print": "PRINT", "println": "PRINT", "int": "TYPE", "bool": "TYPE", "if": "IF", "else": "ELSE", "while": "WHILE", "return": "RETURN"}
-	DoubleChar = map[string]string{"==": "COMP", "<=": "COMP", ">=": "COMP", "!=": "COMP", "&&": "AND", "||": "OR"}
-	SingleChar = map[string]string{"=": "ASSIGN", "<": "COMP", ">": "COMP", "!": "NOT", "+": "PLUS", "-": "MINUS", "/": "DIVIDE", "*": "TIMES", "%": "MOD", "(": "LPAREN", ")": "RPAREN", "{": "BEGIN", "}": "END", ";": "SEMICOLON", ",": "COMMA", ":": "COLON"}
-	Tokens     = map[string]bool{"ID": true, "STRING": true, "INTEGER": true}
+	Keywords   = map[string]string{"true": "BOOLEAN", "false": "BOOLEAN", "print": "PRINT", "println": "PRINT", "int": "TYPE", "bool": "TYPE", "long": "TYPE", "float": "TYPE", "if": "IF", "else": "ELSE", "while": "WHILE", "return": "RETURN", "fun": "FUN", "import": "IMPORT", "export": "EXPORT", "extern": "EXTERN", "const": "CONST", "switch": "SWITCH", "case": "CASE", "default": "DEFAULT", "new": "NEW"}
+	TripleChar = map[string]string{">>>": "SHIFT"}
+	DoubleChar = map[string]string{"==": "COMP", "<=": "COMP", ">=": "COMP", "!=": "COMP", "&&": "AND", "||": "OR", "<<": "SHIFT", ">>": "SHIFT"}
+	SingleChar = map[string]string{"=": "ASSIGN", "<": "COMP", ">": "COMP", "!": "NOT", "+": "PLUS", "-": "MINUS", "/": "DIVIDE", "*": "TIMES", "%": "MOD", "(": "LPAREN", ")": "RPAREN", "[": "LBRACKET", "]": "RBRACKET", "{": "BEGIN", "}": "END", ";": "SEMICOLON", ",": "COMMA", ":": "COLON", "&": "BITAND", "|": "BITOR", "^": "BITXOR", "~": "BITNOT"}
+	Tokens     = map[string]bool{"ID": true, "STRING": true, "INTEGER": true, "LONGINT": true, "REAL": true}
 )
 
 func init() {
 	for _, v := range Keywords {
 		Tokens[v] = true
 	}
+	for _, v := range TripleChar {
+		Tokens[v] = true
+	}
 	for _, v := range DoubleChar {
 		Tokens[v] = true
 	}
@@ -51,6 +56,10 @@ func tokenize(text string) []Token {
 		if idx < len(text)-1 {
 			sym2 = text[idx+1]
 		}
+		sym3 := byte(' ') // the one after
+		if idx < len(text)-2 {
+			sym3 = text[idx+2]
+		}
 
 		switch state {
 		case 0: // start scanning a new token
@@ -64,6 +73,9 @@ func tokenize(text string) []Token {
 			} else if unicode.IsLetter(rune(sym1)) || sym1 == '_' { // start a word scan
 				state = 4
 				accum += string(sym1)
+			} else if typ, ok := TripleChar[string(sym1)+string(sym2)+string(sym3)]; ok { // emit three-character token
+				tokens = append(tokens, Token{typ, string(sym1) + string(sym2) + string(sym3), lineno})
+				idx += 2
 			} else if typ, ok := DoubleChar[string(sym1)+string(sym2)]; ok { // emit two-character token
 				tokens = append(tokens, Token{typ, string(sym1) + string(sym2), lineno})
 				idx++
@@ -75,6 +87,15 @@ func tokenize(text string) []Token {
 		case 2: // scanning a number
 			if unicode.IsDigit(rune(sym1)) { // is next character a digit?
 				accum += string(sym1) // if yes, continue
+			} else if sym1 == '.' && unicode.IsDigit(rune(sym2)) && !strings.Contains(accum, ".") { // fractional part
+				accum += string(sym1)
+			} else if strings.Contains(accum, ".") {
+				tokens = append(tokens, Token{"REAL", accum, lineno})
+				idx--
+				state, accum = 0, ""
+			} else if sym1 == 'L' { // suffix of a long literal
+				tokens = append(tokens, Token{"LONGINT", accum, lineno})
+				state, accum = 0, ""
 			} else {
 				tokens = append(tokens, Token{"INTEGER", accum, lineno}) // otherwise, emit number token
 				idx--
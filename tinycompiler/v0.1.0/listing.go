@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The listing is the assembly of a module annotated for reading: a source line is written as a comment before the
+// instructions generated for it, a function is headed by its signature, and the loads and stores of the variables
+// name the variable. Like the coverage counters, the annotations are attached to the decorations and expanded by
+// transasm. They are attached once the calls are inlined, an inlined body shows the lines of its callee.
+func listInstrument(root Function, path string) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot read %s: %v", path, err))
+	}
+	lines := strings.Split(string(source), "\n")
+	listed := -1 // shared by the comments of the module
+	line := func(deco map[string]any) {
+		if lineno, ok := deco["lineno"].(int); ok && lineno < len(lines) { // the statements made by the inliner have no line
+			deco["listing"] = listing{fmt.Sprintf("# %d: %s\n", lineno+1, strings.TrimRight(lines[lineno], " \t\r")), &listed}
+		}
+	}
+	stat := func(s Statement) {
+		line(statDeco(s))
+		switch e := s.(type) {
+		case Assign:
+			e.deco["listname"] = e.name
+		case Switch:
+			for _, c := range e.cases {
+				line(c.deco)
+			}
+		}
+	}
+	visitFuns(root, func(f Function) {
+		if f.deco["library"] != true {
+			f.deco["listing"] = listing{fmt.Sprintf("# %s\n", signatureOf(f.name, f.deco)), &listed}
+		}
+		visitStats(f.body, stat)
+		visitExprs(f.body, func(e Expression) {
+			switch e := e.(type) {
+			case Var:
+				e.deco["listname"] = e.name
+			case Inline: // the statements of an inlined body are found in the expressions
+				visitStats(e.body, stat)
+			}
+		})
+	})
+}
+
+// a source comment and the line of the last comment written for the module, a line is listed once for the
+// statements sharing it
+type listing struct {
+	comment string
+	listed  *int
+}
+
+// the source line attached to the decoration, if any
+func listasm(deco map[string]any) string {
+	l, ok := deco["listing"].(listing)
+	if !ok || deco["lineno"] == *l.listed {
+		return ""
+	}
+	*l.listed = deco["lineno"].(int)
+	return l.comment
+}
+
+// the name of the variable accessed, as a comment ending the instruction
+func listname(deco map[string]any) string {
+	if name, ok := deco["listname"].(string); ok {
+		return "\t# " + name
+	}
+	return ""
+}
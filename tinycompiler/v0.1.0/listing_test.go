@@ -0,0 +1,68 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestListing(t *testing.T) {
+	source := `main() {
+    int x;
+    long big;
+    int twice(int a) {
+        return a * 2;
+    }
+    x = twice(3); big = 1L;
+    while x > 0 {
+        x = x - 4;
+    }
+    println x;
+    println big;
+}
+`
+	path := writeSource(t, t.TempDir(), "listing", source)
+	listing, _, _ := compileModule(path, buildOptions{emit: "listing", noInline: true})
+	for _, s := range []string{
+		"# main()\n",
+		"# twice(int): int\n",
+		"# 5:         return a * 2;\n",
+		"# 7:     x = twice(3); big = 1L;\n",
+		"# 8:     while x > 0 {\n",
+		"(%eax), %eax\t# a\n",
+		"(%eax)\t# x\n",
+		"(%ecx), %eax\t# big\n",
+	} {
+		if !strings.Contains(listing, s) {
+			t.Errorf("the listing lacks %q:\n%s", s, listing)
+		}
+	}
+	if strings.Count(listing, "# 7:") != 1 {
+		t.Errorf("the line 7 must be listed once:\n%s", listing)
+	}
+
+	// the annotations are comments, the code is the assembly
+	asm, _, _ := compileModule(path, buildOptions{noInline: true})
+	code := func(s string) string {
+		lines := []string{}
+		for _, line := range strings.Split(s, "\n") {
+			if !strings.HasPrefix(line, "#") {
+				lines = append(lines, strings.TrimSpace(regexp.MustCompile(`\s*#.*`).ReplaceAllString(line, "")))
+			}
+		}
+		return regexp.MustCompile(`uniqstr\d+`).ReplaceAllString(strings.Join(lines, "\n"), "uniqstr")
+	}
+	if code(listing) != code(asm) {
+		t.Errorf("the listing differs from the assembly")
+	}
+
+	// a module does not inherit the last line listed by the previous one: other ends with the line 4, where twice
+	// is listed first
+	other := writeSource(t, t.TempDir(), "other", "main() {\n    int y;\n\n    y = 1;\n}\n")
+	if listed, _, _ := compileModule(other, buildOptions{emit: "listing", noInline: true}); !strings.Contains(listed, "# 4:") {
+		t.Errorf("the listing of the other module lacks the line 4:\n%s", listed)
+	}
+	if again, _, _ := compileModule(path, buildOptions{emit: "listing", noInline: true}); !strings.Contains(again, "# twice(int): int\n") {
+		t.Errorf("the listing after another module lacks the signature of twice:\n%s", again)
+	}
+}
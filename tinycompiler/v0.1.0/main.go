@@ -1,48 +1,372 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 )
 
+// exit codes of the driver
+const (
+	exitCompile = 1 // the program is rejected by the compiler
+	exitUsage   = 2 // bad command line, or a file cannot be read or written
+	exitTools   = 3 // the assembler or the linker failed
+)
+
+const usage = `Usage: ./compiler [options] path/source.wend [object.o ...]
+       ./compiler vet path/source.wend
+       ./compiler cover [-html] path/source.wend
+       ./compiler repl
+       ./compiler debug path/source.wend
+       ./compiler fuzz [-n count] [-seed n] [-o dir] [-no-reduce]
+Options:
+`
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: ./compiler path/source.wend")
-		return
+	if len(os.Args) > 2 && os.Args[1] == "vet" {
+		os.Exit(vetFile(os.Args[2]))
+	}
+	if len(os.Args) > 2 && os.Args[1] == "cover" {
+		os.Exit(coverFile(os.Args[len(os.Args)-1], os.Args[2] == "-html"))
+	}
+	if len(os.Args) == 2 && os.Args[1] == "repl" {
+		info, err := os.Stdin.Stat()
+		os.Exit(repl(os.Stdin, os.Stdout, err == nil && info.Mode()&os.ModeCharDevice != 0))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fuzz" {
+		os.Exit(fuzz(os.Args[2:]))
+	}
+	if len(os.Args) == 3 && os.Args[1] == "debug" {
+		info, err := os.Stdin.Stat()
+		os.Exit(debugFile(os.Args[2], os.Stdin, os.Stdout, err == nil && info.Mode()&os.ModeCharDevice != 0))
+	}
+	os.Exit(compile(os.Args[1:]))
+}
+
+var dumps = map[string]func(Function) string{"ast-json": dumpJSON, "ast-dot": dumpDOT, "symbols": dumpSymbols, "call-graph": dumpCallGraph}
+
+// the extension of the output file for every kind of output
+var emitExt = map[string]string{"exe": "", "obj": ".o", "asm": ".asm", "py": ".py", "py-novars": ".py", "listing": ".lst"}
+
+type buildOptions struct {
+	emit       string   // the output: exe (the default), obj, asm and listing for the source file only, py or py-novars
+	libc       bool     // link against the C library, its runtime calls the program as main
+	noInline   bool     // keep all the calls
+	noPeephole bool     // keep the assembly as the templates produce it
+	verbose    bool     // report the work of the optimizations to stderr
+	profile    bool     // count the calls and the cycles spent in every function, report them at the exit
+	coverage   bool     // count the executions of every statement and branch, write them to a file at the exit
+	maxStack   int      // reject the program if its stack use may exceed this many bytes, 0 for no limit
+	objects    []string // extra objects to link, e.g. compiled C code
+}
+
+// the assembler or the linker failed, the error holds their output
+type toolError struct {
+	cmd *exec.Cmd
+	err error
+	out []byte
+}
+
+func (e *toolError) Error() string {
+	return fmt.Sprintf("error running command: %s: %v\n%s", strings.Join(e.cmd.Args, " "), e.err, e.out)
+}
+
+func runTool(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return &toolError{cmd, err, out}
+	}
+	return nil
+}
+
+// parse the command line, compile, and return the exit code; the flags may follow the positional arguments
+func compile(args []string) (code int) {
+	opts := buildOptions{}
+	flags := flag.NewFlagSet("compiler", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	output := flags.String("o", "", "write the output to `file`, - for the standard output of a text output; by default it is named after the source file")
+	emit := flags.String("emit", "exe", "the output: exe, obj, asm, listing, py, py-novars, or a dump: ast-json, ast-dot, symbols, call-graph")
+	asmOnly := flags.Bool("S", false, "same as --emit=asm")
+	objOnly := flags.Bool("c", false, "same as --emit=obj")
+	keepTemps := flags.Bool("keep-temps", false, "keep the intermediate files next to the output")
+	flags.BoolVar(&opts.libc, "libc", false, "link against the C library")
+	flags.BoolVar(&opts.noInline, "no-inline", false, "do not inline the calls")
+	flags.BoolVar(&opts.noPeephole, "no-peephole", false, "do not optimize the generated assembly")
+	flags.BoolVar(&opts.verbose, "v", false, "report the work of the optimizations")
+	flags.BoolVar(&opts.profile, "profile", false, "report the calls and the time spent in every function at the exit")
+	flags.BoolVar(&opts.coverage, "coverage", false, "write the execution counts of the statements at the exit")
+	flags.IntVar(&opts.maxStack, "max-stack", 0, "reject the program if its stack use may exceed `bytes`")
+
+	var path string
+	for len(args) > 0 {
+		if err := flags.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return 0
+			}
+			return exitUsage
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		if strings.HasSuffix(args[0], ".o") {
+			opts.objects = append(opts.objects, args[0])
+		} else if path == "" {
+			path = args[0]
+		} else {
+			fmt.Fprintf(os.Stderr, "more than one source file: %s and %s\n", path, args[0])
+			return exitUsage
+		}
+		args = args[1:]
+	}
+	opts.emit = *emit
+	if *asmOnly {
+		opts.emit = "asm"
+	} else if *objOnly {
+		opts.emit = "obj"
+	}
+	_, isDump := dumps[opts.emit]
+	if _, ok := emitExt[opts.emit]; !ok && !isDump {
+		fmt.Fprintf(os.Stderr, "unknown output kind %q\n", opts.emit)
+		return exitUsage
+	}
+	if path == "" {
+		flags.Usage()
+		return exitUsage
+	}
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if (opts.profile || opts.coverage || opts.libc || len(opts.objects) > 0) && strings.HasPrefix(opts.emit, "py") {
+		fmt.Fprintln(os.Stderr, "--profile, --coverage, -libc and the objects apply to the native backend only")
+		return exitUsage
+	}
+	toStdout := *output == "-"
+	if toStdout && (opts.emit == "exe" || opts.emit == "obj") {
+		fmt.Fprintln(os.Stderr, "-o - applies to the text outputs only, not to the executables and the objects")
+		return exitUsage
+	}
+	if opts.maxStack > 0 && (opts.profile || opts.coverage || opts.emit != "exe") {
+		fmt.Fprintln(os.Stderr, "--max-stack applies to the executables without --profile and --coverage only")
+		return exitUsage
+	}
+
+	defer func() { // the front end reports the errors in the source by panicking
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, r)
+			code = exitCompile
+		}
+	}()
+
+	if isDump {
+		ast, _ := loadModule(path)
+		buildSymtable(ast)
+		if *output == "" || toStdout {
+			fmt.Print(dumps[opts.emit](ast))
+			return 0
+		}
+		if err := os.WriteFile(*output, []byte(dumps[opts.emit](ast)), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		return 0
 	}
 
-	source, err := os.ReadFile(os.Args[1])
+	if *output == "" {
+		*output = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + emitExt[opts.emit]
+	}
+	if !toStdout && sameFile(*output, path) {
+		fmt.Fprintf(os.Stderr, "the output %s would overwrite the source file, name another one with -o\n", *output)
+		return exitUsage
+	}
+	dir := filepath.Dir(*output) // the Python modules of the imported files go next to the program
+	if !*keepTemps && (!strings.HasPrefix(opts.emit, "py") || toStdout) {
+		tmp, err := os.MkdirTemp("", "wend")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+	result, warnings, err := build(path, dir, opts)
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, w)
+	}
+	if err == nil && toStdout {
+		var text []byte
+		if text, err = os.ReadFile(result); err == nil {
+			_, err = os.Stdout.Write(text)
+		}
+	} else if err == nil {
+		err = moveFile(result, *output)
+	}
 	if err != nil {
-		fmt.Printf("failed to read %s: %v\n", os.Args[1], err)
-		return
+		fmt.Fprintln(os.Stderr, err)
+		var te *toolError
+		if errors.As(err, &te) {
+			return exitTools
+		}
+		return exitUsage
 	}
+	return 0
+}
 
-	tokens := tokenize(string(source))
-	ast := (&WendParser{}).Parse(tokens)
-	buildSymtable(ast)
-	asmProgram := transasm(ast.(Function))
+// the two paths name the same file, the first one need not exist
+func sameFile(a, b string) bool {
+	if ia, err := os.Stat(a); err == nil {
+		if ib, err := os.Stat(b); err == nil {
+			return os.SameFile(ia, ib)
+		}
+	}
+	absa, erra := filepath.Abs(a)
+	absb, errb := filepath.Abs(b)
+	return erra == nil && errb == nil && absa == absb
+}
+
+// rename the file, or copy it if it lies on another file system
+func moveFile(from, to string) error {
+	if filepath.Clean(from) == filepath.Clean(to) {
+		return nil
+	}
+	if os.Rename(from, to) == nil {
+		return nil
+	}
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// compile the source file to opts.emit in dir and return the path of the result and the warnings of the compiled
+// files. An executable needs the objects of all the imported files, a Python program needs their modules; an
+// assembly file or an object is produced for the source file only, the imports are not compiled.
+func build(path, dir string, opts buildOptions) (string, []string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to mkdir %s: %v", dir, err)
+	}
+	if opts.emit == "exe" && !opts.profile && !opts.coverage && (opts.maxStack > 0 || opts.verbose) {
+		checkStack(path, opts)
+	}
+	objects, warnings := []string{}, []string{}
+	warn := func(file string, ws []Warning) {
+		for _, w := range ws {
+			warnings = append(warnings, fmt.Sprintf("%s:%d: warning: %s", file, w.lineno+1, w.msg))
+		}
+	}
+	var program string
+	queue, seen := []string{path}, map[string]bool{path: true}
+	for len(queue) > 0 {
+		var deps []string
+		var ws []Warning
+		basename := strings.TrimSuffix(filepath.Base(queue[0]), filepath.Ext(queue[0]))
+		if strings.HasPrefix(opts.emit, "py") {
+			var source string
+			source, deps, ws = compilePython(queue[0], opts.emit == "py-novars")
+			warn(queue[0], ws)
+			if queue[0] != path { // imported by the module name
+				basename = moduleName(queue[0])
+			}
+			pyname := filepath.Join(dir, basename+".py")
+			if err := os.WriteFile(pyname, []byte(source), 0644); err != nil {
+				return "", warnings, fmt.Errorf("failed to write %s: %v", pyname, err)
+			}
+			if program == "" {
+				program = pyname
+			}
+		} else {
+			var asmProgram string
+			asmProgram, deps, ws = compileModule(queue[0], opts)
+			warn(queue[0], ws)
+			asmname := filepath.Join(dir, basename+".asm")
+			oname := filepath.Join(dir, basename+".o")
+			if err := os.WriteFile(asmname, []byte(asmProgram), 0644); err != nil {
+				return "", warnings, fmt.Errorf("failed to write %s: %v", asmname, err)
+			}
+			if opts.emit == "asm" || opts.emit == "listing" {
+				return asmname, warnings, nil
+			}
+			if err := runTool("as", "--march=i386+387", "--32", "-o", oname, asmname); err != nil {
+				return "", warnings, err
+			}
+			objects = append(objects, oname)
+			if opts.emit == "obj" {
+				return oname, warnings, nil
+			}
+		}
+		queue = queue[1:]
+		for _, dep := range deps {
+			if dep = filepath.Clean(dep); !seen[dep] {
+				seen[dep] = true
+				queue = append(queue, dep)
+			}
+		}
+	}
+	if program != "" {
+		return program, warnings, nil
+	}
+	objects = append(objects, opts.objects...)
+
+	exename := strings.TrimSuffix(objects[0], ".o")
+	if opts.libc { // the C compiler driver knows where the C runtime and the library are
+		return exename, warnings, runTool("cc", append([]string{"-m32", "-no-pie", "-o", exename}, objects...)...)
+	}
+	return exename, warnings, runTool("ld", append([]string{"-m", "elf_i386", "-o", exename}, objects...)...)
+}
 
-	basename := strings.TrimSuffix(filepath.Base(os.Args[1]), filepath.Ext(os.Args[1]))
-	oname := basename + ".o"
-	asmname := basename + ".asm"
-	err = os.MkdirAll("out", 0755)
+// report the static check warnings to stderr, the exit code is 1 if there are any
+func vetFile(path string) (code int) {
+	source, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Printf("failed to mkdir %s: %v\n", "out", err)
-		return
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", path, err)
+		return exitUsage
 	}
-	os.WriteFile(filepath.Join("out", asmname), []byte(asmProgram), 0644)
 
-	cmd1 := exec.Command("as", "--march=i386", "--32", "-o", filepath.Join("out", oname), filepath.Join("out", asmname))
-	if err := cmd1.Run(); err != nil {
-		fmt.Printf("error running command: as %s\n", err)
-		return
+	defer func() { // a program the compiler rejects is reported as compile does
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, r)
+			code = exitCompile
+		}
+	}()
+
+	ast, _ := loadModule(path)
+	buildSymtable(ast)
+	warnings := suppress(string(source), vet(ast))
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "%s:%d: %s\n", path, w.lineno+1, w.msg)
 	}
-	cmd2 := exec.Command("ld", "-m", "elf_i386", "-o", filepath.Join("out", basename), filepath.Join("out", oname))
-	if err := cmd2.Run(); err != nil {
-		fmt.Printf("Error running command ld %s\n", err)
-		return
+	if len(warnings) > 0 {
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDriver(t *testing.T) {
+	if _, err := exec.LookPath("as"); err != nil {
+		t.Skip("GNU as is not available")
+	}
+	dir := t.TempDir()
+	good := writeSource(t, dir, "good", "main() {\n    println 42;\n}\n")
+	bad := writeSource(t, dir, "bad", "main() {\n    x = 1;\n}\n")
+
+	for _, test := range []struct {
+		args   []string
+		code   int
+		output string // the file expected to exist afterwards
+	}{
+		{[]string{"-o", filepath.Join(dir, "prog"), good}, 0, "prog"},
+		{[]string{good, "-S", "-o", filepath.Join(dir, "good.s")}, 0, "good.s"},
+		{[]string{"--emit=obj", "-o", filepath.Join(dir, "x.o"), good}, 0, "x.o"},
+		{[]string{"--emit=listing", "-o", filepath.Join(dir, "good.lst"), good}, 0, "good.lst"},
+		{[]string{"--emit=py-novars", "-o", filepath.Join(dir, "prog.py"), good}, 0, "prog.py"},
+		{[]string{"--keep-temps", "-o", filepath.Join(dir, "kept"), good}, 0, "good.asm"},
+		{[]string{"-o", filepath.Join(dir, "bad"), bad}, exitCompile, ""},
+		{[]string{"--emit=wasm", good}, exitUsage, ""},
+		{[]string{filepath.Join(dir, "missing.wend")}, exitUsage, ""},
+		{[]string{good, bad}, exitUsage, ""},
+		{[]string{"-o", good, good}, exitUsage, "good.wend"},
+		{[]string{"--emit=symbols", "-o", "-", good}, 0, ""},
+		{[]string{"--emit=py", "-o", "-", good}, 0, ""},
+		{[]string{"-o", "-", good}, exitUsage, ""},
+		{[]string{"-c", "-o", "-", good}, exitUsage, ""},
+		{[]string{"-o", filepath.Join(dir, "prog"), good, filepath.Join(dir, "missing.o")}, exitTools, ""},
+	} {
+		if code := compile(test.args); code != test.code {
+			t.Errorf("%v: expected exit code %d, got %d", test.args, test.code, code)
+		}
+		if test.output == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, test.output)); err != nil {
+			t.Errorf("%v: %v", test.args, err)
+		}
+	}
+	if source, err := os.ReadFile(good); err != nil || string(source) != "main() {\n    println 42;\n}\n" {
+		t.Errorf("the source file is overwritten: %q (%v)", source, err)
+	}
+	if _, err := os.Stat("-"); err == nil {
+		t.Errorf("-o - writes a file named -")
+	}
+	stdout := os.Stdout // the assembly goes to the standard output
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	code := compile([]string{"-S", "-o", "-", good})
+	os.Stdout = stdout
+	w.Close()
+	asm, _ := io.ReadAll(r)
+	if code != 0 || !strings.Contains(string(asm), "_start:") {
+		t.Errorf("-S -o -: expected the assembly on the standard output, got %d and %q", code, asm)
+	}
+	for _, test := range []struct {
+		path string
+		code int
+	}{
+		{good, 0},
+		{bad, exitCompile},
+		{writeSource(t, dir, "syntax", "main() {\n"), exitCompile},
+		{filepath.Join(dir, "missing.wend"), exitUsage},
+	} {
+		if code := vetFile(test.path); code != test.code {
+			t.Errorf("vet %s: expected exit code %d, got %d", test.path, test.code, code)
+		}
+	}
+	out, err := exec.Command(filepath.Join(dir, "prog")).Output()
+	if err != nil || string(out) != "42\n" {
+		t.Errorf("expected 42, got %q (%v)", out, err)
+	}
+}
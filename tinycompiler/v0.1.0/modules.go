@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// A program is made of one main file and the library files it imports, directly or not. Every file is compiled
+// to its own object: the labels generated by newLabel, the display and the runtime routines stay local to the object,
+// only the exported functions get a global label. The global label is mangled from the module name (the base name
+// of the file) and the signature, so that overloaded functions can be exported as well.
+func mangle(module, name string, argtypes []Type) string {
+	label := "wend_" + module + "_" + name
+	for _, t := range argtypes {
+		label += "_" + t.String()
+	}
+	return identifier(label)
+}
+
+func moduleName(path string) string {
+	return identifier(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+}
+
+// replace the characters that cannot appear in a label
+func identifier(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func parseFile(path string) Function {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		panic(fmt.Sprintf("failed to read %s: %v", path, err))
+	}
+	return (&WendParser{}).Parse(tokenize(string(source))).(Function)
+}
+
+// parse a source file and declare the functions exported by the files it imports, the import paths are relative
+// to the importing file; the result holds the syntax tree and the paths of the imported files
+func loadModule(path string) (Function, []string) {
+	fun := parseFile(path)
+	if fun.deco["library"] == true {
+		fun.deco["module"] = moduleName(path)
+	}
+	imported := []Function{}
+	deps := []string{}
+	for _, name := range fun.deco["imports"].([]string) {
+		dep := filepath.Join(filepath.Dir(path), name)
+		lib := parseFile(dep) // only the signatures are needed, the imports of the library are not followed
+		if lib.deco["library"] != true {
+			panic(fmt.Sprintf("Cannot import %s, it is not a library", name))
+		}
+		for _, f := range lib.fun {
+			if f.deco["export"] != true {
+				continue
+			}
+			argtypes := argTypes(f)
+			imported = append(imported, Function{f.name, f.args, []Var{}, []Function{}, []Statement{}, map[string]any{
+				"type":   f.deco["type"],
+				"lineno": f.deco["lineno"],
+				"label":  mangle(moduleName(dep), f.name, argtypes),
+				"module": moduleName(dep),
+				"import": true,
+			}})
+		}
+		deps = append(deps, dep)
+	}
+	fun.deco["imported"] = imported
+	return fun, deps
+}
+
+// the functions the program never calls are reported on every compile, a "vet:ignore" comment silences them
+func unusedWarnings(path string, fun Function) []Warning {
+	source, _ := os.ReadFile(path) // already parsed
+	return suppress(string(source), fun.deco["callgraph"].(*callGraph).unused())
+}
+
+// compile a source file to assembly, the result holds the paths of the imported files and the warnings as well
+func compileModule(path string, opts buildOptions) (string, []string, []Warning) {
+	fun, deps := loadModule(path)
+	fun.deco["libc"] = opts.libc
+	buildSymtable(fun)
+	warnings := unusedWarnings(path, fun)
+	if opts.coverage {
+		coverInstrument(fun, path)
+	}
+	if opts.profile {
+		instrument(fun)
+	}
+	if !opts.noInline && !opts.profile && !opts.coverage { // the counters are kept for the calls as written
+		inline(&fun)
+	}
+	for _, f := range removeUnused(&fun, newCallGraph(fun)) { // the calls inlined everywhere leave their callee unused
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "%s:%d: removed the unused function %s\n", path, f.deco["lineno"].(int)+1, f.name)
+		}
+	}
+	if opts.emit == "listing" {
+		listInstrument(fun, path)
+	}
+	asm := transasm(fun)
+	if !opts.noPeephole {
+		var removed int
+		asm, removed = peephole(asm)
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "%s: peephole removed %d instructions\n", path, removed)
+		}
+	}
+	return asm, deps, warnings
+}
+
+// translate a source file to a Python module, readable or without variables; the result holds the paths of the
+// imported files and the warnings as well. The calls are not inlined, the Python backends translate the calls as written.
+func compilePython(path string, novars bool) (string, []string, []Warning) {
+	fun, deps := loadModule(path)
+	buildSymtable(fun)
+	warnings := unusedWarnings(path, fun)
+	removeUnused(&fun, fun.deco["callgraph"].(*callGraph))
+	if novars {
+		return transnovars(fun), deps, warnings
+	}
+	return transpy(fun), deps, warnings
+}
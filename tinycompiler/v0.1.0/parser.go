@@ -12,33 +12,173 @@ type Grammer struct {
 }
 
 var Grammars = []Grammer{
+	{
+		"module",
+		[]string{"import_list", "toplevel_list"},
+		func(p []any) any {
+			funs, externs := []Function{}, []Function{}
+			for _, f := range p[1].([]Function) {
+				if f.deco["extern"] == true {
+					externs = append(externs, f)
+				} else {
+					funs = append(funs, f)
+				}
+			}
+			if len(funs) == 1 && funs[0].name == "main" && funs[0].deco["export"] == nil { // a program
+				funs[0].deco["imports"] = p[0].([]string)
+				funs[0].deco["externs"] = externs
+				return funs[0]
+			}
+			return Function{ // a library is a nameless root holding the top-level functions, it has no frame
+				"",
+				[]Var{},
+				[]Var{},
+				funs,
+				[]Statement{},
+				map[string]any{"type": VOID, "lineno": 0, "label": "module_" + newLabel(), "library": true, "imports": p[0].([]string), "externs": externs}}
+		},
+	},
+	{
+		"import_list",
+		[]string{"import_list", "IMPORT", "STRING", "SEMICOLON"},
+		func(p []any) any {
+			return append(p[0].([]string), p[2].(Token).value)
+		},
+	},
+	{
+		"import_list",
+		[]string{},
+		func(p []any) any {
+			return []string{}
+		},
+	},
+	{
+		"toplevel_list",
+		[]string{"toplevel_list", "toplevel"},
+		func(p []any) any {
+			return append(p[0].([]Function), p[1].(Function))
+		},
+	},
+	{
+		"toplevel_list",
+		[]string{"toplevel"},
+		func(p []any) any {
+			return []Function{p[0].(Function)}
+		},
+	},
+	{
+		"toplevel",
+		[]string{"fun"},
+		func(p []any) any {
+			return p[0].(Function)
+		},
+	},
+	{
+		"toplevel",
+		[]string{"EXPORT", "fun"},
+		func(p []any) any {
+			p[1].(Function).deco["export"] = true
+			return p[1].(Function)
+		},
+	},
+	{
+		"toplevel",
+		[]string{"EXTERN", "fun_type", "ID", "LPAREN", "type_list", "RPAREN", "SEMICOLON"},
+		func(p []any) any {
+			args := []Var{} // unnamed parameters, only their types matter
+			for _, t := range p[4].([]Type) {
+				args = append(args, Var{"", map[string]any{"type": t, "lineno": p[2].(Token).lineno}})
+			}
+			return Function{
+				p[2].(Token).value,
+				args,
+				[]Var{},
+				[]Function{},
+				[]Statement{},
+				map[string]any{"type": p[1], "lineno": p[2].(Token).lineno, "label": p[2].(Token).value, "extern": true}}
+		},
+	},
 	{
 		"fun",
 		[]string{"fun_type", "ID", "LPAREN", "param_list", "RPAREN", "BEGIN", "var_list", "fun_list", "statement_list", "END"},
 		func(p []any) any {
+			vars, consts := []Var{}, []Var{} // the constants take no slot, they are kept apart
+			for _, v := range p[6].([]Var) {
+				if v.deco["const"] == true {
+					consts = append(consts, v)
+				} else {
+					vars = append(vars, v)
+				}
+			}
 			return Function{
 				p[1].(Token).value,
 				p[3].([]Var),
-				p[6].([]Var),
+				vars,
 				p[7].([]Function),
 				p[8].([]Statement),
-				map[string]any{"type": p[0], "lineno": p[1].(Token).lineno, "label": p[1].(Token).value + "_" + newLabel()}}
+				map[string]any{"type": p[0], "lineno": p[1].(Token).lineno, "endline": p[9].(Token).lineno, "label": p[1].(Token).value + "_" + newLabel(),
+					"consts": consts}}
 		},
 	},
 	{
 		"var",
-		[]string{"TYPE", "ID"},
+		[]string{"type", "ID"},
 		func(p []any) any {
-			typ := BOOL
-			if p[0].(Token).value == "int" {
-				typ = INT
-			}
 			return Var{
 				p[1].(Token).value,
-				map[string]any{"type": typ, "lineno": p[0].(Token).lineno},
+				map[string]any{"type": p[0].(Type), "lineno": p[1].(Token).lineno},
 			}
 		},
 	},
+	{
+		"type",
+		[]string{"TYPE"},
+		func(p []any) any {
+			return BasicTypes[p[0].(Token).value]
+		},
+	},
+	{
+		"type",
+		[]string{"TYPE", "LBRACKET", "RBRACKET"},
+		func(p []any) any {
+			return arrayOf(BasicTypes[p[0].(Token).value])
+		},
+	},
+	{
+		"type",
+		[]string{"FUN", "LPAREN", "type_list", "RPAREN", "COLON", "TYPE"},
+		func(p []any) any {
+			return newFunType(p[2].([]Type), BasicTypes[p[5].(Token).value])
+		},
+	},
+	{
+		"type",
+		[]string{"FUN", "LPAREN", "type_list", "RPAREN"},
+		func(p []any) any {
+			return newFunType(p[2].([]Type), VOID)
+		},
+	},
+	{
+		"type_list",
+		[]string{"type"},
+		func(p []any) any {
+			return []Type{p[0].(Type)}
+		},
+	},
+	{
+		"type_list",
+		[]string{"type_list", "COMMA", "type"},
+		func(p []any) any {
+			return append(p[0].([]Type), p[2].(Type))
+		},
+	},
+	{
+		"type_list",
+		[]string{},
+		func(p []any) any {
+			return []Type{}
+		},
+	},
 	{
 		"param_list",
 		[]string{"var"},
@@ -72,11 +212,14 @@ var Grammars = []Grammer{
 		"fun_type",
 		[]string{"TYPE"},
 		func(p []any) any {
-			typ := BOOL
-			if p[0].(Token).value == "int" {
-				typ = INT
-			}
-			return typ
+			return BasicTypes[p[0].(Token).value]
+		},
+	},
+	{
+		"fun_type",
+		[]string{"TYPE", "LBRACKET", "RBRACKET"},
+		func(p []any) any {
+			return arrayOf(BasicTypes[p[0].(Token).value])
 		},
 	},
 	{
@@ -93,6 +236,16 @@ var Grammars = []Grammer{
 			return append(p[0].([]Var), p[1].(Var))
 		},
 	},
+	{
+		"var_list",
+		[]string{"var_list", "CONST", "type", "ID", "ASSIGN", "expr", "SEMICOLON"},
+		func(p []any) any {
+			return append(p[0].([]Var), Var{
+				p[3].(Token).value,
+				map[string]any{"type": p[2].(Type), "lineno": p[3].(Token).lineno, "const": true, "value": p[5].(Expression)},
+			})
+		},
+	},
 	{
 		"var_list",
 		[]string{},
@@ -162,6 +315,18 @@ var Grammars = []Grammer{
 			}
 		},
 	},
+	{
+		"statement",
+		[]string{"ID", "LBRACKET", "expr", "RBRACKET", "ASSIGN", "expr", "SEMICOLON"},
+		func(p []any) any {
+			return Store{
+				Var{p[0].(Token).value, map[string]any{"lineno": p[0].(Token).lineno}},
+				p[2].(Expression),
+				p[5].(Expression),
+				map[string]any{"lineno": p[0].(Token).lineno},
+			}
+		},
+	},
 	{
 		"statement",
 		[]string{"RETURN", "expr", "SEMICOLON"},
@@ -195,45 +360,93 @@ var Grammars = []Grammer{
 	},
 	{
 		"statement",
-		[]string{"IF", "expr", "BEGIN", "statement_list", "END", "else_statement"},
+		[]string{"IF", "expr", "BEGIN", "statement_list", "END", "ELSE", "BEGIN", "statement_list", "END"},
 		func(p []any) any {
 			return IfThenElse{
 				p[1].(Expression),
 				p[3].([]Statement),
-				p[5].([]Statement),
-				map[string]any{"lineno": p[0].(Token).lineno},
+				p[7].([]Statement),
+				map[string]any{"lineno": p[0].(Token).lineno, "endline": p[8].(Token).lineno},
 			}
 		},
 	},
 	{
-		"else_statement",
-		[]string{"ELSE", "BEGIN", "statement_list", "END"},
+		"statement",
+		[]string{"IF", "expr", "BEGIN", "statement_list", "END"},
 		func(p []any) any {
-			return p[2].([]Statement)
+			return IfThenElse{
+				p[1].(Expression),
+				p[3].([]Statement),
+				[]Statement{},
+				map[string]any{"lineno": p[0].(Token).lineno, "endline": p[4].(Token).lineno},
+			}
 		},
 	},
 	{
-		"else_statement",
-		[]string{},
+		"statement",
+		[]string{"WHILE", "expr", "BEGIN", "statement_list", "END"},
 		func(p []any) any {
-			r := make([]Statement, len(p))
-			for i := range p {
-				r[i] = p[i].(Statement)
+			return While{
+				p[1].(Expression),
+				p[3].([]Statement),
+				map[string]any{"lineno": p[0].(Token).lineno, "endline": p[4].(Token).lineno},
 			}
-			return r
 		},
 	},
 	{
 		"statement",
-		[]string{"WHILE", "expr", "BEGIN", "statement_list", "END"},
+		[]string{"SWITCH", "expr", "BEGIN", "case_list", "END"},
 		func(p []any) any {
-			return While{
+			return Switch{
 				p[1].(Expression),
-				p[3].([]Statement),
-				map[string]any{"lineno": p[0].(Token).lineno},
+				p[3].([]Case),
+				map[string]any{"lineno": p[0].(Token).lineno, "endline": p[4].(Token).lineno},
 			}
 		},
 	},
+	{
+		"case_list",
+		[]string{"case_list", "CASE", "case_values", "COLON", "statement_list"},
+		func(p []any) any {
+			return append(p[0].([]Case), Case{
+				p[2].([]Expression),
+				p[4].([]Statement),
+				map[string]any{"lineno": p[1].(Token).lineno},
+			})
+		},
+	},
+	{
+		"case_list",
+		[]string{"case_list", "DEFAULT", "COLON", "statement_list"},
+		func(p []any) any {
+			return append(p[0].([]Case), Case{
+				nil,
+				p[3].([]Statement),
+				map[string]any{"lineno": p[1].(Token).lineno},
+			})
+		},
+	},
+	{
+		"case_list",
+		[]string{},
+		func(p []any) any {
+			return []Case{}
+		},
+	},
+	{
+		"case_values",
+		[]string{"expr"},
+		func(p []any) any {
+			return []Expression{p[0].(Expression)}
+		},
+	},
+	{
+		"case_values",
+		[]string{"case_values", "COMMA", "expr"},
+		func(p []any) any {
+			return append(p[0].([]Expression), p[2].(Expression))
+		},
+	},
 	{
 		"arg_list",
 		[]string{"expr"},
@@ -335,14 +548,14 @@ var Grammars = []Grammer{
 	},
 	{
 		"comparand",
-		[]string{"addend"},
+		[]string{"bitor"},
 		func(p []any) any {
 			return p[0].(Expression)
 		},
 	},
 	{
 		"comparand",
-		[]string{"addend", "COMP", "addend"},
+		[]string{"bitor", "COMP", "bitor"},
 		func(p []any) any {
 			return LogicOp{
 				p[1].(Token).value,
@@ -352,6 +565,82 @@ var Grammars = []Grammer{
 			}
 		},
 	},
+	{
+		"bitor",
+		[]string{"bitxor"},
+		func(p []any) any {
+			return p[0].(Expression)
+		},
+	},
+	{
+		"bitor",
+		[]string{"bitor", "BITOR", "bitxor"},
+		func(p []any) any {
+			return ArithOp{
+				p[1].(Token).value,
+				p[0].(Expression),
+				p[2].(Expression),
+				map[string]any{"lineno": p[1].(Token).lineno, "type": INT},
+			}
+		},
+	},
+	{
+		"bitxor",
+		[]string{"bitand"},
+		func(p []any) any {
+			return p[0].(Expression)
+		},
+	},
+	{
+		"bitxor",
+		[]string{"bitxor", "BITXOR", "bitand"},
+		func(p []any) any {
+			return ArithOp{
+				p[1].(Token).value,
+				p[0].(Expression),
+				p[2].(Expression),
+				map[string]any{"lineno": p[1].(Token).lineno, "type": INT},
+			}
+		},
+	},
+	{
+		"bitand",
+		[]string{"shift"},
+		func(p []any) any {
+			return p[0].(Expression)
+		},
+	},
+	{
+		"bitand",
+		[]string{"bitand", "BITAND", "shift"},
+		func(p []any) any {
+			return ArithOp{
+				p[1].(Token).value,
+				p[0].(Expression),
+				p[2].(Expression),
+				map[string]any{"lineno": p[1].(Token).lineno, "type": INT},
+			}
+		},
+	},
+	{
+		"shift",
+		[]string{"addend"},
+		func(p []any) any {
+			return p[0].(Expression)
+		},
+	},
+	{
+		"shift",
+		[]string{"shift", "SHIFT", "addend"},
+		func(p []any) any {
+			return ArithOp{
+				p[1].(Token).value,
+				p[0].(Expression),
+				p[2].(Expression),
+				map[string]any{"lineno": p[1].(Token).lineno, "type": INT},
+			}
+		},
+	},
 	{
 		"addend",
 		[]string{"term"},
@@ -451,6 +740,21 @@ var Grammars = []Grammer{
 					map[string]any{"type": INT},
 				},
 				p[1].(Expression),
+				map[string]any{"lineno": p[0].(Token).lineno, "type": INT, "unary": true},
+			}
+		},
+	},
+	{
+		"factor",
+		[]string{"BITNOT", "atom"},
+		func(p []any) any {
+			return ArithOp{
+				"^",
+				p[1].(Expression),
+				Integer{
+					-1,
+					map[string]any{"type": INT},
+				},
 				map[string]any{"lineno": p[0].(Token).lineno, "type": INT},
 			}
 		},
@@ -476,6 +780,59 @@ var Grammars = []Grammer{
 			}
 		},
 	},
+	{
+		"atom",
+		[]string{"REAL"},
+		func(p []any) any {
+			x, _ := strconv.ParseFloat(p[0].(Token).value, 64)
+			return Float{
+				x,
+				map[string]any{"lineno": p[0].(Token).lineno, "type": FLOAT},
+			}
+		},
+	},
+	{
+		"atom",
+		[]string{"LONGINT"},
+		func(p []any) any {
+			n, _ := strconv.ParseInt(p[0].(Token).value, 10, 64)
+			return Integer{
+				int(n),
+				map[string]any{"lineno": p[0].(Token).lineno, "type": LONG},
+			}
+		},
+	},
+	{
+		"atom",
+		[]string{"TYPE", "LPAREN", "expr", "RPAREN"},
+		func(p []any) any {
+			return Convert{
+				p[2].(Expression),
+				map[string]any{"lineno": p[0].(Token).lineno, "type": BasicTypes[p[0].(Token).value]},
+			}
+		},
+	},
+	{
+		"atom",
+		[]string{"NEW", "TYPE", "LBRACKET", "expr", "RBRACKET"},
+		func(p []any) any {
+			return New{
+				p[3].(Expression),
+				map[string]any{"lineno": p[0].(Token).lineno, "type": arrayOf(BasicTypes[p[1].(Token).value])},
+			}
+		},
+	},
+	{
+		"atom",
+		[]string{"atom", "LBRACKET", "expr", "RBRACKET"},
+		func(p []any) any {
+			return Index{
+				p[0].(Expression),
+				p[2].(Expression),
+				map[string]any{"lineno": p[1].(Token).lineno},
+			}
+		},
+	},
 	{
 		"atom",
 		[]string{"ID", "LPAREN", "arg_list", "RPAREN"},
@@ -630,5 +987,5 @@ func (p *WendParser) buildSyntree(rule ParseState) any {
 }
 
 func (p *WendParser) Parse(tokens []Token) any {
-	return p.buildSyntree(p.recognize(tokens))
+	return p.buildSyntree(p.recognize(append(tokens, Token{}))) // the empty token lets the last chart be completed
 }
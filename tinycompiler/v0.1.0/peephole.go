@@ -0,0 +1,358 @@
+package main
+
+import (
+	"strings"
+)
+
+// The templates are expanded without looking at their neighbours, so the generated code is full of pairs like
+// pushl %eax/popl %ebx. The peephole pass parses the assembly into lines, each holding at most one instruction,
+// and rewrites short windows of consecutive instructions. A window never crosses a label or a directive: control
+// may enter at a label, and the pass knows nothing about the directives. Untouched lines are printed verbatim.
+type instr struct {
+	text    string   // the source line, printed as is unless the instruction is rewritten
+	label   string   // the label defined on the line, if any
+	op      string   // mnemonic or directive, empty for blank lines and comments
+	args    []string // operands in the AT&T order, the destination is the last one
+	comment string
+	dirty   bool
+}
+
+func (ins *instr) String() string {
+	if !ins.dirty {
+		return ins.text
+	}
+	line := ""
+	if ins.label != "" {
+		line = ins.label + ":"
+	}
+	if ins.op != "" {
+		line += "\t" + ins.op
+		if len(ins.args) > 0 {
+			line += " " + strings.Join(ins.args, ", ")
+		}
+	}
+	if ins.comment != "" {
+		line += " " + ins.comment
+	}
+	return line
+}
+
+func (ins *instr) is(op string, args ...string) bool {
+	if ins == nil || ins.op != op || len(ins.args) != len(args) {
+		return false
+	}
+	for i, arg := range args {
+		if arg != "" && ins.args[i] != arg {
+			return false
+		}
+	}
+	return true
+}
+
+func (ins *instr) set(op string, args ...string) {
+	ins.op, ins.args, ins.dirty = op, args, true
+}
+
+func (ins *instr) remove() {
+	ins.op, ins.args, ins.comment, ins.dirty = "", nil, "", true
+}
+
+func parseInstr(line string) *instr {
+	ins := &instr{text: line}
+	code := line
+	quoted := false
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == '#' && !quoted {
+			code, ins.comment = line[:i], line[i:]
+			break
+		}
+	}
+	if colon := strings.Index(code, ":"); colon > 0 && !strings.ContainsAny(code[:colon], " \t\"") {
+		ins.label, code = code[:colon], code[colon+1:]
+	}
+	fields := strings.Fields(code)
+	if len(fields) == 0 {
+		return ins
+	}
+	ins.op = fields[0]
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(code), ins.op))
+	depth, start := 0, 0
+	for i, c := range rest { // split the operands at the commas outside of the parentheses
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			ins.args = append(ins.args, strings.TrimSpace(rest[start:i]))
+			start = i + 1
+		}
+	}
+	if rest != "" {
+		ins.args = append(ins.args, strings.TrimSpace(rest[start:]))
+	}
+	return ins
+}
+
+func isReg(arg string) bool {
+	return strings.HasPrefix(arg, "%")
+}
+
+func isMem(arg string) bool {
+	return !isReg(arg) && !strings.HasPrefix(arg, "$")
+}
+
+func mentions(arg, reg string) bool {
+	return strings.Contains(arg, reg)
+}
+
+// the full register a partial one belongs to
+func fullReg(arg string) string {
+	switch arg {
+	case "%al", "%ah", "%ax":
+		return "%eax"
+	case "%bl", "%bh", "%bx":
+		return "%ebx"
+	case "%cl", "%ch", "%cx":
+		return "%ecx"
+	case "%dl", "%dh", "%dx":
+		return "%edx"
+	}
+	return arg
+}
+
+// the operands written by an instruction, ok is false for the instructions the pass does not model
+func written(ins *instr) (out []string, ok bool) {
+	switch ins.op {
+	case "pushl", "cmp", "cmpl", "test", "testl":
+		return nil, true
+	case "cdq", "cltd", "rdtsc", "mull", "divl", "idivl":
+		return []string{"%eax", "%edx"}, true
+	case "imull":
+		if len(ins.args) == 1 {
+			return []string{"%eax", "%edx"}, true
+		}
+	case "movl", "movb", "movzbl", "movsbl", "leal", "addl", "subl", "adcl", "sbbl", "andl", "orl", "xorl",
+		"shll", "shrl", "sarl", "shldl", "shrdl", "negl", "notl", "incl", "decl", "popl":
+	default:
+		if strings.HasPrefix(ins.op, "set") {
+			break
+		}
+		if strings.HasPrefix(ins.op, "f") && !strings.HasPrefix(ins.op, "fst") && !strings.HasPrefix(ins.op, "fist") &&
+			!strings.HasPrefix(ins.op, "fnst") {
+			return nil, true // the x87 instructions that only read memory
+		}
+		if !strings.HasPrefix(ins.op, "f") {
+			return nil, false
+		}
+	}
+	if len(ins.args) == 0 {
+		return nil, false
+	}
+	return []string{fullReg(ins.args[len(ins.args)-1])}, true
+}
+
+// the rewrite rules: w holds the instructions following the current one, w[0] is the current instruction
+type window []*instr
+
+// the k-th instruction of the window, nil if the straight-line code ends before it
+func (w window) at(k int) *instr {
+	if k >= len(w) || (k > 0 && w[k].label != "") || strings.HasPrefix(w[k].op, ".") || w[k].op == "" {
+		return nil
+	}
+	return w[k]
+}
+
+var peepholeRules = []struct {
+	name    string
+	rewrite func(w window) bool
+}{
+	// the value is computed in %eax just to be moved to %ebx: compute it in %ebx directly
+	//	pushl %eax; movl S1, %eax; ...; movl Sn, %eax; movl %eax, %ebx; popl %eax
+	{"compute in place", func(w window) bool {
+		if !w.at(0).is("pushl", "%eax") {
+			return false
+		}
+		k := 1
+		for ins := w.at(k); ins != nil && ins.is("movl", "", "%eax"); ins = w.at(k) {
+			src := ins.args[0] // the first move must not read the saved %eax, the others read %eax only
+			if (k == 1 && mentions(src, "%eax")) || mentions(src, "%esp") || mentions(src, "%ebx") ||
+				strings.Count(src, "%") > 1 || (isReg(src) && src != "%eax") {
+				return false
+			}
+			k++
+		}
+		if k == 1 || !w.at(k).is("movl", "%eax", "%ebx") || !w.at(k+1).is("popl", "%eax") {
+			return false
+		}
+		w[0].remove()
+		for i := 1; i < k; i++ {
+			w[i].set("movl", strings.ReplaceAll(w[i].args[0], "%eax", "%ebx"), "%ebx")
+		}
+		w[k].remove()
+		w[k+1].remove()
+		return true
+	}},
+	// a value pushed and popped right away
+	//	pushl X; popl R -> movl X, R
+	{"push pop", func(w window) bool {
+		push, pop := w.at(0), w.at(1)
+		if !push.is("pushl", "") || !pop.is("popl", "") || !isReg(pop.args[0]) || mentions(push.args[0], "%esp") {
+			return false
+		}
+		if push.args[0] == pop.args[0] {
+			push.remove()
+		} else {
+			push.set("movl", push.args[0], pop.args[0])
+		}
+		pop.remove()
+		return true
+	}},
+	// a value saved on the stack around a move that does not touch the destination
+	//	pushl X; movl S, D; popl R -> movl X, R; movl S, D
+	{"push move pop", func(w window) bool {
+		push, mov, pop := w.at(0), w.at(1), w.at(2)
+		if !push.is("pushl", "") || !mov.is("movl", "", "") || !pop.is("popl", "") || !isReg(pop.args[0]) {
+			return false
+		}
+		r := pop.args[0]
+		for _, arg := range append([]string{push.args[0]}, mov.args...) {
+			if mentions(arg, "%esp") || mentions(arg, r) {
+				return false
+			}
+		}
+		push.set("movl", push.args[0], r)
+		pop.remove()
+		return true
+	}},
+	// a move to itself
+	//	movl R, R
+	{"self move", func(w window) bool {
+		if !w.at(0).is("movl", "", "") || !isReg(w[0].args[0]) || w[0].args[0] != w[0].args[1] {
+			return false
+		}
+		w[0].remove()
+		return true
+	}},
+	// a move back: the second move copies the value the first one has just copied
+	//	movl A, B; movl B, A
+	{"move back", func(w window) bool {
+		first, second := w.at(0), w.at(1)
+		if !first.is("movl", "", "") || !second.is("movl", "", "") {
+			return false
+		}
+		a, b := first.args[0], first.args[1]
+		if second.args[0] != b || second.args[1] != a {
+			return false
+		}
+		if (isMem(a) && (isMem(b) || mentions(a, b))) || (isMem(b) && mentions(b, a)) {
+			return false
+		}
+		second.remove()
+		return true
+	}},
+	// a value stored to memory and loaded back
+	//	movl R, M; movl M, R2 -> movl R, M; movl R, R2
+	{"store load", func(w window) bool {
+		store, load := w.at(0), w.at(1)
+		if !store.is("movl", "", "") || !load.is("movl", "", "") {
+			return false
+		}
+		r, m := store.args[0], store.args[1]
+		if !isReg(r) || !isMem(m) || load.args[0] != m || !isReg(load.args[1]) || load.args[1] == r {
+			return false
+		}
+		load.set("movl", r, load.args[1])
+		return true
+	}},
+	// a display entry loaded again while the register still holds it
+	//	movl display+N, R; ...; movl display+N, R
+	{"display reload", func(w window) bool {
+		load := w.at(0)
+		if !load.is("movl", "", "") || !strings.HasPrefix(load.args[0], "display") || !isReg(load.args[1]) {
+			return false
+		}
+		for k := 1; w.at(k) != nil; k++ {
+			if w[k].is("movl", load.args[0], load.args[1]) {
+				w[k].remove()
+				return true
+			}
+			out, ok := written(w[k])
+			if !ok {
+				return false
+			}
+			for _, arg := range out {
+				if arg == load.args[1] || mentions(arg, "display") {
+					return false
+				}
+			}
+		}
+		return false
+	}},
+	// a jump to the next line
+	//	jmp L; L:
+	{"jump to next", func(w window) bool {
+		jump := w.at(0)
+		if jump == nil || !strings.HasPrefix(jump.op, "j") || len(jump.args) != 1 || len(w) < 2 {
+			return false
+		}
+		target := strings.TrimSuffix(jump.args[0], "f") // a forward reference to a numeric label
+		if w[1].label != target || (target != jump.args[0] && strings.Trim(target, "0123456789") != "") {
+			return false
+		}
+		jump.remove()
+		return true
+	}},
+}
+
+// apply the rewrite rules until none applies, the result holds the number of removed instructions
+func peephole(asm string) (string, int) {
+	lines := []*instr{}
+	code := []*instr{} // the lines holding an instruction, a label or a directive
+	for _, line := range strings.Split(asm, "\n") {
+		ins := parseInstr(line)
+		lines = append(lines, ins)
+		if ins.op != "" || ins.label != "" {
+			code = append(code, ins)
+		}
+	}
+	count := func() int {
+		n := 0
+		for _, ins := range code {
+			if ins.op != "" && !strings.HasPrefix(ins.op, ".") {
+				n++
+			}
+		}
+		return n
+	}
+	before := count()
+	for changed := true; changed; {
+		changed = false
+		for i := range code {
+			var w window
+			for _, ins := range code[i:] {
+				if ins.op != "" || ins.label != "" {
+					w = append(w, ins)
+				}
+				if len(w) > 1 && ins.label != "" {
+					break
+				}
+			}
+			for _, rule := range peepholeRules {
+				if rule.rewrite(w) {
+					changed = true
+				}
+			}
+		}
+	}
+	out := []string{}
+	for _, ins := range lines {
+		if ins.dirty && ins.op == "" && ins.label == "" { // the line of a removed instruction
+			continue
+		}
+		out = append(out, ins.String())
+	}
+	return strings.Join(out, "\n"), before - count()
+}
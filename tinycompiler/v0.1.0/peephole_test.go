@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		asm, expected string
+		removed       int
+	}{
+		{"\tpushl %eax\n\tpopl %eax\n\tret", "\tret", 2},
+		{"\tpushl %eax\n\tpopl %ebx", "\tmovl %eax, %ebx", 1},
+		{"\tpushl %eax\n\tmovl $5, %eax\n\tmovl %eax, %ebx\n\tpopl %eax", "\tmovl $5, %ebx", 3},
+		{"\tpushl %eax\n\tmovl display+4, %eax\n\tmovl -8(%eax), %eax\n\tmovl %eax, %ebx\n\tpopl %eax",
+			"\tmovl display+4, %ebx\n\tmovl -8(%ebx), %ebx", 3},
+		{"\tpushl %eax\n\tmovl -8(%eax), %eax\n\tmovl %eax, %ebx\n\tpopl %eax", // reads the saved value
+			"\tpushl %eax\n\tmovl -8(%eax), %eax\n\tmovl %eax, %ebx\n\tpopl %eax", 0},
+		{"\tpushl %eax\n\tmovl display+0, %eax\n\tpopl %ebx\n\tmovl %ebx, -8(%eax)",
+			"\tmovl %eax, %ebx\n\tmovl display+0, %eax\n\tmovl %ebx, -8(%eax)", 1},
+		{"\tmovl %eax, %ebx\n\tmovl %ebx, %eax", "\tmovl %eax, %ebx", 1},
+		{"\tmovl (%eax), %eax\n\tmovl %eax, (%eax)", "\tmovl (%eax), %eax\n\tmovl %eax, (%eax)", 0},
+		{"\tmovl display+0, %eax\n\tmovl %ebx, -4(%eax)\n\tmovl display+0, %eax\n\tmovl -4(%eax), %eax",
+			"\tmovl display+0, %eax\n\tmovl %ebx, -4(%eax)\n\tmovl %ebx, %eax", 1},
+		{"\tmovl display+0, %eax\n\tcall f\n\tmovl display+0, %eax", "\tmovl display+0, %eax\n\tcall f\n\tmovl display+0, %eax", 0},
+		{"\tmovl display+0, %eax\nL:\n\tmovl display+0, %eax", "\tmovl display+0, %eax\nL:\n\tmovl display+0, %eax", 0},
+		{"\tjmp L\n\n# comment\nL:\tret", "\n# comment\nL:\tret", 1},
+		{"\tjz 1f\n1:\n\tjmp 1b\n1:", "1:\n\tjmp 1b\n1:", 1},
+		{"\tjmp L1f\nL1:", "\tjmp L1f\nL1:", 0},
+	}
+	for _, test := range tests {
+		asm, removed := peephole(test.asm)
+		if asm != test.expected || removed != test.removed {
+			t.Errorf("%q: expected %q (%d removed), got %q (%d removed)",
+				strings.Split(test.asm, "\n"), test.expected, test.removed, asm, removed)
+		}
+	}
+}
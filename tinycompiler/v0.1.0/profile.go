@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+)
+
+// Profiling: every function is entered through a wrapper that reserves a profiling frame on the stack, around the call
+// of the actual body. The body addresses its frame through the display only, so the extra words do not disturb it.
+// The profiling frames are linked together to charge the time of a call to its caller, the time is read with rdtsc.
+// Each function has a record in the "profile" section, the linker gathers the records of all the objects between
+// __start_profile and __stop_profile, and the runtime prints them at the exit.
+//
+// A tail call would jump into the wrapper of the callee without leaving the wrapper of the caller, so the tail calls
+// become ordinary calls, and a deep recursion that relied on them may need a larger stack. The program is not inlined
+// either: the time of an inlined call would be charged to the caller.
+func instrument(root Function) {
+	root.deco["profile"] = true
+	var walk func(f Function, prefix string)
+	walk = func(f Function, prefix string) {
+		name := prefix + f.name
+		if f.deco["library"] == true { // no code of its own
+			name = f.deco["module"].(string)
+		} else {
+			argtypes := []string{}
+			for _, t := range argTypes(f) {
+				argtypes = append(argtypes, t.String())
+			}
+			f.deco["profname"] = name + "(" + strings.Join(argtypes, ",") + ")"
+		}
+		visitExprs(f.body, func(e Expression) {
+			if call, ok := e.(FunCall); ok {
+				delete(call.deco, "tail")
+			}
+		})
+		for _, nested := range f.fun {
+			walk(nested, name+".")
+		}
+	}
+	walk(root, "")
+}
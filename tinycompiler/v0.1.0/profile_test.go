@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	if _, err := exec.LookPath("as"); err != nil {
+		t.Skip("GNU as is not available")
+	}
+	source := `main() {
+    int i;
+
+    int fib(int n) {
+        if n < 2 {
+            return n;
+        }
+        return fib(n - 1) + fib(n - 2);
+    }
+
+    long twice(long x) {
+        return x + x;
+    }
+
+    unused() {
+    }
+
+    i = 0;
+    while i < 3 {
+        println twice(long(fib(10 + i)));
+        i = i + 1;
+    }
+}
+`
+	dir := t.TempDir()
+	exename, warnings, err := build(writeSource(t, dir, "profile", source), dir, buildOptions{profile: true})
+	if err != nil {
+		t.Fatalf("build failed: %s", err)
+	}
+	if len(warnings) != 1 || !strings.HasSuffix(warnings[0], "profile.wend:15: warning: function unused is never called") {
+		t.Errorf("expected the warning for unused, got %q", warnings)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(exename)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("fail to exec program: %s", err)
+	}
+	if stdout.String() != "110\n178\n288\n" {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+	// fib(n) makes 2*fib(n+1)-1 calls
+	report := regexp.MustCompile(`^function\tcalls\ttotal cycles\tself cycles
+main\.fib\(INT\)\t929\t\d+\t\d+
+main(\.twice\(LONG\)\t3|\(\)\t1)\t\d+\t\d+
+main(\.twice\(LONG\)\t3|\(\)\t1)\t\d+\t\d+
+$`)
+	if !report.Match(stderr.Bytes()) {
+		t.Errorf("unexpected report %q", stderr.String())
+	}
+}
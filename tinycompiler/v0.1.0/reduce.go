@@ -0,0 +1,199 @@
+package main
+
+// The reducer shrinks a program built by the generator while it still fails. The edits of the tree are numbered in
+// the order of a walk: drop a nested function, a variable with its initialization or a statement, replace a conditional
+// by one of its branches or a loop by its body, replace an expression by one of its operands or by a zero of its type.
+// The edits are tried one at a time, a failing result replaces the program, and the walk is repeated until no edit is
+// kept. The initializations of the variables and the steps of the loop counters are never touched: the programs stay
+// free of undefined behaviour, and the loops stay finite. An edit that breaks the typing is rejected by the predicate.
+type reducer struct {
+	target int // the edit to apply
+	count  int // the edits seen so far
+}
+
+func (r *reducer) edit() bool {
+	r.count++
+	return r.count-1 == r.target
+}
+
+func reduce(f Function, failing func(Function) bool) Function {
+	for changed := true; changed; {
+		changed = false
+		for target := 0; ; {
+			r := &reducer{target: target}
+			candidate := r.fun(f)
+			if r.count <= target { // all the edits have been tried
+				break
+			}
+			if failing(candidate) {
+				f, changed = candidate, true
+			} else {
+				target++
+			}
+		}
+	}
+	return f
+}
+
+func (r *reducer) fun(f Function) Function {
+	g := Function{f.name, f.args, []Var{}, []Function{}, nil, f.deco}
+	for _, nested := range f.fun {
+		if !r.edit() {
+			g.fun = append(g.fun, r.fun(nested))
+		}
+	}
+	dropped := map[string]bool{}
+	for _, v := range f.vars {
+		if r.edit() {
+			dropped[v.name] = true
+		} else {
+			g.vars = append(g.vars, v)
+		}
+	}
+	g.body = r.stats(f.body, dropped)
+	return g
+}
+
+func (r *reducer) stats(ss []Statement, dropped map[string]bool) []Statement {
+	result := []Statement{}
+	for _, s := range ss {
+		if a, ok := s.(Assign); ok && (a.deco["init"] == true || a.deco["step"] == true) {
+			if !dropped[a.name] {
+				result = append(result, s)
+			}
+			continue
+		}
+		if !r.edit() {
+			result = append(result, r.stat(s)...)
+		}
+	}
+	return result
+}
+
+func (r *reducer) stat(n Statement) []Statement {
+	switch e := n.(type) {
+	case Print:
+		e.expr = r.expr(e.expr)
+		return []Statement{e}
+	case Return:
+		if e.expr != nil {
+			e.expr = r.expr(e.expr)
+		}
+		return []Statement{e}
+	case Assign:
+		e.expr = r.expr(e.expr)
+		return []Statement{e}
+	case FunCall:
+		return []Statement{r.expr(e).(FunCall)}
+	case While: // the condition is kept, the loop must stay finite
+		if r.edit() {
+			return e.body
+		}
+		e.body = r.stats(e.body, nil)
+		return []Statement{e}
+	case IfThenElse:
+		if r.edit() {
+			return e.ibody
+		}
+		if r.edit() {
+			return e.ebody
+		}
+		e.expr = r.expr(e.expr)
+		e.ibody = r.stats(e.ibody, nil)
+		e.ebody = r.stats(e.ebody, nil)
+		return []Statement{e}
+	case Switch:
+		for _, c := range e.cases {
+			if r.edit() {
+				return c.body
+			}
+		}
+		e.expr = r.expr(e.expr)
+		cases := []Case{}
+		for _, c := range e.cases {
+			cases = append(cases, Case{c.values, r.stats(c.body, nil), c.deco})
+		}
+		e.cases = cases
+		return []Statement{e}
+	}
+	return []Statement{n}
+}
+
+func zeroLiteral(t Type) Expression {
+	switch t {
+	case FLOAT:
+		return Float{0, map[string]any{"type": t}}
+	case BOOL:
+		return Boolean{false, map[string]any{"type": t}}
+	}
+	return Integer{0, map[string]any{"type": t}}
+}
+
+func (r *reducer) expr(n Expression) Expression {
+	t, _ := n.getDeco()["type"].(Type)
+	switch e := n.(type) {
+	case Integer:
+		if e.value != 0 && r.edit() {
+			return zeroLiteral(t)
+		}
+		return e
+	case Float:
+		if e.value != 0 && r.edit() {
+			return zeroLiteral(t)
+		}
+		return e
+	case Boolean:
+		if e.value && r.edit() {
+			return zeroLiteral(t)
+		}
+		return e
+	case FunCall:
+		if t != VOID && r.edit() {
+			return zeroLiteral(t)
+		}
+		args := []Expression{}
+		for _, arg := range e.args {
+			args = append(args, r.expr(arg))
+		}
+		e.args = args
+		return e
+	}
+	if r.edit() {
+		return zeroLiteral(t)
+	}
+	switch e := n.(type) {
+	case ArithOp:
+		if e.deco["unary"] != true && r.edit() {
+			return e.left
+		}
+		if e.deco["bitnot"] != true && r.edit() {
+			return e.right
+		}
+		if e.deco["unary"] != true { // the operand made up by the parser is not printed
+			e.left = r.expr(e.left)
+		}
+		if e.deco["bitnot"] != true {
+			e.right = r.expr(e.right)
+		}
+		return e
+	case LogicOp:
+		if e.deco["unary"] != true && r.edit() {
+			return e.left
+		}
+		if r.edit() {
+			return e.right
+		}
+		if e.deco["unary"] != true {
+			e.left = r.expr(e.left)
+		}
+		e.right = r.expr(e.right)
+		return e
+	case Convert:
+		if r.edit() {
+			return e.expr
+		}
+		e.expr = r.expr(e.expr)
+		return e
+	}
+	return n
+}
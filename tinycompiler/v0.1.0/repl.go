@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// The REPL session is a main function that grows with every snippet: the declared variables and functions are added
+// to it, the statements are analyzed against the symbol table that persists between the snippets, then executed by
+// the interpreter in the frame of main. A snippet is parsed as the body of a function, if it is not a body it is
+// evaluated as an expression and its value is printed.
+type session struct {
+	root     Function
+	symtable *SymbolTable
+	m        *machine
+	frame    *frame
+}
+
+func newSession(out io.Writer) *session {
+	root := Function{"main", []Var{}, []Var{}, []Function{}, []Statement{}, map[string]any{
+		"type": VOID, "lineno": 0, "label": "main", "argtypes": []Type{}, "ancestors": []int{},
+		"strings": map[string]string{}, "imported": []Function{}, "externs": []Function{},
+	}}
+	s := &session{root: root, symtable: newSymbolTable(), m: newMachine(out)}
+	s.symtable.addFun(root.name, []Type{}, root.deco)
+	addBuiltins(s.symtable)
+	s.symtable.pushScope(&root.deco)
+	s.frame = newFrame(root, &module{})
+	s.m.enter(s.frame)
+	return s
+}
+
+// the snippet as the body of main, the lines are numbered from 1
+func parseSnippet(source string) Function {
+	return (&WendParser{}).Parse(tokenize("main() {\n" + source + "\n}")).(Function)
+}
+
+// the errors of the front end and of the running program are panics, they are turned into errors; restore is called
+// before the error is returned
+func catch(err *error, restore func()) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if _, ok := r.(runtime.Error); ok {
+		panic(r)
+	}
+	if restore != nil {
+		restore()
+	}
+	*err = fmt.Errorf("%v", r)
+}
+
+// run the analysis, a failed one leaves the symbol table as it was
+func (s *session) analyze(analysis func()) (err error) {
+	top := len(s.symtable.variables) - 1
+	variables, functions := map[string]map[string]any{}, map[string]map[string]any{}
+	for k, v := range s.symtable.variables[top] {
+		variables[k] = v
+	}
+	for k, v := range s.symtable.functions[top] {
+		functions[k] = v
+	}
+	varCnt := s.root.deco["varCnt"]
+	defer catch(&err, func() {
+		s.symtable.variables[top], s.symtable.functions[top] = variables, functions
+		s.root.deco["varCnt"] = varCnt
+	})
+	analysis()
+	return nil
+}
+
+// declare the variables, the constants and the functions of the snippet, analyze its statements like processScope does for a body
+func (s *session) analyzeSnippet(snippet Function) error {
+	return s.analyze(func() {
+		for _, v := range snippet.vars {
+			s.symtable.addVar(v.name, &v.deco)
+		}
+		declareConsts(snippet.deco, s.symtable)
+		for _, f := range snippet.fun {
+			argtypes := argTypes(f)
+			s.symtable.addFun(f.name, argtypes, f.deco)
+			f.deco["argtypes"] = argtypes
+			f.deco["ancestors"] = []int{s.root.deco["level"].(int)}
+			f.deco["parent"] = s.root.deco["scope"]
+		}
+		for range 2 { // like buildSymtable
+			for i := range snippet.fun {
+				processScope(&snippet.fun[i], s.symtable)
+			}
+		}
+		for i := range snippet.body {
+			snippet.body[i] = processStat(snippet.body[i], s.symtable)
+		}
+	})
+}
+
+// execute the statements in the frame of main, after an error the frames of the unfinished calls are dropped
+func (s *session) execute(ss []Statement) (err error) {
+	for len(s.frame.slots) < s.root.deco["varCnt"].(int) {
+		s.frame.slots = append(s.frame.slots, nil)
+	}
+	for _, v := range s.root.vars {
+		if s.frame.slots[v.deco["offset"].(int)] == nil {
+			s.frame.slots[v.deco["offset"].(int)] = zeroValue(v.deco["type"].(Type))
+		}
+	}
+	defer catch(&err, func() {
+		s.m.cur, s.m.depth = s.frame, 0
+		s.frame.mod.display = s.frame.mod.display[:1]
+	})
+	s.m.body(ss)
+	return nil
+}
+
+func (s *session) eval(input string) error {
+	snippet, err := func() (f Function, err error) {
+		defer catch(&err, nil)
+		return parseSnippet(input), nil
+	}()
+	if err != nil { // not a body, maybe an expression
+		var expr Expression
+		if exprErr := func() (err error) {
+			defer catch(&err, nil)
+			expr = parseSnippet("println " + strings.TrimSuffix(input, ";") + ";").body[0].(Print).expr
+			return nil
+		}(); exprErr != nil {
+			return err
+		}
+		if err := s.analyze(func() { expr = processExpr(expr, s.symtable) }); err != nil {
+			return err
+		}
+		stat := Statement(Print{expr, true, map[string]any{"lineno": expr.getDeco()["lineno"]}})
+		if call, ok := expr.(FunCall); ok && call.deco["type"] == VOID {
+			stat = call
+		}
+		s.root.body = append(s.root.body, stat)
+		return s.execute([]Statement{stat})
+	}
+	if err := s.analyzeSnippet(snippet); err != nil {
+		return err
+	}
+	s.root.vars = append(s.root.vars, snippet.vars...)
+	s.root.fun = append(s.root.fun, snippet.fun...)
+	s.root.body = append(s.root.body, snippet.body...)
+	for _, f := range snippet.fun {
+		s.m.load(f, s.frame.mod)
+	}
+	return s.execute(snippet.body)
+}
+
+// the type of an expression, the expression is not evaluated
+func (s *session) typeOf(input string) (t Type, err error) {
+	var expr Expression
+	if err := func() (err error) {
+		defer catch(&err, nil)
+		expr = parseSnippet("println " + input + ";").body[0].(Print).expr
+		return nil
+	}(); err != nil {
+		return VOID, err
+	}
+	err = s.analyze(func() { t = processExpr(expr, s.symtable).getDeco()["type"].(Type) })
+	return t, err
+}
+
+// the session compiled as a program
+func (s *session) asm() (asm string, err error) {
+	defer catch(&err, nil)
+	s.root.deco["levelCnt"] = s.symtable.levelCnt
+	asm, _ = peephole(transasm(s.root))
+	return asm, nil
+}
+
+const replHelp = `Enter declarations, statements or an expression; a line with an unbalanced { continues on the next lines.
+:type expr   print the type of the expression
+:ast         print the syntax tree of the session
+:asm         print the assembly of the session
+:reset       start a new session
+:quit        leave
+`
+
+// the balance of the braces of the line, the strings and the comments are skipped
+func braceDepth(line string) int {
+	depth, quoted := 0, false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '/' && i+1 < len(line) && line[i+1] == '/':
+			return depth
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		}
+	}
+	return depth
+}
+
+// read the snippets and print the results, the errors are printed as well and do not end the session
+func repl(in io.Reader, out io.Writer, prompt bool) int {
+	s := newSession(out)
+	scanner := bufio.NewScanner(in)
+	input, depth := "", 0
+	for {
+		if prompt {
+			if depth > 0 {
+				fmt.Fprint(out, "...   ")
+			} else {
+				fmt.Fprint(out, "wend> ")
+			}
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Text()
+		input += line + "\n"
+		if depth += braceDepth(line); depth > 0 {
+			continue
+		}
+		text := strings.TrimSpace(input)
+		input, depth = "", 0
+		command, arg, _ := strings.Cut(text, " ")
+		var err error
+		switch {
+		case text == "":
+		case command == ":quit" || command == ":q":
+			return 0
+		case command == ":help":
+			fmt.Fprint(out, replHelp)
+		case command == ":reset":
+			s = newSession(out)
+		case command == ":type":
+			var t Type
+			if t, err = s.typeOf(arg); err == nil {
+				fmt.Fprintln(out, strings.ToLower(t.String()))
+			}
+		case command == ":ast":
+			fmt.Fprint(out, dumpJSON(s.root))
+		case command == ":asm":
+			var asm string
+			if asm, err = s.asm(); err == nil {
+				fmt.Fprintln(out, asm)
+			}
+		case strings.HasPrefix(command, ":"):
+			err = fmt.Errorf("unknown command %s, try :help", command)
+		default:
+			err = s.eval(text)
+		}
+		if err != nil {
+			fmt.Fprintln(out, "error:", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepl(t *testing.T) {
+	script := `int x;
+x = 6 * 7;
+x
+:type x > 1
+int fib(int n) {
+    if n < 2 {
+        return n;
+    }
+    return fib(n-1) + fib(n-2);
+}
+fib(20)
+fun(int):int f;
+f = fib;
+:type f
+println f(10) + x;
+x / 0
+y = 1;
+x = true;
+x
+float(x) / 8.0
+:bogus
+:reset
+x
+`
+	expected := `42
+bool
+6765
+fun(int):int
+97
+error: division by zero, line 1
+error: No declaration for the variable y
+error: Incompatible types in assignment statement, line 1
+42
+5.250000
+error: unknown command :bogus, try :help
+error: No declaration for the variable x
+`
+	var out bytes.Buffer
+	if code := repl(strings.NewReader(script), &out, false); code != 0 {
+		t.Errorf("exit code %d", code)
+	}
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+// the assembly of the session is a program that prints what the session has printed
+func TestReplAsm(t *testing.T) {
+	if _, err := exec.LookPath("as"); err != nil {
+		t.Skip("GNU as is not available")
+	}
+	var out bytes.Buffer
+	s := newSession(&out)
+	for _, snippet := range []string{"int x;", "int sq(int n) { return n * n; }", "x = sq(7);", "x + 1", `println "ok";`} {
+		if err := s.eval(snippet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	asm, err := s.asm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	asmname := filepath.Join(dir, "session.asm")
+	if err := os.WriteFile(asmname, []byte(asm), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runTool("as", "--march=i386+387", "--32", "-o", filepath.Join(dir, "session.o"), asmname); err != nil {
+		t.Fatal(err)
+	}
+	if err := runTool("ld", "-m", "elf_i386", "-o", filepath.Join(dir, "session"), filepath.Join(dir, "session.o")); err != nil {
+		t.Fatal(err)
+	}
+	exe, err := exec.Command(filepath.Join(dir, "session")).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(exe) != out.String() || out.String() != "50\nok\n" {
+		t.Errorf("the session printed %q, the program %q", out.String(), exe)
+	}
+}
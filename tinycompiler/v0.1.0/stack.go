@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The stack analysis bounds the bytes a program takes below its initial stack pointer. A call to a function takes
+// the frame built by the funcall template: the saved display entry, the arguments, the locals and the return
+// address; the body then pushes the temporaries of the expressions and the frames of its own calls. The total of a
+// function counts from the stack pointer of the caller before the call: a tail call rebuilds the frame in place, so
+// it costs the total of the callee and nothing more, a cycle of tail calls runs in constant space. An indirect call
+// may reach any function whose closure is created with the type of the closure called, through its thunk. The
+// whole program is analyzed at once, the imported functions are found in the libraries by their global labels.
+// A cycle with a call that is not a tail call is unbounded, a call to an extern function cannot be bounded.
+
+// the bytes taken by the runtime routines, the return address included
+var runtimeStack = map[string]int{
+	"print_int32":    24,
+	"print_int64":    36,
+	"print_float":    52,
+	"div_int64":      20,
+	"float_trunc":    16,
+	"wend_set_pixel": 4,
+	"wend_clear":     8,
+	"wend_present":   72,
+	"wend_sleep_ms":  12,
+	"wend_rand":      4,
+	"wend_new":       32,
+	"wend_len":       4,
+	"gfx_exit":       56,
+}
+
+// a call made by a function, the depth is the number of bytes the caller has pushed below its frame at the call
+type stackSite struct {
+	kind   string // call, tail, thunk (an imported function), indirect, builtin or extern
+	callee string // the label of the callee, or of the routine
+	depth  int
+	ft     Type // the type of the closure of an indirect call
+}
+
+// the bound of the stack use; an unbounded use tells why, e.g. the cycle found or the extern function called
+type stackBound struct {
+	bytes  int
+	reason string
+}
+
+type stackFun struct {
+	f     Function
+	entry int // the frame built by the call, the body starts below it
+	own   int // the temporaries and the runtime routines of the body
+	sites []stackSite
+}
+
+type stackAnalysis struct {
+	funs     map[string]*stackFun
+	order    []string
+	globals  map[string]string // the global label of an exported function -> its label
+	closures map[string]bool   // the functions whose closure is created
+	total    map[string]stackBound
+	cur      *stackFun
+}
+
+// analyze the program made of the source file and all the libraries it imports, directly or not; the tree of every
+// module goes through the same passes as for the compilation
+func programStack(path string, opts buildOptions) stackBound {
+	a := &stackAnalysis{funs: map[string]*stackFun{}, globals: map[string]string{}, closures: map[string]bool{},
+		total: map[string]stackBound{}}
+	var program Function
+	queue, seen := []string{path}, map[string]bool{path: true}
+	for len(queue) > 0 {
+		fun, deps := loadModule(queue[0])
+		buildSymtable(fun)
+		if !opts.noInline {
+			inline(&fun)
+		}
+		removeUnused(&fun, newCallGraph(fun))
+		if queue[0] == path {
+			program = fun
+		}
+		a.add(fun)
+		queue = queue[1:]
+		for _, dep := range deps {
+			if dep = filepath.Clean(dep); !seen[dep] {
+				seen[dep] = true
+				queue = append(queue, dep)
+			}
+		}
+	}
+	if program.deco["library"] == true {
+		return stackBound{reason: "a library has no entry point"}
+	}
+	a.solve()
+	bound := a.total[program.deco["label"].(string)]
+	if program.deco["gfx"] == true && bound.reason == "" { // the picture is closed once main has returned
+		bound.bytes = max(bound.bytes, runtimeStack["gfx_exit"])
+	}
+	return bound
+}
+
+// walk the functions of a module; main gets no saved display entry nor arguments from the entry point
+func (a *stackAnalysis) add(root Function) {
+	visitFuns(root, func(f Function) {
+		if f.deco["library"] == true {
+			return
+		}
+		entry := 8 + (argWords(f.deco["argtypes"].([]Type))+f.deco["varCnt"].(int))*4
+		if f.deco["label"] == root.deco["label"] {
+			entry = 4 + f.deco["varCnt"].(int)*4
+		}
+		if global, ok := f.deco["global"].(string); ok {
+			a.globals[global] = f.deco["label"].(string)
+		}
+		a.cur = &stackFun{f: f, entry: entry}
+		a.funs[f.deco["label"].(string)] = a.cur
+		a.order = append(a.order, f.deco["label"].(string))
+		a.stats(f.body, 0)
+	})
+}
+
+func (a *stackAnalysis) use(depth int) {
+	a.cur.own = max(a.cur.own, depth)
+}
+
+func (a *stackAnalysis) site(kind, callee string, depth int, ft Type) {
+	a.cur.sites = append(a.cur.sites, stackSite{kind, callee, depth, ft})
+}
+
+// the temporaries pushed by the templates of the statements and the expressions, depth is the number of bytes
+// pushed before the node is evaluated
+func (a *stackAnalysis) stats(ss []Statement, depth int) {
+	for _, s := range ss {
+		a.stat(s, depth)
+	}
+}
+
+func (a *stackAnalysis) stat(n Statement, depth int) {
+	switch e := n.(type) {
+	case Print:
+		a.expr(e.expr, depth)
+		switch e.expr.getDeco()["type"].(Type) {
+		case INT:
+			a.use(depth + 4 + runtimeStack["print_int32"])
+		case LONG:
+			a.use(depth + 8 + runtimeStack["print_int64"])
+		case FLOAT:
+			a.use(depth + 8 + runtimeStack["print_float"])
+		}
+		a.use(depth + 4) // the line break
+	case Return:
+		if e.expr != nil {
+			a.expr(e.expr, depth)
+		}
+	case Assign:
+		a.expr(e.expr, depth)
+		a.use(depth + 4)
+	case Store: // the array and the index are pushed while the value is evaluated
+		a.expr(e.array, depth)
+		a.expr(e.index, depth+4)
+		a.expr(e.expr, depth+8)
+	case FunCall:
+		a.expr(e, depth)
+	case Inline:
+		a.expr(e, depth)
+	case While:
+		a.expr(e.expr, depth)
+		a.stats(e.body, depth)
+	case IfThenElse:
+		a.expr(e.expr, depth)
+		a.stats(e.ibody, depth)
+		a.stats(e.ebody, depth)
+	case Switch: // the value is tested in a register
+		a.expr(e.expr, depth)
+		for _, c := range e.cases {
+			a.stats(c.body, depth)
+		}
+	}
+}
+
+func (a *stackAnalysis) expr(n Expression, depth int) {
+	a.use(depth)
+	switch e := n.(type) {
+	case ArithOp:
+		a.binary(e.op, e.left, e.right, depth)
+	case LogicOp:
+		a.binary(e.op, e.left, e.right, depth)
+	case Convert:
+		a.expr(e.expr, depth)
+		a.use(depth + 16 + runtimeStack["float_trunc"])
+	case New:
+		a.expr(e.size, depth)
+		a.use(depth + 8 + runtimeStack["wend_new"])
+	case Index:
+		a.expr(e.array, depth)
+		a.expr(e.index, depth+4)
+	case Inline:
+		a.stats(e.body, depth)
+		if e.expr != nil {
+			a.expr(e.expr, depth)
+		}
+	case FunCall:
+		if e.deco["extern"] == true {
+			a.site("extern", e.name, depth, 0)
+			return
+		}
+		base := depth
+		if e.deco["indirect"] != true && e.deco["tail"] == nil && e.deco["builtin"] != true && e.deco["import"] != true {
+			base += 4 // the saved display entry
+		}
+		for _, arg := range e.args {
+			a.expr(arg, base)
+			base += arg.getDeco()["type"].(Type).size() * 4
+		}
+		a.use(base)
+		switch {
+		case e.deco["indirect"] == true:
+			a.site("indirect", "", base, e.deco["closure"].(Var).deco["type"].(Type))
+		case e.deco["tail"] != nil:
+			a.site("tail", e.deco["label"].(string), base, 0)
+		case e.deco["builtin"] == true:
+			a.use(base + runtimeStack[e.deco["label"].(string)])
+		case e.deco["import"] == true:
+			a.site("thunk", e.deco["label"].(string), base, 0)
+		default:
+			a.site("call", e.deco["label"].(string), depth, 0)
+		}
+	case FunRef:
+		label := e.deco["fundeco"].(map[string]any)["label"].(string)
+		a.closures[label] = true
+	}
+}
+
+// the left operand is pushed while the right one is evaluated, then both are pushed for the x87 and div_int64
+func (a *stackAnalysis) binary(op string, left, right Expression, depth int) {
+	a.expr(left, depth)
+	switch t := left.getDeco()["type"].(Type); {
+	case op == "&&" || op == "||":
+		a.expr(right, depth)
+	case t == LONG:
+		a.expr(right, depth+8)
+		if op == "/" || op == "%" {
+			a.use(depth + 16 + runtimeStack["div_int64"])
+		}
+	case t == FLOAT:
+		a.expr(right, depth+8)
+		a.use(depth + 16)
+	default:
+		a.expr(right, depth+4)
+	}
+}
+
+// the functions a call may reach
+func (a *stackAnalysis) callees(s stackSite) []string {
+	switch s.kind {
+	case "call", "tail":
+		return []string{s.callee}
+	case "thunk":
+		if label, ok := a.globals[s.callee]; ok {
+			return []string{label}
+		}
+	case "indirect":
+		result := []string{}
+		for _, label := range a.order {
+			if a.closures[label] || a.closures[a.globalOf(label)] {
+				f := a.funs[label].f
+				if newFunType(f.deco["argtypes"].([]Type), f.deco["type"].(Type)) == s.ft {
+					result = append(result, label)
+				}
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+func (a *stackAnalysis) globalOf(label string) string {
+	global, _ := a.funs[label].f.deco["global"].(string)
+	return global
+}
+
+// the bytes a call takes from the stack pointer of the caller, once the callee is known
+func (a *stackAnalysis) cost(s stackSite, callee string) int {
+	if s.kind == "call" || s.kind == "tail" {
+		return a.total[callee].bytes
+	}
+	f := a.funs[callee].f // through the thunk: its return address and the display entries of the closure
+	return 4 + len(f.deco["ancestors"].([]int))*4 + a.total[callee].bytes
+}
+
+// the components of the calls are solved callees first; a component with a call that is not a tail call is
+// a recursion, its functions are unbounded, and so are their callers
+func (a *stackAnalysis) solve() {
+	for _, component := range components(a.order, func(label string) []string {
+		result := []string{}
+		for _, s := range a.funs[label].sites {
+			result = append(result, a.callees(s)...)
+		}
+		return result
+	}) {
+		inside := map[string]bool{}
+		for _, label := range component {
+			inside[label] = true
+		}
+		bound := stackBound{}
+		for _, label := range component {
+			sf := a.funs[label]
+			bytes := sf.entry + sf.own
+			for _, s := range sf.sites {
+				if s.kind == "extern" && bound.reason == "" {
+					bound.reason = fmt.Sprintf("the extern function %s is called", s.callee)
+				}
+				if s.kind == "thunk" && len(a.callees(s)) == 0 && bound.reason == "" {
+					bound.reason = fmt.Sprintf("the imported function %s is not found", s.callee)
+				}
+				for _, callee := range a.callees(s) {
+					if inside[callee] {
+						if s.kind != "tail" && bound.reason == "" {
+							bound.reason = "recursion " + a.cycle(label, callee, inside)
+						}
+						continue
+					}
+					if a.total[callee].reason != "" && bound.reason == "" {
+						bound.reason = a.total[callee].reason
+					}
+					if s.kind == "tail" {
+						bytes = max(bytes, a.cost(s, callee))
+					} else {
+						bytes = max(bytes, sf.entry+s.depth+a.cost(s, callee))
+					}
+				}
+			}
+			bound.bytes = max(bound.bytes, bytes)
+		}
+		for _, label := range component {
+			a.total[label] = bound
+		}
+	}
+}
+
+// the cycle through the call from the caller to the callee, a path back to the caller inside the component
+func (a *stackAnalysis) cycle(caller, callee string, inside map[string]bool) string {
+	from := map[string]string{callee: ""}
+	queue := []string{callee}
+	for len(queue) > 0 && caller != callee {
+		label := queue[0]
+		queue = queue[1:]
+		for _, s := range a.funs[label].sites {
+			for _, next := range a.callees(s) {
+				if _, seen := from[next]; !seen && inside[next] {
+					from[next] = label
+					queue = append(queue, next)
+				}
+			}
+		}
+		if _, ok := from[caller]; ok {
+			break
+		}
+	}
+	path := []string{}
+	for label := caller; label != ""; label = from[label] {
+		f := a.funs[label].f
+		path = append([]string{signatureOf(f.name, f.deco)}, path...)
+		if label == callee {
+			break
+		}
+	}
+	f := a.funs[caller].f
+	return strings.Join(append([]string{signatureOf(f.name, f.deco)}, path...), " -> ")
+}
+
+// reject the program if it may exceed the limit of --max-stack, otherwise report the bound with -v; a rejected
+// program is reported once, by the error
+func checkStack(path string, opts buildOptions) {
+	bound := programStack(path, opts)
+	if opts.maxStack > 0 && bound.reason != "" {
+		panic(fmt.Sprintf("Cannot bound the stack use: %s", bound.reason))
+	}
+	if opts.maxStack > 0 && bound.bytes > opts.maxStack {
+		panic(fmt.Sprintf("The stack use may reach %d bytes, the limit is %d", bound.bytes, opts.maxStack))
+	}
+	if opts.verbose && bound.reason != "" {
+		fmt.Fprintf(os.Stderr, "%s: the stack use cannot be bounded: %s\n", path, bound.reason)
+	} else if opts.verbose {
+		fmt.Fprintf(os.Stderr, "%s: the stack use is at most %d bytes\n", path, bound.bytes)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStack(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, source string
+		bytes        int
+		reason       string
+	}{
+		// main: 4 (x) + 4 (the return address); f: 4 (the saved display entry) + 4 (a) + 4 (the slot of a counted
+		// by varCnt) + 4 (the return address) + 8 (the long pushed) + 36 (print_int64)
+		{"leaf", `main() {
+    int x;
+    f(int a) {
+        println long(a);
+    }
+    x = 2;
+    f(x);
+}
+`, 8 + 60, ""},
+		{"recursion", `main() {
+    int fact(int n) {
+        if n < 2 {
+            return 1;
+        }
+        return n * fact(n - 1);
+    }
+    println fact(5);
+}
+`, 0, "recursion fact(int): int -> fact(int): int"},
+		{"mutual", `main() {
+    bool even(int n) {
+        if n == 0 {
+            return true;
+        }
+        return odd(n - 1);
+    }
+    bool odd(int n) {
+        if n == 0 {
+            return false;
+        }
+        println n;
+        return even(n - 1);
+    }
+    println odd(7);
+}
+`, 4 + 16 + 4 + 24, ""}, // main calls odd, the int printed by odd is pushed for print_int32, the tail calls reuse the frame
+		// main: 8 (the closure record) + 4 (the return address); apply: 4 (the saved display entry) + 4 (f) + 4 (its
+		// slot) + 4 (the return address) + 4 (the 3 pushed); the thunk: 4 (its return address) + 4 (the display entry
+		// of the closure); twice: 4 + 4 + 4 + 4 as apply, + 4 (the left operand of a * 2 pushed)
+		{"closure", `main() {
+    int twice(int a) {
+        return a * 2;
+    }
+    apply(fun(int):int f) {
+        println f(3);
+    }
+    apply(twice);
+}
+`, 12 + 20 + 8 + 20, ""},
+		{"extern", `extern int add3(int, int, int);
+main() {
+    println add3(1, 2, 3);
+}
+`, 0, "the extern function add3 is called"},
+	}
+	for _, test := range tests {
+		path := writeSource(t, dir, test.name, test.source)
+		bound := programStack(path, buildOptions{noInline: true})
+		if bound.reason != test.reason {
+			t.Errorf("%s: expected the reason %q, got %q", test.name, test.reason, bound.reason)
+		}
+		if test.bytes != 0 && bound.bytes != test.bytes {
+			t.Errorf("%s: expected %d bytes, got %d", test.name, test.bytes, bound.bytes)
+		}
+	}
+
+	err := func() (err error) {
+		defer catch(&err, nil)
+		checkStack(writeSource(t, dir, "leaf", tests[0].source), buildOptions{noInline: true, maxStack: 67})
+		return nil
+	}()
+	if err == nil || !strings.Contains(err.Error(), "may reach 68 bytes, the limit is 67") {
+		t.Errorf("expected the limit to be exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSwitch(t *testing.T) {
+	tests := []struct{ source, err string }{
+		{"main() {\n    switch true {\n    }\n}\n", "Non-integer expression in switch statement, line 1"},
+		{"main() {\n    switch 1 {\n        case 1L: println 1;\n    }\n}\n", "Non-integer case value in switch statement, line 2"},
+		{"main() {\n    switch 1 {\n        case 1, 2: println 1;\n        case 3 - 1: println 2;\n    }\n}\n", "Duplicate case 2 in switch statement, line 3"},
+		{"main() {\n    switch 1 {\n        default: println 1;\n        default: println 2;\n    }\n}\n", "Duplicate default case in switch statement, line 3"},
+		{"main() {\n    int x;\n    switch 1 {\n        case x: println 1;\n    }\n}\n", "The value of the case is not a constant expression, line 3"},
+	}
+	for _, test := range tests {
+		if err := analyzeError(test.source); err == nil || err.Error() != test.err {
+			t.Errorf("expected the error %q, got %v", test.err, err)
+		}
+	}
+
+	// the dense cases jump through a table, the sparse ones are compared in turn
+	for _, test := range []struct {
+		cases string
+		table bool
+	}{
+		{"case 1: case 2: case 3: case 4:", true},
+		{"case 10, 12: case 14, 16: default:", true},
+		{"case 1: case 2: case 3:", false},
+		{"case 1: case 100: case 1000: case 10000:", false},
+	} {
+		source := fmt.Sprintf("main() {\n    int x;\n    x = 1;\n    switch x {\n        %s\n    }\n}\n", test.cases)
+		ast := (&WendParser{}).Parse(tokenize(source)).(Function)
+		buildSymtable(ast)
+		asm := transasm(ast)
+		if table := strings.Contains(asm, "table:"); table != test.table {
+			t.Errorf("%s: expected a jump table %t:\n%s", test.cases, test.table, asm)
+		}
+	}
+
+	source := `main() {
+    int x;
+    int y;
+    int sign(int v) {
+        switch v {
+            case 0: return 0;
+            case 1: return 1;
+            default: return -1;
+        }
+        println v;
+    }
+    int f(int v) {
+        switch v {
+            case 0: return 0;
+        }
+    }
+    switch sign(f(1)) {
+        case 0: x = 1;
+        case 1: return;
+        default: x = 2; y = 3;
+    }
+    println x;
+    println y;
+}
+`
+	ast := (&WendParser{}).Parse(tokenize(source)).(Function)
+	buildSymtable(ast)
+	expected := "10: unreachable statement; 12: function f can reach the end without a return statement; " +
+		"23: variable y may be read before assignment"
+	warnings := []string{}
+	for _, w := range vet(ast) {
+		warnings = append(warnings, fmt.Sprintf("%d: %s", w.lineno+1, w.msg))
+	}
+	if got := strings.Join(warnings, "; "); got != expected {
+		t.Errorf("warnings: expected %s, got %s", expected, got)
+	}
+}
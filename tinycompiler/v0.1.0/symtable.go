@@ -1,12 +1,16 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type SymbolTable struct {
 	variables []map[string]map[string]any
 	functions []map[string]map[string]any
 	retStack  []*map[string]any
-	scopeCnt  int
+	scopeCnt  int // functions get unique scope ids
+	levelCnt  int // the display has one entry per nesting level
 }
 
 func newSymbolTable() *SymbolTable {
@@ -24,9 +28,9 @@ type Signature struct {
 func (s Signature) String() string {
 	var argtypes string
 	if len(s.argtypes) > 0 {
-		argtypes = TypeNames[s.argtypes[0]]
+		argtypes = s.argtypes[0].String()
 		for _, argtype := range s.argtypes[1:] {
-			argtypes += "," + TypeNames[argtype]
+			argtypes += "," + argtype.String()
 		}
 	}
 	return fmt.Sprintf("Signature{name:%s,argtypes:%s}", s.name, argtypes)
@@ -45,6 +49,8 @@ func (s *SymbolTable) addFun(name string, argtypes []Type, deco map[string]any)
 	s.functions[len(s.functions)-1][signature.String()] = deco
 	deco["scope"] = s.scopeCnt
 	s.scopeCnt++
+	deco["level"] = len(s.retStack) - 1
+	s.levelCnt = max(s.levelCnt, len(s.retStack))
 }
 
 func (s *SymbolTable) addVar(name string, deco *map[string]any) {
@@ -58,8 +64,17 @@ func (s *SymbolTable) addVar(name string, deco *map[string]any) {
 
 	s.variables[len(s.variables)-1][name] = *deco
 	(*deco)["scope"] = (*s.retStack[len(s.retStack)-1])["scope"]
+	(*deco)["level"] = (*s.retStack[len(s.retStack)-1])["level"]
 	(*deco)["offset"] = (*s.retStack[len(s.retStack)-1])["varCnt"]
-	(*s.retStack[len(s.retStack)-1])["varCnt"] = (*s.retStack[len(s.retStack)-1])["varCnt"].(int) + 1
+	(*s.retStack[len(s.retStack)-1])["varCnt"] = (*s.retStack[len(s.retStack)-1])["varCnt"].(int) + (*deco)["type"].(Type).size()
+}
+
+// a constant is a variable without a slot, its decoration holds its value
+func (s *SymbolTable) addConst(name string, deco map[string]any) {
+	if _, ok := s.variables[len(s.variables)-1][name]; ok {
+		panic(fmt.Sprintf("Double declaration of the variable %s", name))
+	}
+	s.variables[len(s.variables)-1][name] = deco
 }
 
 func (s *SymbolTable) pushScope(deco *map[string]any) {
@@ -76,14 +91,56 @@ func (s *SymbolTable) popScope() {
 }
 
 func (s *SymbolTable) findVar(name string) map[string]any {
+	if v, ok := s.lookupVar(name); ok {
+		return v
+	}
+	panic(fmt.Sprintf("No declaration for the variable %s", name))
+}
+
+func (s *SymbolTable) lookupVar(name string) (map[string]any, bool) {
 	for i := len(s.variables) - 1; i >= 0; i-- {
 		if v, ok := s.variables[i][name]; ok {
-			return v
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// a function name used as a value must be unambiguous: the innermost scope declaring the name must hold exactly one overload
+func (s *SymbolTable) findFunRef(name string) map[string]any {
+	prefix := Signature{name, nil}.String()
+	prefix = prefix[:len(prefix)-1]
+	for i := len(s.functions) - 1; i >= 0; i-- {
+		var found []map[string]any
+		for signature, v := range s.functions[i] {
+			if strings.HasPrefix(signature, prefix) {
+				found = append(found, v)
+			}
+		}
+		if len(found) > 1 {
+			panic(fmt.Sprintf("Ambiguous reference to the overloaded function %s", name))
+		}
+		if len(found) == 1 {
+			return found[0]
 		}
 	}
 	panic(fmt.Sprintf("No declaration for the variable %s", name))
 }
 
+// a variable of function type shadows the functions declared in outer scopes, the boolean result tells if the call is indirect
+func (s *SymbolTable) findCallee(name string, argtypes []Type) (map[string]any, bool) {
+	signature := Signature{name, argtypes}
+	for i := len(s.functions) - 1; i >= 0; i-- {
+		if v, ok := s.variables[i][name]; ok && v["type"].(Type).isFun() {
+			return v, true
+		}
+		if v, ok := s.functions[i][signature.String()]; ok {
+			return v, false
+		}
+	}
+	panic(fmt.Sprintf("No declaration for the variable %s", signature))
+}
+
 func (s *SymbolTable) findFun(name string, argtypes []Type) map[string]any {
 	signature := Signature{name, argtypes}
 	for i := len(s.functions) - 1; i >= 0; i-- {
@@ -1,5 +1,7 @@
 package main
 
+import "fmt"
+
 type Type int
 
 const (
@@ -7,16 +9,109 @@ const (
 	INT
 	BOOL
 	STRING
+	LONG
+	FLOAT
+	INTARRAY // an array is a reference to a block of the heap holding its length and its elements
+	BOOLARRAY
+	LONGARRAY
+	FLOATARRAY
 )
 
-var TypeNames = [...]string{"VOID", "INT", "BOOL", "STRING"}
+var TypeNames = [...]string{"VOID", "INT", "BOOL", "STRING", "LONG", "FLOAT", "INT[]", "BOOL[]", "LONG[]", "FLOAT[]"}
+
+var BasicTypes = map[string]Type{"int": INT, "bool": BOOL, "long": LONG, "float": FLOAT}
+
+// function types are interned: the type id of a function type is len(TypeNames) + its index in FunTypes,
+// so that two function types are equal iff their ids are equal
+type FunType struct {
+	args []Type
+	ret  Type
+}
+
+var FunTypes []FunType
+
+func newFunType(args []Type, ret Type) Type {
+	for i, ft := range FunTypes {
+		if ft.ret != ret {
+			continue
+		}
+		if sameTypes(ft.args, args) {
+			return Type(len(TypeNames) + i)
+		}
+	}
+	FunTypes = append(FunTypes, FunType{args, ret})
+	return Type(len(TypeNames) + len(FunTypes) - 1)
+}
+
+func sameTypes(a, b []Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// number of 32-bit stack slots taken by a value of the type
+func (t Type) size() int {
+	if t == LONG || t == FLOAT {
+		return 2
+	}
+	return 1
+}
+
+func (t Type) isNumeric() bool {
+	return t == INT || t == LONG || t == FLOAT
+}
+
+// the type of the arrays of the elements of type elem
+func arrayOf(elem Type) Type {
+	return map[Type]Type{INT: INTARRAY, BOOL: BOOLARRAY, LONG: LONGARRAY, FLOAT: FLOATARRAY}[elem]
+}
+
+func (t Type) isArray() bool {
+	return t >= INTARRAY && t <= FLOATARRAY
+}
+
+func (t Type) elem() Type {
+	return map[Type]Type{INTARRAY: INT, BOOLARRAY: BOOL, LONGARRAY: LONG, FLOATARRAY: FLOAT}[t]
+}
+
+func (t Type) isFun() bool {
+	return int(t) >= len(TypeNames)
+}
+
+func (t Type) funType() FunType {
+	return FunTypes[int(t)-len(TypeNames)]
+}
+
+func (t Type) String() string {
+	if !t.isFun() {
+		return TypeNames[t]
+	}
+	ft := t.funType()
+	var args string
+	for i, arg := range ft.args {
+		if i > 0 {
+			args += ","
+		}
+		args += arg.String()
+	}
+	if ft.ret == VOID {
+		return fmt.Sprintf("fun(%s)", args)
+	}
+	return fmt.Sprintf("fun(%s):%s", args, ft.ret)
+}
 
 type Function struct {
 	name string         // function name, string
 	args []Var          // function arguments, list of tuples (name, type)
 	vars []Var          // local variables, list of tuples (name, type)
 	fun  []Function     // nested functions, list of Function nodes
-	body []Statement    // function body, list of statement nodes (Print/Return/Assign/While/IfThenElse/FunCall)
+	body []Statement    // function body, list of statement nodes (Print/Return/Assign/Store/While/IfThenElse/Switch/FunCall)
 	deco map[string]any // decoration dictionary to be filled by the parser (line number) and by the semantic analyzer (return type, scope id etc)
 }
 
@@ -48,6 +143,16 @@ type Assign struct {
 
 func (s Assign) s() {}
 
+// an assignment to an element of an array, the array is a variable
+type Store struct {
+	array Expression
+	index Expression
+	expr  Expression
+	deco  map[string]any
+}
+
+func (s Store) s() {}
+
 type While struct {
 	expr Expression
 	body []Statement
@@ -65,6 +170,30 @@ type IfThenElse struct {
 
 func (s IfThenElse) s() {}
 
+type Switch struct {
+	expr  Expression
+	cases []Case // in the order of the source, the default case has no values
+	deco  map[string]any
+}
+
+func (s Switch) s() {}
+
+func (s Switch) hasDefault() bool {
+	for _, c := range s.cases {
+		if len(c.values) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// a case of a switch, its values are int literals once analyzed; there is no fall through
+type Case struct {
+	values []Expression
+	body   []Statement
+	deco   map[string]any
+}
+
 // expressions
 type Expression interface {
 	e()
@@ -99,6 +228,14 @@ type Integer struct {
 func (e Integer) e()                      {}
 func (e Integer) getDeco() map[string]any { return e.deco }
 
+type Float struct {
+	value float64
+	deco  map[string]any
+}
+
+func (e Float) e()                      {}
+func (e Float) getDeco() map[string]any { return e.deco }
+
 type Boolean struct {
 	value bool
 	deco  map[string]any
@@ -123,6 +260,43 @@ type Var struct {
 func (e Var) e()                      {}
 func (e Var) getDeco() map[string]any { return e.deco }
 
+// explicit conversion between numeric types, the target type is deco["type"]
+type Convert struct {
+	expr Expression
+	deco map[string]any
+}
+
+func (e Convert) e()                      {}
+func (e Convert) getDeco() map[string]any { return e.deco }
+
+// a new array of the type deco["type"] holding size elements, all of them zero
+type New struct {
+	size Expression
+	deco map[string]any
+}
+
+func (e New) e()                      {}
+func (e New) getDeco() map[string]any { return e.deco }
+
+// an element of an array
+type Index struct {
+	array Expression
+	index Expression
+	deco  map[string]any
+}
+
+func (e Index) e()                      {}
+func (e Index) getDeco() map[string]any { return e.deco }
+
+// a function name used as a value, evaluates to a closure
+type FunRef struct {
+	name string
+	deco map[string]any
+}
+
+func (e FunRef) e()                      {}
+func (e FunRef) getDeco() map[string]any { return e.deco }
+
 // depending on the context, a function call can be a statement or an expression
 type FunCall struct {
 	name string
@@ -133,3 +307,15 @@ type FunCall struct {
 func (e FunCall) s()                      {}
 func (e FunCall) e()                      {}
 func (e FunCall) getDeco() map[string]any { return e.deco }
+
+// an inlined call: the statements run in the frame of the caller, then the result is evaluated (nil for a procedure);
+// like a function call, it can be a statement or an expression
+type Inline struct {
+	body []Statement
+	expr Expression
+	deco map[string]any
+}
+
+func (e Inline) s()                      {}
+func (e Inline) e()                      {}
+func (e Inline) getDeco() map[string]any { return e.deco }
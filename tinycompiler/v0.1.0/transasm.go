@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"slices"
 	"strings"
 	"text/template"
 )
@@ -10,8 +12,12 @@ var Templates = map[string]string{
 	"ascii": `{{.Label}}: .ascii "{{.String}}"
 	{{.Label}}_len = . - {{.Label}}
 `,
-	"var": `	movl display+{{.Scope}}, %eax
-	movl -{{.Variable}}(%eax), %eax
+	"var": `	movl display+{{.Level}}, %eax
+	movl -{{.Variable}}(%eax), %eax{{.Name}}
+`,
+	"var_long": `	movl display+{{.Level}}, %ecx
+	movl -{{.Low}}(%ecx), %eax{{.Name}}
+	movl -{{.Variable}}(%ecx), %edx
 `,
 	"print_linebreak": `	pushl $10           # '\n'
 	movl $4, %eax       # write system call
@@ -26,6 +32,20 @@ var Templates = map[string]string{
 	call print_int32
 	addl $4, %esp
 {{.Newline}}
+`,
+	"print_long": `{{.Expr}}
+	pushl %edx
+	pushl %eax
+	call print_int64
+	addl $8, %esp
+{{.Newline}}
+`,
+	"print_float": `{{.Expr}}
+	pushl %edx
+	pushl %eax
+	call print_float
+	addl $8, %esp
+{{.Newline}}
 `,
 	"print_string": `	movl $4, %eax
 	movl $1, %ebx
@@ -48,9 +68,47 @@ var Templates = map[string]string{
 `,
 	"assign": `{{.Expression}}
 	pushl %eax
-	movl display+{{.Scope}}, %eax
+	movl display+{{.Level}}, %eax
 	popl %ebx
-	movl %ebx, -{{.Variable}}(%eax)
+	movl %ebx, -{{.Variable}}(%eax){{.Name}}
+`,
+	"assign_long": `{{.Expression}}
+	movl display+{{.Level}}, %ecx
+	movl %eax, -{{.Low}}(%ecx){{.Name}}
+	movl %edx, -{{.Variable}}(%ecx)
+`,
+	"store": `{{.Array}}
+	pushl %eax
+{{.Index}}
+	pushl %eax
+{{.Expression}}
+	popl %ebx
+	popl %ecx
+	test %ecx, %ecx     # a variable never assigned holds no array
+	jz wend_bounds
+	cmpl (%ecx), %ebx   # the length is the first word, a negative index is out of range as well
+	jae wend_bounds
+{{if .Long}}	movl %eax, 4(%ecx,%ebx,8)
+	movl %edx, 8(%ecx,%ebx,8)
+{{else}}	movl %eax, 4(%ecx,%ebx,4)
+{{end}}`,
+	"index": `{{.Array}}
+	pushl %eax
+{{.Index}}
+	popl %ecx
+	test %ecx, %ecx
+	jz wend_bounds
+	cmpl (%ecx), %eax
+	jae wend_bounds
+{{if .Long}}	movl 8(%ecx,%eax,8), %edx
+	movl 4(%ecx,%eax,8), %eax
+{{else}}	movl 4(%ecx,%eax,4), %eax
+{{end}}`,
+	"new": `{{.Size}}
+	pushl ${{.Elemsize}}
+	pushl %eax
+	call wend_new
+	addl $8, %esp
 `,
 	"ifthenelse": `{{.Condition}}
 	test %eax, %eax
@@ -68,39 +126,156 @@ var Templates = map[string]string{
 {{.Body}}
 	jmp {{.Label1}}
 {{.Label2}}:
+{{.Exit}}`,
+	"switch": `{{.Expression}}
+{{.Dispatch}}{{range .Cases}}{{.Label}}:
+{{.Body}}	jmp {{$.End}}
+{{end}}{{.End}}:
+`,
+	"switch_table": `{{if .Min}}	subl ${{.Min}}, %eax
+{{end}}	cmpl ${{.Span}}, %eax
+	ja {{.Default}}
+	jmp *{{.Table}}(,%eax,4)
+	.p2align 2
+{{.Table}}:
+{{range .Targets}}	.long {{.}}
+{{end}}`,
+	"switch_compare": `{{range .Tests}}	cmpl ${{.Value}}, %eax
+	je {{.Label}}
+{{end}}	jmp {{.Default}}
+`,
+	"logic": `{{.Left}}
+	test %eax, %eax
+	{{.Jump}} {{.Label}}
+{{.Right}}
+{{.Label}}:
 `,
-	"funcall": `	pushl display+{{.Scope}}
+	"funcall": `	pushl display+{{.Level}}
 {{.Allocargs}}
 	subl ${{.Varsize}}, %esp
 	leal {{.Disphead}}(%esp), %eax
-	movl %eax, display+{{.Scope}}
+	movl %eax, display+{{.Level}}
 	call {{.Funlabel}}
-	movl display+{{.Scope}}, %esp
+	movl display+{{.Level}}, %esp
 	addl $4, %esp
-	popl display+{{.Scope}}
+	popl display+{{.Level}}
 `,
-	"program": `.global _start
-	.data
-{{.Strings}}
-truestr: .ascii "true"
-	truestr_len = . - truestr
-falsestr: .ascii "false"
-	falsestr_len = . - falsestr
-	.align 2
-display: .skip {{.DisplaySize}}
+	"tailcall": `{{.Allocargs}}	movl display+{{.Level}}, %ecx
+	movl -{{.Retaddr}}(%ecx), %eax   # the return address of the current frame
+{{range .Moves}}	movl {{.Temp}}(%esp), %ebx
+	movl %ebx, -{{.Slot}}(%ecx)
+{{end}}	leal -{{.Frame}}(%ecx), %esp
+	movl %eax, (%esp)
+	jmp {{.Funlabel}}
+`,
+	"funcall_indirect": `{{.Allocargs}}{{.Closure}}	call *(%eax)        # %eax points to the closure record, its first word is the thunk
+	addl ${{.Argsize}}, %esp
+`,
+	"funcall_import": `{{.Allocargs}}	call {{.Funlabel}}   # the entry point of an imported function is its thunk
+	addl ${{.Argsize}}, %esp
+`,
+	"closure": `	movl display+{{.Level}}, %eax
+	movl ${{.Thunk}}, -{{.Entry}}(%eax)
+{{range .Ancestors}}	movl display+{{.Display}}, %ebx
+	movl %ebx, -{{.Slot}}(%eax)
+{{end}}	leal -{{.Entry}}(%eax), %eax
+`,
+	"thunk": `{{.Label}}_thunk:
+	pushl display+{{.Level}}
+{{range .Ancestors}}	pushl display+{{.Display}}
+{{end}}{{range .Ancestors}}	movl {{.Record}}(%eax), %ebx   # restore the static context captured by the closure
+	movl %ebx, display+{{.Display}}
+{{end}}{{range .Args}}	pushl {{$.Argoffset}}(%esp)
+{{end}}	subl ${{.Varsize}}, %esp
+	leal {{.Disphead}}(%esp), %eax
+	movl %eax, display+{{.Level}}
+	call {{.Label}}
+	movl display+{{.Level}}, %esp
+	addl $4, %esp
+{{range .Restore}}	popl display+{{.}}
+{{end}}	popl display+{{.Level}}
+	ret
+`,
+	"profile_wrapper": `	.data
+{{.Label}}_name: .ascii "{{.Name}}"
+	{{.Label}}_name_len = . - {{.Label}}_name
+	.section profile, "aw"
+	.balign 4
+{{.Label}}_profile: .long 0, 0, 0, 0, 0, 0, {{.Label}}_name, {{.Label}}_name_len
 	.text
+{{.Label}}:
+	subl $24, %esp      # the profiling frame: record, start time, time of the callees, frame of the caller
+	movl ${{.Label}}_profile, (%esp)
+	call profile_enter
+	call {{.Label}}_body
+	call profile_exit
+	addl $24, %esp
+	ret
+{{.Label}}_body:
+`,
+	"zero_arrays": `	movl display+{{.Level}}, %eax
+{{range .Slots}}	movl $0, -{{.}}(%eax)   # no array until assigned, the slot may hold a stale word
+{{end}}`,
+	"funcall_builtin": `{{.Allocargs}}	call {{.Funlabel}}   # a routine of the runtime
+	addl ${{.Argsize}}, %esp
+`,
+	"funcall_extern": `	movl %esp, %eax
+	subl ${{.Argsize}}+4, %esp
+	andl $-16, %esp     # the stack must be 16-byte aligned at the call
+	movl %eax, {{.Argsize}}(%esp)
+{{.Allocargs}}	call {{.Funlabel}}
+	movl {{.Argsize}}(%esp), %esp
+{{.Result}}`,
+	"program": `{{.Data}}
+{{.Entry}}
+{{.Functions}}
+`,
+	"start": `	.comm wend_stack_base, 4, 4 # the collectors of the heaps scan the stack up to it
+.global _start
 _start:
+	movl %esp, wend_stack_base
 	leal -4(%esp), %eax
 	movl %eax, display+{{.Offset}}
 	subl ${{.Varsize}}, %esp # allocate locals
 	call {{.Main}}
 	addl ${{.Varsize}}, %esp # deallocate locals
-_end:               # do not care about clearing the stack
+{{.Report}}_end:               # do not care about clearing the stack
 	movl $1, %eax   # _exit system call (check asm/unistd_32.h for the table)
 	movl $0, %ebx   # error code 0
-	int $0x80       # make system call
+	int $0x80       # make system call`,
+	"start_libc": `	.comm wend_stack_base, 4, 4
+.global main
+main:                   # called by the C runtime, the registers it expects to be preserved are saved
+	pushl %ebx
+	pushl %esi
+	pushl %edi
+	pushl %ebp
+	movl %esp, wend_stack_base
+	leal -4(%esp), %eax
+	movl %eax, display+{{.Offset}}
+	subl ${{.Varsize}}, %esp # allocate locals
+	call {{.Main}}
+	addl ${{.Varsize}}, %esp # deallocate locals
+{{.Report}}	popl %ebp
+	popl %edi
+	popl %esi
+	popl %ebx
+	xorl %eax, %eax # exit code 0, returning lets the C library flush its buffers
+	ret`,
+	"library": `{{.Data}}
 {{.Functions}}
-print_int32:
+`,
+	"data": `	.data
+{{.Strings}}
+truestr: .ascii "true"
+	truestr_len = . - truestr
+falsestr: .ascii "false"
+	falsestr_len = . - falsestr
+	.align 2
+print_fd: .long 1   # the output of the print routines of the runtime
+display: .skip {{.DisplaySize}}
+	.text`,
+	"runtime": `print_int32:
 	movl 4(%esp), %eax  # the number to print
 	cdq
 	xorl %edx, %eax
@@ -120,13 +295,886 @@ print_int32:
 	decl %ecx           # allocate one more character
 	movb $45, 0(%ecx)   # "-"
 0:	movl $4, %eax       # write system call
-	movl $1, %ebx       # stdout
+	movl print_fd, %ebx
 	leal 16(%esp), %edx # the buffer to print
 	subl %ecx, %edx     # number of digits
 	int $0x80           # make system call
 	addl $20, %esp      # deallocate the buffer
 	ret
-`}
+print_int64:
+	movl 4(%esp), %eax  # low half of the number to print
+	movl 8(%esp), %edx  # high half
+	pushl %esi
+	pushl %edi
+	movl %edx, %edi     # keep the sign
+	test %edx, %edx
+	jns 0f
+	negl %eax           # abs(%edx:%eax), -2^63 stays as is and is right when seen as unsigned
+	adcl $0, %edx
+	negl %edx
+0:	subl $24, %esp      # max 19 digits and a sign (keep %esp dword-aligned)
+	leal 24(%esp), %ecx # the digits are written backwards from the end of the buffer
+	movl $10, %ebx
+1:	movl %eax, %esi     #     long division by 10 in two steps:
+	movl %edx, %eax     #     high half first,
+	xorl %edx, %edx
+	divl %ebx
+	xchgl %eax, %esi    #     %esi = high/10
+	divl %ebx           #     then (high%10):low, %edx = the digit
+	decl %ecx
+	addb $48, %dl
+	movb %dl, (%ecx)
+	movl %esi, %edx
+	orl %eax, %esi
+	jnz 1b              # until %edx:%eax==0
+	test %edi, %edi     # if the number is negative
+	jns 2f
+	decl %ecx
+	movb $45, 0(%ecx)   # "-"
+2:	movl $4, %eax       # write system call
+	movl print_fd, %ebx
+	leal 24(%esp), %edx
+	subl %ecx, %edx     # number of characters
+	int $0x80
+	addl $24, %esp
+	popl %edi
+	popl %esi
+	ret
+print_float:            # print 4(%esp) with 6 decimals, rounded to the nearest; from 10^12 on with an exponent
+	fldl 4(%esp)
+	fucom %st(0)
+	fnstsw %ax
+	sahf
+	jp 6f               # NaN, whatever its sign bit
+	movl 8(%esp), %eax
+	test %eax, %eax     # the sign bit
+	jns 0f
+	pushl $45           # "-"
+	movl $4, %eax
+	movl print_fd, %ebx
+	leal 0(%esp), %ecx
+	movl $1, %edx
+	int  $0x80
+	addl $4, %esp
+0:	fabs
+	pushl $0x426d1a94   # 10^12
+	pushl $0xa2000000
+	fldl (%esp)
+	addl $8, %esp
+	fucomp %st(1)
+	fnstsw %ax
+	sahf
+	jbe 4f              # 10^12 <= |x|
+	pushl $-1           # no exponent
+	pushl $1000000
+	fimull (%esp)       # |x|*10^6
+	subl $4, %esp
+	fistpll (%esp)      # rounded to a 64-bit integer
+1:	popl %eax           # the digits in %edx:%eax, 6 of them after the point, the exponent above them
+	popl %edx
+	pushl $0
+	pushl $1000000
+	pushl %edx
+	pushl %eax
+	call div_int64      # integer part in %edx:%eax, fraction in %ebx
+	addl $16, %esp
+	pushl %ebx
+	pushl %edx
+	pushl %eax
+	call print_int64
+	addl $8, %esp
+	popl %eax           # the fraction
+	pushl %esi
+	subl $8, %esp
+	movb $46, (%esp)    # "."
+	leal 1(%esp), %esi
+	leal 7(%esp), %ecx  # exactly 6 digits, with leading zeros
+	movl $10, %ebx
+2:	xorl %edx, %edx
+	divl %ebx
+	decl %ecx
+	addb $48, %dl
+	movb %dl, (%ecx)
+	cmpl %esi, %ecx
+	jne 2b
+	movl $4, %eax
+	movl print_fd, %ebx
+	movl %esp, %ecx
+	movl $7, %edx
+	int  $0x80
+	addl $8, %esp
+	popl %esi
+	cmpl $0, (%esp)
+	jl 3f
+	pushl $0x2b65       # "e+"
+	movl $4, %eax
+	movl print_fd, %ebx
+	movl %esp, %ecx
+	movl $2, %edx
+	int  $0x80
+	addl $4, %esp
+	call print_int32    # the exponent
+3:	addl $4, %esp
+	ret
+4:	fxam
+	fnstsw %ax
+	andb $0x45, %ah
+	cmpb $5, %ah        # C3=0 C2=1 C0=1: infinite
+	je 7f
+	pushl $12           # the exponent e, the digits are |x|/10^(e-6) rounded to the nearest
+	pushl $1000000
+	fildl (%esp)        # 10^(e-6), exact up to 10^27
+5:	movl $10000000, (%esp)
+	fildl (%esp)
+	fmul %st(1), %st
+	fucomp %st(2)
+	fnstsw %ax
+	sahf
+	ja 8f               # |x| < 10^(e+1)
+	movl $10, (%esp)
+	fimull (%esp)
+	incl 4(%esp)
+	jmp 5b
+8:	fdivrp %st, %st(1)  # |x|/10^(e-6)
+	frndint
+	movl $10000000, (%esp)
+	ficoml (%esp)
+	fnstsw %ax
+	sahf
+	jne 9f
+	movl $10, (%esp)    # rounded up to 10^7
+	fidivl (%esp)
+	incl 4(%esp)
+9:	subl $4, %esp
+	fistpll (%esp)
+	jmp 1b
+6:	pushl $0x6e616e     # "nan"
+	jmp 0f
+7:	pushl $0x666e69     # "inf", after the sign
+0:	fstp %st(0)
+	movl $4, %eax
+	movl print_fd, %ebx
+	movl %esp, %ecx
+	movl $3, %edx
+	int  $0x80
+	addl $4, %esp
+	ret
+div_int64:              # signed division of 4(%esp) (low, high) by 12(%esp) (low, high)
+	pushl %ebp          # quotient is returned in %edx:%eax, remainder in %ecx:%ebx
+	pushl %esi
+	pushl %edi
+	movl 24(%esp), %ebx
+	movl 28(%esp), %ecx # divisor
+	movl %ebx, %eax
+	orl %ecx, %eax
+	jnz 0f
+	divl %eax           # division by zero traps like its 32-bit counterpart
+0:	xorl %ebp, %ebp     # bit 0: negative quotient, bit 1: negative remainder
+	test %ecx, %ecx
+	jns 1f
+	negl %ebx
+	adcl $0, %ecx
+	negl %ecx
+	xorl $1, %ebp
+1:	movl 16(%esp), %eax
+	movl 20(%esp), %edx # dividend
+	test %edx, %edx
+	jns 2f
+	negl %eax
+	adcl $0, %edx
+	negl %edx
+	xorl $3, %ebp
+2:	xorl %esi, %esi     # %edi:%esi is the running remainder,
+	xorl %edi, %edi     # the dividend is shifted out of %edx:%eax while the quotient is shifted in
+	pushl $64
+3:	shll $1, %eax
+	rcll $1, %edx
+	rcll $1, %esi
+	rcll $1, %edi
+	cmpl %ecx, %edi     # unsigned comparison of the remainder with the divisor
+	ja 4f
+	jb 5f
+	cmpl %ebx, %esi
+	jb 5f
+4:	subl %ebx, %esi
+	sbbl %ecx, %edi
+	orl $1, %eax
+5:	decl (%esp)
+	jnz 3b
+	addl $4, %esp
+	test $1, %ebp
+	jz 6f
+	negl %eax
+	adcl $0, %edx
+	negl %edx
+6:	test $2, %ebp
+	jz 7f
+	negl %esi
+	adcl $0, %edi
+	negl %edi
+7:	movl %esi, %ebx
+	movl %edi, %ecx
+	popl %edi
+	popl %esi
+	popl %ebp
+	ret
+float_trunc:            # the double at 4(%esp) truncated toward zero into %edx:%eax, saturated
+	fldl 12(%esp)       # to [-hi, hi) where hi = 2^31 or 2^63 is the double at 12(%esp), NaN is 0
+	fldl 4(%esp)
+	fucom %st(0)
+	fnstsw %ax
+	sahf
+	jp 3f
+	fucom %st(1)        # compare with hi
+	fnstsw %ax
+	sahf
+	jae 2f
+	fxch
+	fchs
+	fucom %st(1)        # compare -hi with the value
+	fnstsw %ax
+	sahf
+	jbe 1f
+	fxch                # below the range: -hi is truncated instead
+1:	fstp %st(0)
+	subl $12, %esp
+	fnstcw 8(%esp)      # round toward zero as C does
+	movw 8(%esp), %ax
+	orw $0x0c00, %ax
+	movw %ax, 10(%esp)
+	fldcw 10(%esp)
+	fistpll (%esp)
+	fldcw 8(%esp)
+	popl %eax
+	popl %edx
+	addl $4, %esp
+	ret
+2:	fstp %st(0)         # above the range: hi - 1, 2^63 is stored as -2^63 which wraps around as well
+	subl $8, %esp
+	fistpll (%esp)
+	popl %eax
+	popl %edx
+	subl $1, %eax
+	sbbl $0, %edx
+	ret
+3:	fstp %st(0)
+	fstp %st(0)
+	xorl %eax, %eax
+	xorl %edx, %edx
+	ret
+`,
+	// a record holds the number of calls, the number of active calls, the total time (the calls nested
+	// in a recursion are not counted twice), the time spent in the function itself, and the name
+	"runtime_profile": `	.data
+profile_header: .ascii "function\tcalls\ttotal cycles\tself cycles\n"
+	profile_header_len = . - profile_header
+	.comm profile_frame, 4, 4
+	.text
+profile_enter:          # the profiling frame is at 4(%esp)
+	leal 4(%esp), %ecx
+	movl profile_frame, %eax
+	movl %eax, 20(%ecx) # link to the frame of the caller
+	movl %ecx, profile_frame
+	movl (%ecx), %eax
+	incl (%eax)         # calls
+	incl 4(%eax)        # active calls
+	movl $0, 12(%ecx)
+	movl $0, 16(%ecx)
+	.byte 0x0f, 0x31    # rdtsc, the assembler accepts the i386 instruction set only
+	movl %eax, 4(%ecx)
+	movl %edx, 8(%ecx)
+	ret
+profile_exit:           # the profiling frame is at 4(%esp), the result in %edx:%eax is preserved
+	pushl %eax
+	pushl %edx
+	.byte 0x0f, 0x31    # rdtsc, the assembler accepts the i386 instruction set only
+	leal 12(%esp), %ecx
+	subl 4(%ecx), %eax
+	sbbl 8(%ecx), %edx  # the time of the call
+	movl 20(%ecx), %ebx
+	movl %ebx, profile_frame
+	test %ebx, %ebx
+	jz 0f
+	addl %eax, 12(%ebx) # is charged to the callees of the caller
+	adcl %edx, 16(%ebx)
+0:	movl (%ecx), %ebx
+	decl 4(%ebx)
+	jnz 1f
+	addl %eax, 8(%ebx)  # total time, once the outermost active call is over
+	adcl %edx, 12(%ebx)
+1:	subl 12(%ecx), %eax
+	sbbl 16(%ecx), %edx
+	addl %eax, 16(%ebx) # self time
+	adcl %edx, 20(%ebx)
+	popl %edx
+	popl %eax
+	ret
+profile_char:           # write the character 4(%esp) to stderr
+	movl $4, %eax
+	movl $2, %ebx
+	leal 4(%esp), %ecx
+	movl $1, %edx
+	int $0x80
+	ret
+profile_report:         # sort the records by self time (selection sort) and print the called functions to stderr
+	pushl %esi
+	pushl %edi
+	movl $2, print_fd
+	movl $4, %eax
+	movl $2, %ebx
+	movl $profile_header, %ecx
+	movl $profile_header_len, %edx
+	int $0x80
+	movl $__start_profile, %esi
+0:	cmpl $__stop_profile, %esi
+	jae 6f
+	movl %esi, %edi     # the record with the largest self time
+	leal 32(%esi), %ecx
+1:	cmpl $__stop_profile, %ecx
+	jae 3f
+	movl 20(%ecx), %eax
+	cmpl 20(%edi), %eax
+	ja 2f
+	jb 7f
+	movl 16(%ecx), %eax
+	cmpl 16(%edi), %eax
+	jbe 7f
+2:	movl %ecx, %edi
+7:	addl $32, %ecx
+	jmp 1b
+3:	xorl %ecx, %ecx     # swap the records
+4:	movl (%esi,%ecx,4), %eax
+	movl (%edi,%ecx,4), %edx
+	movl %edx, (%esi,%ecx,4)
+	movl %eax, (%edi,%ecx,4)
+	incl %ecx
+	cmpl $8, %ecx
+	jne 4b
+	cmpl $0, (%esi)
+	je 5f
+	movl $4, %eax
+	movl $2, %ebx
+	movl 24(%esi), %ecx
+	movl 28(%esi), %edx
+	int $0x80
+	pushl $9            # '\t'
+	call profile_char
+	movl $0, (%esp)
+	pushl (%esi)
+	call print_int64
+	movl $9, (%esp)
+	call profile_char
+	movl 12(%esi), %eax
+	movl %eax, 4(%esp)
+	movl 8(%esi), %eax
+	movl %eax, (%esp)
+	call print_int64
+	movl $9, (%esp)
+	call profile_char
+	movl 20(%esi), %eax
+	movl %eax, 4(%esp)
+	movl 16(%esi), %eax
+	movl %eax, (%esp)
+	call print_int64
+	movl $10, (%esp)    # '\n'
+	call profile_char
+	addl $8, %esp
+5:	addl $32, %esi
+	jmp 0b
+6:	movl $1, print_fd
+	popl %edi
+	popl %esi
+	ret
+`,
+	// a counter holds its value and the text that names it
+	"coverage": `	.data
+{{range .Points}}{{.Label}}_text: .ascii "{{.Text}}"
+	{{.Label}}_text_len = . - {{.Label}}_text
+{{end}}	.section coverage, "aw"
+	.balign 4
+{{range .Points}}{{.Label}}: .long 0, 0, {{.Label}}_text, {{.Label}}_text_len
+{{end}}	.text
+`,
+	"runtime_coverage": `	.data
+coverage_file: .asciz "{{.File}}"
+	.text
+coverage_dump:          # write the counters to the coverage file, one "path:line kind count" line each
+	pushl %esi
+	movl $5, %eax       # open system call
+	movl $coverage_file, %ebx
+	movl $0x241, %ecx   # O_WRONLY|O_CREAT|O_TRUNC
+	movl $0644, %edx
+	int $0x80
+	test %eax, %eax
+	js 2f               # the coverage is lost, the program still exits normally
+	movl %eax, print_fd
+	movl $__start_coverage, %esi
+0:	cmpl $__stop_coverage, %esi
+	jae 1f
+	movl $4, %eax
+	movl print_fd, %ebx
+	movl 8(%esi), %ecx
+	movl 12(%esi), %edx
+	int $0x80
+	pushl 4(%esi)
+	pushl (%esi)
+	call print_int64
+	movl $10, (%esp)    # '\n'
+	movl $4, %eax
+	movl print_fd, %ebx
+	movl %esp, %ecx
+	movl $1, %edx
+	int $0x80
+	addl $8, %esp
+	addl $16, %esi
+	jmp 0b
+1:	movl $6, %eax       # close system call
+	movl print_fd, %ebx
+	int $0x80
+	movl $1, print_fd
+2:	popl %esi
+	ret
+`,
+	// the graphics runtime, see gfx.go; the state is in common symbols shared by all the objects of the program
+	"runtime_gfx": `	.data
+gfx_init: .ascii "\033[2J\033[?25l"
+	gfx_init_len = . - gfx_init
+gfx_fini: .ascii "\033[?25h\033["
+	gfx_fini_len = . - gfx_fini
+	.comm gfx_back, {{.Size}}, 4
+	.comm gfx_front, {{.Size}}, 4
+	.comm gfx_out, {{.Out}}, 4
+	.comm gfx_width, 4, 4
+	.comm gfx_height, 4, 4
+	.comm gfx_presented, 4, 4
+	.comm gfx_seed, 4, 4
+	.text
+gfx_itoa:               # append the decimal digits of %eax to the buffer at %edi
+	movl $10, %ebx
+	xorl %ecx, %ecx
+0:	xorl %edx, %edx
+	divl %ebx
+	pushl %edx
+	incl %ecx
+	test %eax, %eax
+	jnz 0b
+1:	popl %eax
+	addb $48, %al
+	movb %al, (%edi)
+	incl %edi
+	loop 1b
+	ret
+gfx_write:              # write the buffer up to %edi to stdout
+	movl $4, %eax
+	movl $1, %ebx
+	movl $gfx_out, %ecx
+	movl %edi, %edx
+	subl $gfx_out, %edx
+	int $0x80
+	ret
+wend_set_pixel:         # set_pixel(x, y, r, g, b) draws to the back buffer, the pixels off the screen are ignored
+	movl 20(%esp), %ecx # x
+	movl 16(%esp), %edx # y
+	cmpl ${{.Width}}, %ecx
+	jae 0f              # unsigned comparisons: the negative coordinates are off the screen as well
+	cmpl ${{.Height}}, %edx
+	jae 0f
+	movl 12(%esp), %eax
+	andl $255, %eax
+	orl $256, %eax      # the pixel is drawn: 0x01rrggbb
+	shll $8, %eax
+	movb 8(%esp), %al
+	shll $8, %eax
+	movb 4(%esp), %al
+	leal 1(%ecx), %ebx  # extend the bounding box
+	cmpl gfx_width, %ebx
+	jbe 1f
+	movl %ebx, gfx_width
+1:	leal 1(%edx), %ebx
+	cmpl gfx_height, %ebx
+	jbe 2f
+	movl %ebx, gfx_height
+2:	imull ${{.Width}}, %edx
+	addl %ecx, %edx
+	movl %eax, gfx_back(,%edx,4)
+0:	ret
+wend_clear:             # fill the back buffer with black
+	pushl %edi
+	movl $gfx_back, %edi
+	movl ${{.Pixels}}, %ecx
+	movl $0x1000000, %eax
+	cld
+	rep stosl
+	popl %edi
+	ret
+wend_present:           # write the pixels that differ from the front buffer, they are copied to it
+	pushl %esi
+	pushl %edi
+	pushl %ebp
+	movl $gfx_out, %edi
+	cmpl $0, gfx_presented
+	jne 0f
+	movl $1, gfx_presented
+	movl $gfx_init, %esi # the first frame clears the terminal and hides the cursor
+	movl $gfx_init_len, %ecx
+	cld
+	rep movsb
+0:	xorl %ebp, %ebp     # the colour in use
+	pushl $-1           # 8(%esp): the pixel under the cursor
+	pushl $0            # 4(%esp): y
+	pushl $0            # (%esp): x
+1:	movl 4(%esp), %eax
+	cmpl gfx_height, %eax
+	jae 6f
+	movl $0, (%esp)
+2:	movl (%esp), %eax
+	cmpl gfx_width, %eax
+	jae 5f
+	movl 4(%esp), %esi
+	imull ${{.Width}}, %esi
+	addl (%esp), %esi   # the index of the pixel
+	movl gfx_back(,%esi,4), %eax
+	cmpl gfx_front(,%esi,4), %eax
+	je 4f
+	movl %eax, gfx_front(,%esi,4)
+	cmpl 8(%esp), %esi
+	je 3f
+	movw $0x5b1b, (%edi) # move the cursor: "\033[y;xH"
+	addl $2, %edi
+	movl 4(%esp), %eax
+	incl %eax
+	call gfx_itoa
+	movb $59, (%edi)
+	incl %edi
+	movl (%esp), %eax
+	incl %eax
+	call gfx_itoa
+	movb $72, (%edi)
+	incl %edi
+3:	movl gfx_front(,%esi,4), %eax
+	cmpl %ebp, %eax
+	je 7f
+	movl %eax, %ebp     # set the colour: "\033[48;2;r;g;bm"
+	movl $0x38345b1b, (%edi)
+	movb $59, 4(%edi)
+	movb $50, 5(%edi)
+	movb $59, 6(%edi)
+	addl $7, %edi
+	movl %ebp, %eax
+	shrl $16, %eax
+	andl $255, %eax
+	call gfx_itoa
+	movb $59, (%edi)
+	incl %edi
+	movl %ebp, %eax
+	shrl $8, %eax
+	andl $255, %eax
+	call gfx_itoa
+	movb $59, (%edi)
+	incl %edi
+	movl %ebp, %eax
+	andl $255, %eax
+	call gfx_itoa
+	movb $109, (%edi)
+	incl %edi
+7:	movb $32, (%edi)    # the pixel
+	incl %edi
+	leal 1(%esi), %eax
+	movl %eax, 8(%esp)
+4:	incl (%esp)
+	jmp 2b
+5:	incl 4(%esp)
+	jmp 1b
+6:	addl $12, %esp
+	cmpl $gfx_out, %edi
+	je 8f
+	movl $0x6d305b1b, (%edi) # reset the colour: "\033[0m"
+	addl $4, %edi
+	call gfx_write
+8:	popl %ebp
+	popl %edi
+	popl %esi
+	ret
+wend_sleep_ms:          # nanosleep system call
+	movl 4(%esp), %eax
+	test %eax, %eax
+	jle 0f
+	xorl %edx, %edx
+	movl $1000, %ecx
+	divl %ecx
+	imull $1000000, %edx
+	pushl %edx          # nanoseconds
+	pushl %eax          # seconds
+	movl $162, %eax
+	movl %esp, %ebx
+	xorl %ecx, %ecx
+	int $0x80
+	addl $8, %esp
+0:	ret
+wend_rand:              # xorshift32, the result is not negative
+	movl gfx_seed, %eax
+	test %eax, %eax
+	jnz 0f
+	movl ${{.Seed}}, %eax
+0:	movl %eax, %edx
+	shll $13, %edx
+	xorl %edx, %eax
+	movl %eax, %edx
+	shrl $17, %edx
+	xorl %edx, %eax
+	movl %eax, %edx
+	shll $5, %edx
+	xorl %edx, %eax
+	movl %eax, gfx_seed
+	shrl $1, %eax
+	ret
+gfx_exit:               # show the cursor again below the picture: "\033[?25h\033[height+1;1H"
+	cmpl $0, gfx_presented
+	je 0f
+	pushl %esi
+	pushl %edi
+	movl $gfx_out, %edi
+	movl $gfx_fini, %esi
+	movl $gfx_fini_len, %ecx
+	cld
+	rep movsb
+	movl gfx_height, %eax
+	incl %eax
+	call gfx_itoa
+	movl $0x48313b, (%edi) # ";1H"
+	addl $3, %edi
+	call gfx_write
+	popl %edi
+	popl %esi
+0:	ret
+`,
+	// heapSize bytes are reserved with mmap at the first allocation, a block is taken from the free list (first fit)
+	// or from the top of the heap; the elements of a long or a float array are 8-byte aligned. Once the allocations
+	// pass the budget, the collector marks the blocks a word of the stack points to, from the stack pointer up to the
+	// one of the entry point, and merges the others into the free list; an integer that looks like a reference keeps
+	// a block alive. An array references no other block, the marking stops at the roots. The budget is the live bytes
+	// plus heapInitial, so the heap stays about twice as large as the arrays in use. Every module has a heap of its
+	// own, all of them scan the whole stack.
+	"runtime_heap": `	.data
+heap_bits: .long 0      # the mark bits, one per 8 bytes of the heap
+heap_base: .long 0      # the heap is reserved by the first allocation
+heap_top: .long 0       # the end of the blocks
+heap_end: .long 0
+heap_budget: .long 0    # the bytes that may be allocated before the collector runs
+heap_free: .long 0      # the free blocks in the order of the addresses, linked by their second word
+heap_bounds: .ascii "{{.Bounds}}\n"
+	heap_bounds_len = . - heap_bounds
+heap_negative: .ascii "{{.Negative}}\n"
+	heap_negative_len = . - heap_negative
+heap_memory: .ascii "{{.Memory}}\n"
+	heap_memory_len = . - heap_memory
+	.text
+wend_new:               # a new array of 4(%esp) elements of 8(%esp) bytes, all of them zero
+	pushl %edi
+	movl 8(%esp), %eax
+	test %eax, %eax
+	js heap_negative_size
+	mull 12(%esp)       # the bytes of the elements
+	test %edx, %edx
+	jnz heap_exhausted
+	cmpl ${{.Size}}, %eax
+	ja heap_exhausted
+	leal 15(%eax), %ecx # the header and the length too, rounded up to 8 bytes
+	andl $-8, %ecx
+	cmpl $0, heap_base
+	jne 0f
+	call heap_init
+0:	call heap_alloc
+	test %eax, %eax
+	jnz 1f
+	pushl %ecx
+	call heap_collect
+	movl (%esp), %ecx
+	call heap_alloc
+	test %eax, %eax
+	jnz 2f
+	movl (%esp), %ecx   # the collector freed less than the block takes, it is allocated anyway
+	movl %ecx, heap_budget
+	call heap_alloc
+	test %eax, %eax
+	jz heap_exhausted
+2:	addl $4, %esp
+1:	movl (%eax), %ecx   # the size of the block, it may be larger than asked for
+	shrl $2, %ecx
+	decl %ecx
+	leal 4(%eax), %edi
+	movl %edi, %edx
+	xorl %eax, %eax
+	cld
+	rep stosl           # zero the length and the elements
+	movl %edx, %eax     # the array
+	movl 8(%esp), %ecx
+	movl %ecx, (%eax)   # its length
+	popl %edi
+	ret
+heap_init:              # reserve the mark bits and the heap, the pages are mapped once touched
+	pushl %ebx
+	pushl %ecx
+	pushl %esi
+	pushl %edi
+	pushl %ebp
+	movl $192, %eax     # mmap2 system call
+	xorl %ebx, %ebx     # at any address
+	movl ${{.Size}}+{{.Bits}}, %ecx
+	movl $3, %edx       # PROT_READ|PROT_WRITE
+	movl $0x4022, %esi  # MAP_PRIVATE|MAP_ANONYMOUS|MAP_NORESERVE
+	movl $-1, %edi      # no file
+	xorl %ebp, %ebp
+	int $0x80
+	cmpl $-4096, %eax   # an error code is in [-4095, -1]
+	ja heap_exhausted
+	movl %eax, heap_bits
+	addl ${{.Bits}}, %eax
+	movl %eax, heap_base
+	movl %eax, heap_top
+	movl ${{.Initial}}, heap_budget
+	addl ${{.Size}}, %eax
+	movl %eax, heap_end
+	popl %ebp
+	popl %edi
+	popl %esi
+	popl %ecx
+	popl %ebx
+	ret
+heap_alloc:             # a block of %ecx bytes in %eax: the first free block large enough, or one bumped from the top; 0 if the budget is spent or the heap is full
+	pushl %ebx
+	pushl %esi
+	xorl %eax, %eax
+	cmpl heap_budget, %ecx
+	ja 5f
+	movl $heap_free, %esi # the link to the block
+0:	movl (%esi), %eax
+	test %eax, %eax
+	jz 3f
+	movl (%eax), %ebx
+	subl %ecx, %ebx     # what is left of the free block
+	jae 1f
+	leal 4(%eax), %esi
+	jmp 0b
+1:	cmpl $8, %ebx       # a free block takes 8 bytes at least
+	jb 2f
+	leal (%eax,%ecx), %edx # the rest of the block stays free in its place in the list
+	movl %ebx, (%edx)
+	movl 4(%eax), %ebx
+	movl %ebx, 4(%edx)
+	movl %edx, (%esi)
+	movl %ecx, (%eax)
+	jmp 4f
+2:	movl 4(%eax), %ebx  # the whole block is taken
+	movl %ebx, (%esi)
+	jmp 4f
+3:	movl heap_end, %edx
+	subl heap_top, %edx # the room left at the top
+	cmpl %edx, %ecx
+	ja 5f
+	movl heap_top, %eax
+	addl %ecx, heap_top
+	movl %ecx, (%eax)
+4:	subl %ecx, heap_budget
+5:	popl %esi
+	popl %ebx
+	ret
+heap_collect:           # mark the blocks referenced by the stack, the others make the free list
+	pushl %ebx
+	pushl %esi
+	pushl %edi
+	pushl %ebp
+	movl heap_top, %ecx # clear the mark bits of the heap in use
+	subl heap_base, %ecx
+	shrl $8, %ecx       # 32 bits per word, 8 bytes per bit
+	incl %ecx
+	movl heap_bits, %edi
+	xorl %eax, %eax
+	cld
+	rep stosl
+	movl heap_bits, %edi
+	movl heap_base, %ebx
+	addl $4, %ebx       # a reference points to the second word of its block
+	movl heap_top, %edx
+	subl heap_base, %edx
+	movl %esp, %esi
+0:	cmpl wend_stack_base, %esi
+	jae 2f
+	movl (%esi), %eax
+	subl %ebx, %eax
+	cmpl %edx, %eax
+	jae 1f              # not in the heap
+	test $7, %eax
+	jnz 1f              # not the start of a block
+	shrl $3, %eax
+	btsl %eax, (%edi)
+1:	addl $4, %esi
+	jmp 0b
+2:	pushl $0            # the bytes of the live blocks
+	movl $0, heap_free
+	movl $heap_free, %ebx # the link of the last free block
+	xorl %ebp, %ebp     # the link to the last free block
+	xorl %edx, %edx     # the free block the dead blocks are merged into, 0 after a live block
+	movl heap_base, %esi
+3:	cmpl heap_top, %esi
+	jae 6f
+	movl (%esi), %ecx   # the size of the block
+	movl %esi, %eax
+	subl heap_base, %eax
+	shrl $3, %eax
+	btl %eax, (%edi)
+	jc 5f
+	test %edx, %edx
+	jz 4f
+	addl %ecx, (%edx)   # the dead block is merged into the previous free one
+	jmp 7f
+4:	movl %esi, %edx     # the dead block starts a free one
+	movl %ebx, %ebp
+	movl %esi, (%ebx)
+	movl $0, 4(%esi)
+	leal 4(%esi), %ebx
+	jmp 7f
+5:	xorl %edx, %edx
+	addl %ecx, (%esp)
+7:	addl %ecx, %esi
+	jmp 3b
+6:	test %edx, %edx     # the last free block is given back to the top
+	jz 8f
+	movl %edx, heap_top
+	movl $0, (%ebp)
+8:	popl %eax           # the next collection comes once the live bytes and some more are allocated
+	addl ${{.Initial}}, %eax
+	movl %eax, heap_budget
+	popl %ebp
+	popl %edi
+	popl %esi
+	popl %ebx
+	ret
+wend_len:               # the length of the array 4(%esp), 0 if there is none
+	movl 4(%esp), %eax
+	test %eax, %eax
+	jz 0f
+	movl (%eax), %eax
+0:	ret
+wend_bounds:            # the errors stop the program
+	movl $heap_bounds, %ecx
+	movl $heap_bounds_len, %edx
+	jmp 0f
+heap_negative_size:
+	movl $heap_negative, %ecx
+	movl $heap_negative_len, %edx
+	jmp 0f
+heap_exhausted:
+	movl $heap_memory, %ecx
+	movl $heap_memory_len, %edx
+0:	movl $4, %eax       # write system call
+	movl $2, %ebx       # stderr
+	int $0x80
+	movl $1, %eax       # _exit system call
+	movl $1, %ebx       # error code 1
+	int $0x80
+`,
+}
 
 func renderTemplate(templateName string, data any) string {
 	t := template.Must(template.New(templateName).Parse(Templates[templateName]))
@@ -142,17 +1190,45 @@ func templateFuncFactory(templateName string) func(map[string]any) string {
 }
 
 var TemplateFuns = map[string]func(map[string]any) string{
-	"ascii":           templateFuncFactory("ascii"),
-	"var":             templateFuncFactory("var"),
-	"print_linebreak": templateFuncFactory("print_linebreak"),
-	"print_int":       templateFuncFactory("print_int"),
-	"print_string":    templateFuncFactory("print_string"),
-	"print_bool":      templateFuncFactory("print_bool"),
-	"assign":          templateFuncFactory("assign"),
-	"ifthenelse":      templateFuncFactory("ifthenelse"),
-	"while":           templateFuncFactory("while"),
-	"funcall":         templateFuncFactory("funcall"),
-	"program":         templateFuncFactory("program"),
+	"ascii":            templateFuncFactory("ascii"),
+	"var":              templateFuncFactory("var"),
+	"var_long":         templateFuncFactory("var_long"),
+	"print_linebreak":  templateFuncFactory("print_linebreak"),
+	"print_int":        templateFuncFactory("print_int"),
+	"print_long":       templateFuncFactory("print_long"),
+	"print_float":      templateFuncFactory("print_float"),
+	"print_string":     templateFuncFactory("print_string"),
+	"print_bool":       templateFuncFactory("print_bool"),
+	"assign":           templateFuncFactory("assign"),
+	"assign_long":      templateFuncFactory("assign_long"),
+	"store":            templateFuncFactory("store"),
+	"index":            templateFuncFactory("index"),
+	"new":              templateFuncFactory("new"),
+	"ifthenelse":       templateFuncFactory("ifthenelse"),
+	"while":            templateFuncFactory("while"),
+	"switch":           templateFuncFactory("switch"),
+	"switch_table":     templateFuncFactory("switch_table"),
+	"switch_compare":   templateFuncFactory("switch_compare"),
+	"logic":            templateFuncFactory("logic"),
+	"funcall":          templateFuncFactory("funcall"),
+	"tailcall":         templateFuncFactory("tailcall"),
+	"funcall_indirect": templateFuncFactory("funcall_indirect"),
+	"closure":          templateFuncFactory("closure"),
+	"thunk":            templateFuncFactory("thunk"),
+	"funcall_import":   templateFuncFactory("funcall_import"),
+	"funcall_extern":   templateFuncFactory("funcall_extern"),
+	"zero_arrays":      templateFuncFactory("zero_arrays"),
+	"funcall_builtin":  templateFuncFactory("funcall_builtin"),
+	"program":          templateFuncFactory("program"),
+	"start":            templateFuncFactory("start"),
+	"start_libc":       templateFuncFactory("start_libc"),
+	"library":          templateFuncFactory("library"),
+	"data":             templateFuncFactory("data"),
+	"profile_wrapper":  templateFuncFactory("profile_wrapper"),
+	"coverage":         templateFuncFactory("coverage"),
+	"runtime_coverage": templateFuncFactory("runtime_coverage"),
+	"runtime_gfx":      templateFuncFactory("runtime_gfx"),
+	"runtime_heap":     templateFuncFactory("runtime_heap"),
 }
 
 func transasm(n Function) string {
@@ -160,20 +1236,51 @@ func transasm(n Function) string {
 	for label, strs := range n.deco["strings"].(map[string]string) {
 		strings += TemplateFuns["ascii"](map[string]any{"Label": label, "String": strs})
 	}
-	displaySize := n.deco["scopeCnt"].(int) * 4
-	offset := n.deco["scope"].(int) * 4
+	runtime := Templates["runtime"]
+	report := ""
+	if n.deco["profile"] == true {
+		runtime += Templates["runtime_profile"]
+		report = "\tcall profile_report\n"
+	}
+	if points, ok := n.deco["coverage"]; ok {
+		runtime += TemplateFuns["coverage"](map[string]any{"Points": points})
+		if file, ok := n.deco["coverfile"]; ok {
+			runtime += TemplateFuns["runtime_coverage"](map[string]any{"File": file})
+			report += "\tcall coverage_dump\n"
+		}
+	}
+	if n.deco["gfx"] == true {
+		runtime += TemplateFuns["runtime_gfx"](map[string]any{"Width": gfxWidth, "Height": gfxHeight, "Pixels": gfxWidth * gfxHeight,
+			"Size": gfxWidth * gfxHeight * 4, "Out": gfxWidth * gfxHeight * 30, "Seed": uint32(gfxSeed)})
+		report += "\tcall gfx_exit\n"
+	}
+	if n.deco["heap"] == true {
+		runtime += TemplateFuns["runtime_heap"](map[string]any{"Size": heapSize, "Bits": heapSize / 64,
+			"Initial": heapInitial, "Bounds": errBounds, "Negative": errNegative, "Memory": errMemory})
+	}
+	data := TemplateFuns["data"](map[string]any{"Strings": strings, "DisplaySize": n.deco["levelCnt"].(int) * 4})
+	if n.deco["library"] == true { // no entry point, the root has no code of its own
+		var functions string
+		for _, f := range n.fun {
+			functions += funasm(f)
+		}
+		return TemplateFuns["library"](map[string]any{"Data": data, "Functions": functions}) + runtime
+	}
+	offset := n.deco["level"].(int) * 4
 	main := n.deco["label"]
-	varsize := len(n.vars) * 4
+	varsize := n.deco["varCnt"].(int) * 4
+	start := "start"
+	if n.deco["libc"] == true { // the C runtime provides _start
+		start = "start_libc"
+	}
+	entry := TemplateFuns[start](map[string]any{"Offset": offset, "Varsize": varsize, "Main": main, "Report": report})
 	functions := funasm(n)
 	return TemplateFuns["program"](
 		map[string]any{
-			"Strings":     strings,
-			"DisplaySize": displaySize,
-			"Offset":      offset,
-			"Varsize":     varsize,
-			"Main":        main,
-			"Functions":   functions,
-		})
+			"Data":      data,
+			"Entry":     entry,
+			"Functions": functions,
+		}) + runtime
 }
 
 func funasm(n Function) string {
@@ -182,14 +1289,78 @@ func funasm(n Function) string {
 	for _, f := range n.fun {
 		nested += funasm(f)
 	}
-	body := ""
+	header := listasm(n.deco)
+	body := zeroArrays(n) + coverasm(n.deco, "cover")
 	for _, s := range n.body {
 		body += statasm(s)
 	}
-	return fmt.Sprintf("%s:\n%s\n\tret\n%s\n", label, body, nested)
+	thunk := ""
+	if n.deco["thunk"] == true {
+		thunk = thunkasm(n)
+	}
+	if global, ok := n.deco["global"]; ok { // exported function
+		thunk = fmt.Sprintf("\t.global %s\n%s:\n%s", global, global, thunk)
+	}
+	entry := fmt.Sprintf("%s:\n", label)
+	if name, ok := n.deco["profname"]; ok {
+		entry = TemplateFuns["profile_wrapper"](map[string]any{"Label": label, "Name": name})
+	}
+	return fmt.Sprintf("%s%s%s\n\tret\n%s%s\n", header, entry, body, thunk, nested)
+}
+
+// clear the local array variables read by the function, those of the inlined calls included
+func zeroArrays(n Function) string {
+	args := 0
+	for _, arg := range n.args {
+		args += arg.deco["type"].(Type).size()
+	}
+	slots := []int{}
+	visitExprs(n.body, func(e Expression) {
+		if v, ok := e.(Var); ok && v.deco["type"].(Type).isArray() && v.deco["scope"] == n.deco["scope"] && v.deco["offset"].(int) >= args {
+			slots = append(slots, v.deco["offset"].(int)*4)
+		}
+	})
+	if len(slots) == 0 {
+		return ""
+	}
+	slices.Sort(slots)
+	return TemplateFuns["zero_arrays"](map[string]any{"Level": n.deco["level"].(int) * 4, "Slots": slices.Compact(slots)})
+}
+
+// entry point for indirect calls: the caller has pushed the arguments, the thunk installs the captured
+// display entries, then builds the frame exactly as the funcall template does
+func thunkasm(n Function) string {
+	ancestors := n.deco["ancestors"].([]int)
+	save := []map[string]int{}
+	restore := []int{}
+	for i, level := range ancestors {
+		save = append(save, map[string]int{"Display": level * 4, "Record": (i + 1) * 4})
+		restore = append([]int{level * 4}, restore...)
+	}
+	argwords := []int{} // one element per 32-bit word of the arguments
+	for _, arg := range n.args {
+		for range arg.deco["type"].(Type).size() {
+			argwords = append(argwords, len(argwords))
+		}
+	}
+	varsize := n.deco["varCnt"].(int) * 4
+	return TemplateFuns["thunk"](map[string]any{
+		"Label":     n.deco["label"],
+		"Level":     n.deco["level"].(int) * 4,
+		"Ancestors": save,
+		"Restore":   restore,
+		"Args":      argwords,
+		"Argoffset": (len(argwords) + 1 + len(ancestors)) * 4,
+		"Varsize":   varsize,
+		"Disphead":  varsize + len(argwords)*4 - 4,
+	})
 }
 
 func statasm(n Statement) string {
+	return listasm(statDeco(n)) + coverasm(statDeco(n), "cover") + stmtasm(n)
+}
+
+func stmtasm(n Statement) string {
 	switch e := n.(type) {
 	case Print:
 		var newline string
@@ -199,6 +1370,10 @@ func statasm(n Statement) string {
 		switch e.expr.getDeco()["type"].(Type) {
 		case INT:
 			return TemplateFuns["print_int"](map[string]any{"Expr": exprasm(e.expr), "Newline": newline})
+		case LONG:
+			return TemplateFuns["print_long"](map[string]any{"Expr": exprasm(e.expr), "Newline": newline})
+		case FLOAT:
+			return TemplateFuns["print_float"](map[string]any{"Expr": exprasm(e.expr), "Newline": newline})
 		case BOOL:
 			return TemplateFuns["print_bool"](map[string]any{"Expr": exprasm(e.expr), "Newline": newline})
 		case STRING:
@@ -213,32 +1388,100 @@ func statasm(n Statement) string {
 			return "\tret\n"
 		}
 	case Assign:
-		return TemplateFuns["assign"](map[string]any{"Expression": exprasm(e.expr), "Scope": e.deco["scope"].(int) * 4, "Variable": e.deco["offset"].(int) * 4})
+		if e.deco["type"].(Type).size() == 2 {
+			return TemplateFuns["assign_long"](map[string]any{"Expression": exprasm(e.expr), "Level": e.deco["level"].(int) * 4, "Variable": e.deco["offset"].(int) * 4, "Low": e.deco["offset"].(int)*4 + 4, "Name": listname(e.deco)})
+		}
+		return TemplateFuns["assign"](map[string]any{"Expression": exprasm(e.expr), "Level": e.deco["level"].(int) * 4, "Variable": e.deco["offset"].(int) * 4, "Name": listname(e.deco)})
+	case Store:
+		return TemplateFuns["store"](map[string]any{"Array": exprasm(e.array), "Index": exprasm(e.index),
+			"Expression": exprasm(e.expr), "Long": e.deco["type"].(Type).size() == 2})
 	case FunCall:
 		return exprasm(e)
-	case While:
-		var body string
+	case Inline:
+		return exprasm(e)
+	case While: // the code is generated in the order of the output, the listing follows it
+		condition := exprasm(e.expr)
+		body := coverasm(e.deco, "cover_body")
 		for _, s := range e.body {
 			body += statasm(s)
 		}
-		return TemplateFuns["while"](map[string]any{"Condition": exprasm(e.expr), "Label1": newLabel(), "Label2": newLabel(), "Body": body})
+		return TemplateFuns["while"](map[string]any{"Condition": condition, "Label1": newLabel(), "Label2": newLabel(), "Body": body, "Exit": coverasm(e.deco, "cover_exit")})
 	case IfThenElse:
-		var ibody string
+		condition := exprasm(e.expr)
+		ibody := coverasm(e.deco, "cover_then")
 		for _, s := range e.ibody {
 			ibody += statasm(s)
 		}
-		var ebody string
+		ebody := coverasm(e.deco, "cover_else")
 		for _, s := range e.ebody {
 			ebody += statasm(s)
 		}
-		return TemplateFuns["ifthenelse"](map[string]any{"Condition": exprasm(e.expr), "Label1": newLabel() + "if1", "Label2": newLabel() + "if2", "Ibody": ibody, "Ebody": ebody})
+		return TemplateFuns["ifthenelse"](map[string]any{"Condition": condition, "Label1": newLabel() + "if1", "Label2": newLabel() + "if2", "Ibody": ibody, "Ebody": ebody})
+	case Switch:
+		return switchasm(e)
 	default:
 		panic(fmt.Sprint("Unknown statement type", e))
 	}
 }
 
+// a switch over at least jumpTableMin values filling at least half of their range jumps through a table, a sparse
+// one compares the value to every case in turn
+const jumpTableMin = 4
+
+func switchasm(e Switch) string {
+	expression := exprasm(e.expr)
+	end := newLabel() + "end"
+	cases := []map[string]any{}
+	targets := map[int]string{} // value -> label of its case
+	values := []int{}
+	dflt := end
+	for _, c := range e.cases {
+		label := newLabel() + "case"
+		body := listasm(c.deco) + coverasm(c.deco, "cover_case")
+		for _, s := range c.body {
+			body += statasm(s)
+		}
+		cases = append(cases, map[string]any{"Label": label, "Body": body})
+		if len(c.values) == 0 {
+			dflt = label
+		}
+		for _, v := range c.values {
+			targets[v.(Integer).value] = label
+			values = append(values, v.(Integer).value)
+		}
+	}
+	if none := coverasm(e.deco, "cover_none"); none != "" && dflt == end { // count the values matching no case
+		dflt = newLabel() + "none"
+		cases = append(cases, map[string]any{"Label": dflt, "Body": none})
+	}
+	slices.Sort(values)
+	var dispatch string
+	if n := len(values); n >= jumpTableMin && values[n-1]-values[0] < 2*n {
+		table := []string{}
+		for v := values[0]; v <= values[n-1]; v++ {
+			if label, ok := targets[v]; ok {
+				table = append(table, label)
+			} else {
+				table = append(table, dflt)
+			}
+		}
+		dispatch = TemplateFuns["switch_table"](map[string]any{"Min": values[0], "Span": values[n-1] - values[0],
+			"Default": dflt, "Table": newLabel() + "table", "Targets": table})
+	} else {
+		tests := []map[string]any{}
+		for _, c := range e.cases {
+			for _, v := range c.values {
+				tests = append(tests, map[string]any{"Value": v.(Integer).value, "Label": targets[v.(Integer).value]})
+			}
+		}
+		dispatch = TemplateFuns["switch_compare"](map[string]any{"Tests": tests, "Default": dflt})
+	}
+	return TemplateFuns["switch"](map[string]any{"Expression": expression, "Dispatch": dispatch, "Cases": cases, "End": end})
+}
+
 func exprasm(n Expression) string {
-	pyeq1 := map[string]string{"+": "addl", "-": "subl", "*": "imull", "||": "orl", "&&": "andl"}
+	pyeq1 := map[string]string{"+": "addl", "-": "subl", "*": "imull", "||": "orl", "&&": "andl", "&": "andl", "|": "orl", "^": "xorl"}
+	shifts := map[string]string{"<<": "sall", ">>": "sarl", ">>>": "shrl"}
 	pyeq2 := map[string]string{"<=": "jle", "<": "jl", ">=": "jge", ">": "jg", "==": "je", "!=": "jne"}
 	isContain := func(eop string, pyeq map[string]string) bool {
 		_, ok := pyeq[eop]
@@ -246,11 +1489,19 @@ func exprasm(n Expression) string {
 	}
 	switch e := n.(type) {
 	case ArithOp:
+		if e.deco["type"] == LONG {
+			return longasm(e.op, e.left, e.right)
+		}
+		if e.deco["type"] == FLOAT {
+			return floatasm(e.op, e.left, e.right)
+		}
 		args := exprasm(e.left) + "\tpushl %eax\n" + exprasm(e.right) + "\tmovl %eax, %ebx\n\tpopl %eax\n"
 		if isContain(e.op, pyeq1) {
 			return args + fmt.Sprintf("\t%s %%ebx, %%eax\n", pyeq1[e.op])
 		} else if isContain(e.op, pyeq2) {
 			return args + fmt.Sprintf("\tcmp %%ebx, %%eax\n\tmovl $1, %%eax\n\t%s 1f\n\txorl %%eax, %%eax\n1:\n", pyeq2[e.op])
+		} else if isContain(e.op, shifts) {
+			return args + fmt.Sprintf("\tmovl %%ebx, %%ecx\n\t%s %%cl, %%eax\n", shifts[e.op])
 		} else if e.op == "/" {
 			return args + "\tcdq\n\tidivl %ebx, %eax\n"
 		} else if e.op == "%" {
@@ -258,6 +1509,16 @@ func exprasm(n Expression) string {
 		}
 		panic("Unknown binary operation")
 	case LogicOp:
+		if e.op == "&&" || e.op == "||" { // short-circuit: the right operand is evaluated only if the left one does not decide
+			jump := map[string]string{"&&": "jz", "||": "jnz"}[e.op]
+			return TemplateFuns["logic"](map[string]any{"Left": exprasm(e.left), "Right": exprasm(e.right), "Jump": jump, "Label": newLabel() + "sc"})
+		}
+		if e.left.getDeco()["type"] == LONG {
+			return longasm(e.op, e.left, e.right)
+		}
+		if e.left.getDeco()["type"] == FLOAT {
+			return floatasm(e.op, e.left, e.right)
+		}
 		args := exprasm(e.left) + "\tpushl %eax\n" + exprasm(e.right) + "\tmovl %eax, %ebx\n\tpopl %eax\n"
 		if isContain(e.op, pyeq1) {
 			return args + fmt.Sprintf("\t%s %%ebx, %%eax\n", pyeq1[e.op])
@@ -270,7 +1531,13 @@ func exprasm(n Expression) string {
 		}
 		panic("Unknown binary operation")
 	case Integer:
+		if e.deco["type"] == LONG {
+			return fmt.Sprintf("\tmovl $%d, %%eax\n\tmovl $%d, %%edx\n", int32(e.value), int32(e.value>>32))
+		}
 		return fmt.Sprintf("\tmovl $%d, %%eax\n", e.value)
+	case Float: // the bit pattern of the double
+		bits := math.Float64bits(e.value)
+		return fmt.Sprintf("\tmovl $%d, %%eax\n\tmovl $%d, %%edx\n", int32(bits), int32(bits>>32))
 	case Boolean:
 		var value int
 		if e.value {
@@ -280,18 +1547,188 @@ func exprasm(n Expression) string {
 		}
 		return fmt.Sprintf("\tmovl $%d, %%eax\n", value)
 	case Var:
-		return TemplateFuns["var"](map[string]any{"Scope": e.deco["scope"].(int) * 4, "Variable": e.deco["offset"].(int) * 4})
+		if e.deco["type"].(Type).size() == 2 {
+			return TemplateFuns["var_long"](map[string]any{"Level": e.deco["level"].(int) * 4, "Variable": e.deco["offset"].(int) * 4, "Low": e.deco["offset"].(int)*4 + 4, "Name": listname(e.deco)})
+		}
+		return TemplateFuns["var"](map[string]any{"Level": e.deco["level"].(int) * 4, "Variable": e.deco["offset"].(int) * 4, "Name": listname(e.deco)})
+	case Inline:
+		var body string
+		for _, s := range e.body {
+			body += statasm(s)
+		}
+		if e.expr != nil {
+			body += exprasm(e.expr)
+		}
+		return body
+	case Convert:
+		return exprasm(e.expr) + convertasm(e.expr.getDeco()["type"].(Type), e.deco["type"].(Type))
+	case New:
+		return TemplateFuns["new"](map[string]any{"Size": exprasm(e.size), "Elemsize": elemSize(e.deco["type"].(Type))})
+	case Index:
+		return TemplateFuns["index"](map[string]any{"Array": exprasm(e.array), "Index": exprasm(e.index),
+			"Long": e.deco["type"].(Type).size() == 2})
+	case FunRef:
+		fundeco := e.deco["fundeco"].(map[string]any)
+		ancestors := fundeco["ancestors"].([]int)
+		entry := e.deco["slot"].(int) + len(ancestors) // the record grows upwards from its first word
+		capture := []map[string]int{}
+		for i, level := range ancestors {
+			capture = append(capture, map[string]int{"Display": level * 4, "Slot": (entry - 1 - i) * 4})
+		}
+		thunk := fundeco["label"].(string) + "_thunk"
+		if fundeco["import"] == true {
+			thunk = fundeco["label"].(string)
+		}
+		return TemplateFuns["closure"](map[string]any{"Level": e.deco["level"].(int) * 4, "Thunk": thunk, "Entry": entry * 4, "Ancestors": capture})
 	case FunCall:
+		if e.deco["extern"] == true {
+			return externasm(e)
+		}
 		var allocargs string
+		argsize := 0
 		for _, arg := range e.args {
-			allocargs += fmt.Sprintf("%s\tpushl %%eax\n", exprasm(arg))
+			allocargs += exprasm(arg) + pushasm(arg.getDeco()["type"].(Type))
+			argsize += arg.getDeco()["type"].(Type).size() * 4
+		}
+		if e.deco["indirect"] == true {
+			return TemplateFuns["funcall_indirect"](map[string]any{"Allocargs": allocargs, "Closure": exprasm(e.deco["closure"].(Var)), "Argsize": argsize})
+		}
+		if _, ok := e.deco["tail"]; ok {
+			return tailcallasm(e, allocargs, argsize/4)
 		}
-		varsize := e.deco["varCnt"].(int) * 4
-		disphead := varsize + len(e.args)*4 - 4
-		scope := e.deco["scope"].(int) * 4
+		if e.deco["builtin"] == true {
+			return TemplateFuns["funcall_builtin"](map[string]any{"Allocargs": allocargs, "Funlabel": e.deco["label"], "Argsize": argsize})
+		}
+		if e.deco["import"] == true {
+			return TemplateFuns["funcall_import"](map[string]any{"Allocargs": allocargs, "Funlabel": e.deco["label"], "Argsize": argsize})
+		}
+		varsize := e.deco["fundeco"].(map[string]any)["varCnt"].(int) * 4
+		disphead := varsize + argsize - 4
+		level := e.deco["level"].(int) * 4
 		funlabel := e.deco["label"].(string)
-		return TemplateFuns["funcall"](map[string]any{"Scope": scope, "Allocargs": allocargs, "Varsize": varsize, "Disphead": disphead, "Funlabel": funlabel})
+		return TemplateFuns["funcall"](map[string]any{"Level": level, "Allocargs": allocargs, "Varsize": varsize, "Disphead": disphead, "Funlabel": funlabel})
 	default:
 		panic(fmt.Sprint("Unknown expression type", e))
 	}
 }
+
+// The arguments pushed to the stack are moved to the head of the current frame. The temporaries lie below
+// the return address, so the copy starting from the first word (the highest address) never overwrites a word
+// not yet copied. Then the return address is moved below the callee's frame, and the callee is entered by a jump.
+func tailcallasm(e FunCall, allocargs string, argwords int) string {
+	caller := e.deco["tail"].(map[string]any)
+	callee := e.deco["fundeco"].(map[string]any)
+	callerArgwords := 0
+	for _, t := range caller["argtypes"].([]Type) {
+		callerArgwords += t.size()
+	}
+	moves := []map[string]int{}
+	for j := range argwords {
+		moves = append(moves, map[string]int{"Temp": (argwords - 1 - j) * 4, "Slot": j * 4})
+	}
+	return TemplateFuns["tailcall"](map[string]any{
+		"Allocargs": allocargs,
+		"Level":     e.deco["level"].(int) * 4,
+		"Retaddr":   (caller["varCnt"].(int) + callerArgwords) * 4,
+		"Moves":     moves,
+		"Frame":     (callee["varCnt"].(int) + argwords) * 4,
+		"Funlabel":  e.deco["label"],
+	})
+}
+
+// cdecl call of a C function: the arguments are evaluated left to right into the space reserved below the saved %esp,
+// the first one at the lowest address
+func externasm(e FunCall) string {
+	var allocargs string
+	argsize := 0
+	for _, arg := range e.args {
+		allocargs += exprasm(arg) + fmt.Sprintf("\tmovl %%eax, %d(%%esp)\n", argsize)
+		if arg.getDeco()["type"].(Type).size() == 2 {
+			allocargs += fmt.Sprintf("\tmovl %%edx, %d(%%esp)\n", argsize+4)
+		}
+		argsize += arg.getDeco()["type"].(Type).size() * 4
+	}
+	var result string
+	if e.deco["type"] == FLOAT { // a double is returned on the FPU stack
+		result = "\tsubl $8, %esp\n\tfstpl (%esp)\n\tpopl %eax\n\tpopl %edx\n"
+	}
+	return TemplateFuns["funcall_extern"](map[string]any{"Allocargs": allocargs, "Argsize": argsize, "Funlabel": e.deco["label"], "Result": result})
+}
+
+// push the value of %eax (or %edx:%eax for long) to the stack
+func pushasm(t Type) string {
+	if t.size() == 2 {
+		return "\tpushl %edx\n\tpushl %eax\n"
+	}
+	return "\tpushl %eax\n"
+}
+
+// 64-bit operations: the left operand is in %edx:%eax, the right one in %ecx:%ebx
+func longasm(op string, left, right Expression) string {
+	args := exprasm(left) + "\tpushl %edx\n\tpushl %eax\n" + exprasm(right) + "\tmovl %eax, %ebx\n\tmovl %edx, %ecx\n\tpopl %eax\n\tpopl %edx\n"
+	bitwise := map[string]string{"&": "andl", "|": "orl", "^": "xorl"}
+	compare := map[string]string{"<": "jl", ">=": "jge", ">": "jl", "<=": "jge"}
+	switch op {
+	case "+":
+		return args + "\taddl %ebx, %eax\n\tadcl %ecx, %edx\n"
+	case "-":
+		return args + "\tsubl %ebx, %eax\n\tsbbl %ecx, %edx\n"
+	case "*": // the low 64 bits of the product: al*bl + ((ah*bl + al*bh) << 32)
+		return args + "\timull %ebx, %edx\n\timull %eax, %ecx\n\taddl %edx, %ecx\n\tmull %ebx\n\taddl %ecx, %edx\n"
+	case "/":
+		return args + "\tpushl %ecx\n\tpushl %ebx\n\tpushl %edx\n\tpushl %eax\n\tcall div_int64\n\taddl $16, %esp\n"
+	case "%":
+		return args + "\tpushl %ecx\n\tpushl %ebx\n\tpushl %edx\n\tpushl %eax\n\tcall div_int64\n\taddl $16, %esp\n\tmovl %ebx, %eax\n\tmovl %ecx, %edx\n"
+	case "&", "|", "^":
+		return args + fmt.Sprintf("\t%s %%ebx, %%eax\n\t%s %%ecx, %%edx\n", bitwise[op], bitwise[op])
+	case "<<":
+		return args + "\tmovl %ebx, %ecx\n\tshldl %cl, %eax, %edx\n\tsall %cl, %eax\n\ttestb $32, %cl\n\tjz 1f\n\tmovl %eax, %edx\n\txorl %eax, %eax\n1:\n"
+	case ">>":
+		return args + "\tmovl %ebx, %ecx\n\tshrdl %cl, %edx, %eax\n\tsarl %cl, %edx\n\ttestb $32, %cl\n\tjz 1f\n\tmovl %edx, %eax\n\tsarl $31, %edx\n1:\n"
+	case ">>>":
+		return args + "\tmovl %ebx, %ecx\n\tshrdl %cl, %edx, %eax\n\tshrl %cl, %edx\n\ttestb $32, %cl\n\tjz 1f\n\tmovl %edx, %eax\n\txorl %edx, %edx\n1:\n"
+	case "==", "!=": // compare both halves at once
+		jump := map[string]string{"==": "je", "!=": "jne"}[op]
+		return args + fmt.Sprintf("\txorl %%ebx, %%eax\n\txorl %%ecx, %%edx\n\torl %%edx, %%eax\n\tmovl $1, %%eax\n\t%s 1f\n\txorl %%eax, %%eax\n1:\n", jump)
+	case "<", ">=": // the flags of the 64-bit subtraction give the signed comparison
+		return args + fmt.Sprintf("\tsubl %%ebx, %%eax\n\tsbbl %%ecx, %%edx\n\tmovl $1, %%eax\n\t%s 1f\n\txorl %%eax, %%eax\n1:\n", compare[op])
+	case ">", "<=": // same with swapped operands
+		return args + fmt.Sprintf("\tsubl %%eax, %%ebx\n\tsbbl %%edx, %%ecx\n\tmovl $1, %%eax\n\t%s 1f\n\txorl %%eax, %%eax\n1:\n", compare[op])
+	}
+	panic("Unknown binary operation")
+}
+
+// x87 operations on doubles: both operands are stored on the stack, the left one above the right one
+func floatasm(op string, left, right Expression) string {
+	args := exprasm(left) + "\tpushl %edx\n\tpushl %eax\n" + exprasm(right) + "\tpushl %edx\n\tpushl %eax\n"
+	arith := map[string]string{"+": "faddl", "-": "fsubl", "*": "fmull", "/": "fdivl"}
+	compare := map[string]string{"<": "jb", "<=": "jbe", ">": "ja", ">=": "jae", "==": "je", "!=": "jne"}
+	if instr, ok := arith[op]; ok {
+		return args + fmt.Sprintf("\tfldl 8(%%esp)\n\t%s (%%esp)\n\taddl $8, %%esp\n\tfstpl (%%esp)\n\tpopl %%eax\n\tpopl %%edx\n", instr)
+	}
+	if jump, ok := compare[op]; ok { // the FPU status word is moved to the flags, they read as an unsigned comparison
+		return args + fmt.Sprintf("\tfldl (%%esp)\n\tfldl 8(%%esp)\n\taddl $16, %%esp\n\tfucompp\n\tfnstsw %%ax\n\tsahf\n\tmovl $1, %%eax\n\t%s 1f\n\txorl %%eax, %%eax\n1:\n", jump)
+	}
+	panic("Unknown binary operation")
+}
+
+// convert the value in %eax (or %edx:%eax) between the numeric types
+func convertasm(from, to Type) string {
+	switch {
+	case from == to:
+		return ""
+	case from == INT && to == LONG:
+		return "\tcdq\n"
+	case from == LONG && to == INT: // keep the low half
+		return ""
+	case from == INT && to == FLOAT:
+		return "\tpushl %eax\n\tfildl (%esp)\n\tsubl $4, %esp\n\tfstpl (%esp)\n\tpopl %eax\n\tpopl %edx\n"
+	case from == LONG && to == FLOAT:
+		return "\tpushl %edx\n\tpushl %eax\n\tfildll (%esp)\n\tfstpl (%esp)\n\tpopl %eax\n\tpopl %edx\n"
+	case from == FLOAT && to == INT: // saturated to 2^31 - 1, the low half is kept
+		return "\tpushl $0x41e00000\n\tpushl $0\n\tpushl %edx\n\tpushl %eax\n\tcall float_trunc\n\taddl $16, %esp\n"
+	case from == FLOAT && to == LONG:
+		return "\tpushl $0x43e00000\n\tpushl $0\n\tpushl %edx\n\tpushl %eax\n\tcall float_trunc\n\taddl $16, %esp\n"
+	}
+	panic(fmt.Sprintf("Invalid conversion from %s to %s", from, to))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var (
+	rootpath string
+)
+
+func init() {
+	_, exepath, _, _ := runtime.Caller(0)
+	rootpath = filepath.Dir(filepath.Dir(exepath))
+}
+
+func writeSource(t *testing.T, dir, name, source string) string {
+	path := filepath.Join(dir, name+".wend")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// the error reported by the analysis of the source, nil if it is accepted
+func analyzeError(source string) (err error) {
+	defer catch(&err, nil)
+	buildSymtable((&WendParser{}).Parse(tokenize(source)).(Function))
+	return nil
+}
+
+// compile wend source into an i386 executable placed in dir
+func buildExe(t *testing.T, dir, name, source string) string {
+	exename, _, err := build(writeSource(t, dir, name, source), dir, buildOptions{})
+	if err != nil {
+		t.Fatalf("build failed for %s: %s", name, err)
+	}
+	return exename
+}
+
+func TestTransasm(t *testing.T) {
+	if _, err := exec.LookPath("as"); err != nil {
+		t.Skip("GNU as is not available")
+	}
+
+	expectedFiles, _ := filepath.Glob(filepath.Join(rootpath, "test-programs", "*", "*.expected"))
+	for _, expectedFile := range expectedFiles {
+		base := strings.TrimSuffix(filepath.Base(expectedFile), ".expected")
+		t.Run(base, func(t *testing.T) {
+			expected, err := os.ReadFile(expectedFile)
+			if err != nil {
+				t.Fatalf("Error read expected file: %s\n", err)
+			}
+
+			exename, _, err := build(strings.TrimSuffix(expectedFile, ".expected")+".wend", t.TempDir(), buildOptions{})
+			if err != nil {
+				t.Fatalf("build failed for %s: %s", base, err)
+			}
+			var out bytes.Buffer
+			cmd := exec.Command(exename)
+			cmd.Stdout = &out
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("fail to exec program %s: %s\n", base, err)
+			}
+			if out.String() != string(expected) {
+				t.Errorf("expected: %s, got: %s\n", expected, out.String())
+			}
+		})
+	}
+}
+
+// extern functions are called with the C convention, the C side is compiled without the C library
+func TestExtern(t *testing.T) {
+	if _, err := exec.LookPath("as"); err != nil {
+		t.Skip("GNU as is not available")
+	}
+	dir := t.TempDir()
+	csource := `
+int add3(int a, int b, int c) { return a*100 + b*10 + c; }
+long long mul64(long long a, int b) { return a * b; }
+double hypot2(double x, double y) { return x*x + y*y; }
+int negate(int b) { return !b; }
+int aligned(void) { return ((unsigned)__builtin_frame_address(0) & 15) == 8; }
+`
+	cname := filepath.Join(dir, "c.c")
+	if err := os.WriteFile(cname, []byte(csource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("cc", "-m32", "-c", "-O0", "-fno-omit-frame-pointer", "-ffreestanding", "-fno-pic", "-o", filepath.Join(dir, "c.o"), cname).CombinedOutput(); err != nil {
+		t.Skipf("no 32-bit C compiler: %s\n%s", err, out)
+	}
+
+	source := `
+extern int add3(int, int, int);
+extern long mul64(long, int);
+extern float hypot2(float, float);
+extern bool negate(bool);
+extern int aligned();
+
+main() {
+    int add3(int a, int b) { // overloads are resolved as usual
+        return a + b;
+    }
+
+    println add3(1, 2, 3);
+    println add3(1, 2);
+    println mul64(3000000000L, -3);
+    println hypot2(1.5, 2.0);
+    println negate(false);
+    println aligned() == 1;
+    println add3(add3(0, 0, 1), aligned(), add3(4, 5));
+}
+`
+	path := filepath.Join(dir, "extern.wend")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exename, _, err := build(path, dir, buildOptions{objects: []string{filepath.Join(dir, "c.o")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(exename).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "123\n3\n-9000000000\n6.250000\ntrue\ntrue\n119\n"
+	if string(out) != expected {
+		t.Errorf("expected: %s, got: %s\n", expected, out)
+	}
+}
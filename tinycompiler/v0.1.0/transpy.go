@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The Python backends. transpy produces a readable program: every wend function is a Python function nested like
+// in the source, the variables are Python variables. transnovars produces a program without variables, it mimics the
+// assembly with two registers, a stack and a display. Python integers are unbounded, so the runtime shared by both
+// wraps the results to 32 or 64 bits, truncates the division toward zero like idiv, and prints the values like the
+// runtime of the compiled programs. Every module becomes a Python module named after it; the exported functions are
+// imported by their global label.
+const pyRuntime = `import sys
+sys.setrecursionlimit(1 << 24)
+
+def wend_int(x):
+	return (x + 0x80000000) % 0x100000000 - 0x80000000
+
+def wend_long(x):
+	return (x + 0x8000000000000000) % 0x10000000000000000 - 0x8000000000000000
+
+def wend_div(a, b):
+	q = abs(a) // abs(b)
+	return q if (a < 0) == (b < 0) else -q
+
+def wend_mod(a, b):
+	return a - b * wend_div(a, b)
+
+def wend_fdiv(a, b): # the FPU gives an infinity or NaN, not an error
+	if b == 0.0:
+		if a != a or a == 0.0:
+			return float("nan")
+		return float("inf") if (a < 0) == str(b).startswith("-") else float("-inf")
+	return a / b
+
+def wend_trunc(x, bits): # saturated to the range of the integer type, NaN is 0
+	if x != x:
+		return 0
+	if x >= 2.0**(bits-1):
+		return (1 << (bits-1)) - 1
+	if x <= -2.0**(bits-1) - 1:
+		return -(1 << (bits-1))
+	return int(x)
+
+def wend_print(x, end):
+	if isinstance(x, bool):
+		x = "true" if x else "false"
+	elif isinstance(x, float): # |x| rounded to 6 decimals, then the sign bit; from 10^12 on with an exponent
+		sign = "-" if str(x).startswith("-") else ""
+		if x != x:
+			x = "nan"
+		elif abs(x) == float("inf"):
+			x = sign + "inf"
+		elif abs(x) >= 1e12:
+			x = sign + "%.6e" % abs(x)
+		else:
+			n = int(abs(x)) * 1000000 + round((abs(x) - int(abs(x))) * 1000000)
+			x = "%s%d.%06d" % (sign, n // 1000000, n % 1000000)
+	sys.stdout.write(str(x) + end)
+
+`
+
+var pyKeywords = map[string]bool{"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true,
+	"async": true, "await": true, "break": true, "class": true, "continue": true, "def": true, "del": true, "elif": true,
+	"else": true, "except": true, "finally": true, "for": true, "from": true, "global": true, "if": true, "import": true,
+	"in": true, "is": true, "lambda": true, "nonlocal": true, "not": true, "or": true, "pass": true, "raise": true,
+	"return": true, "try": true, "while": true, "with": true, "yield": true}
+
+// a wend identifier that cannot be used as is in Python gets an underscore
+func pyName(name string) string {
+	if pyKeywords[name] || strings.HasPrefix(name, "wend_") {
+		return name + "_"
+	}
+	return name
+}
+
+func pyLiteral(n Expression) string {
+	switch e := n.(type) {
+	case Integer:
+		if e.deco["type"] == LONG {
+			return fmt.Sprint(int64(e.value))
+		}
+		return fmt.Sprint(int32(e.value))
+	case Float:
+		s := strconv.FormatFloat(e.value, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case Boolean:
+		if e.value {
+			return "True"
+		}
+		return "False"
+	case String:
+		return "\"" + e.value + "\"" // the escapes are the same as in GNU as
+	}
+	panic(fmt.Sprint("Unknown literal type", n))
+}
+
+// the Python expression of a binary operation over the operands a and b of type t
+func pyBinary(op string, t Type, a, b string) string {
+	wrap := "wend_int"
+	bits := 32
+	if t == LONG {
+		wrap, bits = "wend_long", 64
+	}
+	switch op {
+	case "+", "-", "*":
+		if t == FLOAT {
+			return fmt.Sprintf("(%s %s %s)", a, op, b)
+		}
+		return fmt.Sprintf("%s(%s %s %s)", wrap, a, op, b)
+	case "/":
+		if t == FLOAT {
+			return fmt.Sprintf("wend_fdiv(%s, %s)", a, b)
+		}
+		return fmt.Sprintf("%s(wend_div(%s, %s))", wrap, a, b)
+	case "%":
+		return fmt.Sprintf("wend_mod(%s, %s)", a, b)
+	case "&", "|", "^", "<", "<=", ">", ">=", "==", "!=":
+		return fmt.Sprintf("(%s %s %s)", a, op, b)
+	case "&&":
+		return fmt.Sprintf("(%s and %s)", a, b)
+	case "||":
+		return fmt.Sprintf("(%s or %s)", a, b)
+	case "<<": // the count is masked like the i386 does
+		return fmt.Sprintf("%s(%s << (%s & %d))", wrap, a, b, bits-1)
+	case ">>":
+		return fmt.Sprintf("(%s >> (%s & %d))", a, b, bits-1)
+	case ">>>":
+		return fmt.Sprintf("%s((%s & 0x%x) >> (%s & %d))", wrap, a, uint64(1)<<bits-1, b, bits-1)
+	}
+	panic("Unknown binary operation")
+}
+
+func pyConvert(from, to Type, x string) string {
+	switch {
+	case from == to, from == INT && to == LONG:
+		return x
+	case from == LONG && to == INT:
+		return fmt.Sprintf("wend_int(%s)", x)
+	case to == FLOAT:
+		return fmt.Sprintf("float(%s)", x)
+	case from == FLOAT && to == INT:
+		return fmt.Sprintf("wend_trunc(%s, 32)", x)
+	case from == FLOAT && to == LONG:
+		return fmt.Sprintf("wend_trunc(%s, 64)", x)
+	}
+	panic(fmt.Sprintf("Invalid conversion from %s to %s", from, to))
+}
+
+func pyNewline(newline bool) string {
+	if newline {
+		return `"\n"`
+	}
+	return `""`
+}
+
+// the imports of the functions exported by other modules
+func pyImports(root Function) string {
+	modules := map[string][]string{}
+	imported, _ := root.deco["imported"].([]Function)
+	for _, f := range imported {
+		module := f.deco["module"].(string)
+		modules[module] = append(modules[module], f.deco["label"].(string))
+	}
+	names := []string{}
+	for module := range modules {
+		names = append(names, module)
+	}
+	sort.Strings(names)
+	var str string
+	for _, module := range names {
+		str += fmt.Sprintf("from %s import %s\n", module, strings.Join(modules[module], ", "))
+	}
+	if len(str) > 0 {
+		str += "\n"
+	}
+	return str
+}
+
+func pyExtern(e FunCall) {
+	panic(fmt.Sprintf("The Python backends cannot call the extern function %s, line %d", e.name, e.deco["lineno"]))
+}
+
+// the runtime of the builtins, if the module calls them
+func pyBuiltins(root Function) string {
+	str := ""
+	if root.deco["heap"] == true {
+		str += pyHeap()
+	}
+	if root.deco["gfx"] == true {
+		str += pyGfx()
+	}
+	return str
+}
+
+func pyGfxExit(root Function) string {
+	if root.deco["gfx"] == true {
+		return "wend_gfx_exit()\n"
+	}
+	return ""
+}
+
+func transpy(root Function) string {
+	str := pyRuntime + pyBuiltins(root) + pyImports(root)
+	if root.deco["library"] != true {
+		return str + funpy(root) + fmt.Sprintf("\n%s()\n", root.deco["label"]) + pyGfxExit(root)
+	}
+	for _, f := range root.fun {
+		str += funpy(f) + "\n"
+	}
+	for _, f := range root.fun {
+		if global, ok := f.deco["global"]; ok {
+			str += fmt.Sprintf("%s = %s\n", global, f.deco["label"])
+		}
+	}
+	return str
+}
+
+func funpy(n Function) string {
+	args := []string{}
+	for _, arg := range n.args {
+		args = append(args, pyName(arg.name))
+	}
+	lines := []string{}
+	nonlocals := map[string]bool{} // the variables of the enclosing functions assigned in this one
+	visitStats(n.body, func(s Statement) {
+		if e, ok := s.(Assign); ok && e.deco["scope"] != n.deco["scope"] {
+			nonlocals[pyName(e.name)] = true
+		}
+	})
+	if len(nonlocals) > 0 {
+		names := []string{}
+		for name := range nonlocals {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		lines = append(lines, "nonlocal "+strings.Join(names, ", ")+"\n")
+	}
+	for _, v := range n.vars {
+		lines = append(lines, fmt.Sprintf("%s = %s\n", pyName(v.name), pyZero(v.deco["type"].(Type))))
+	}
+	for _, f := range n.fun {
+		lines = append(lines, funpy(f))
+	}
+	for _, s := range n.body {
+		lines = append(lines, stat(s))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "pass\n")
+	}
+	return fmt.Sprintf("def %s(%s):\n", n.deco["label"], strings.Join(args, ", ")) + indent(lines)
+}
+
+// the initial value of a variable of the type
+func pyZero(t Type) string {
+	switch {
+	case t == FLOAT:
+		return "0.0"
+	case t == BOOL:
+		return "False"
+	case t.isFun(), t.isArray():
+		return "None"
+	}
+	return "0"
+}
+
+func stat(n Statement) string {
+	block := func(ss []Statement) string {
+		body := []string{}
+		for _, s := range ss {
+			body = append(body, stat(s))
+		}
+		if len(body) == 0 {
+			body = append(body, "pass\n")
+		}
+		return indent(body)
+	}
+
+	switch e := n.(type) {
+	case Print:
+		return fmt.Sprintf("wend_print(%s, %s)\n", expr(e.expr), pyNewline(e.newline))
+	case Return:
+		if e.expr != nil {
+			return fmt.Sprintf("return %s\n", expr(e.expr))
+		}
+		return "return\n"
+	case Assign:
+		return fmt.Sprintf("%s = %s\n", pyName(e.name), expr(e.expr))
+	case Store:
+		return fmt.Sprintf("wend_set(%s, %s, %s)\n", expr(e.array), expr(e.index), expr(e.expr))
+	case FunCall:
+		return expr(e) + "\n"
+	case While:
+		return fmt.Sprintf("while %s:\n", expr(e.expr)) + block(e.body)
+	case IfThenElse:
+		str := fmt.Sprintf("if %s:\n", expr(e.expr)) + block(e.ibody)
+		if len(e.ebody) > 0 {
+			str += "else:\n" + block(e.ebody)
+		}
+		return str
+	case Switch: // the value is tested before any case runs, a nested switch may reuse the variable
+		return fmt.Sprintf("wend_switch = %s\n", expr(e.expr)) + pyCases(e, "wend_switch", block)
+	default:
+		panic(fmt.Sprint("Unknown statement type", e))
+	}
+}
+
+// a chain of tests of the value held by the variable, the default case comes last
+func pyCases(e Switch, variable string, block func([]Statement) string) string {
+	str, keyword := "", "if"
+	var dflt []Statement
+	for _, c := range e.cases {
+		if len(c.values) == 0 {
+			dflt = c.body
+			continue
+		}
+		tests := []string{}
+		for _, v := range c.values {
+			tests = append(tests, fmt.Sprintf("%s == %s", variable, pyLiteral(v)))
+		}
+		str += fmt.Sprintf("%s %s:\n", keyword, strings.Join(tests, " or ")) + block(c.body)
+		keyword = "elif"
+	}
+	if keyword == "if" { // the default case only
+		return "if True:\n" + block(dflt)
+	}
+	if len(dflt) > 0 {
+		str += "else:\n" + block(dflt)
+	}
+	return str
+}
+
+func expr(n Expression) string {
+	switch e := n.(type) {
+	case ArithOp:
+		return pyBinary(e.op, e.deco["type"].(Type), expr(e.left), expr(e.right))
+	case LogicOp:
+		return pyBinary(e.op, e.left.getDeco()["type"].(Type), expr(e.left), expr(e.right))
+	case Integer, Float, Boolean, String:
+		return pyLiteral(e)
+	case Var:
+		return pyName(e.name)
+	case Convert:
+		return pyConvert(e.expr.getDeco()["type"].(Type), e.deco["type"].(Type), expr(e.expr))
+	case FunRef:
+		return e.deco["fundeco"].(map[string]any)["label"].(string)
+	case New:
+		t := e.deco["type"].(Type)
+		return fmt.Sprintf("wend_new(%s, %s, %d)", expr(e.size), pyZero(t.elem()), elemSize(t))
+	case Index:
+		return fmt.Sprintf("wend_get(%s, %s)", expr(e.array), expr(e.index))
+	case FunCall:
+		if e.deco["extern"] == true {
+			pyExtern(e)
+		}
+		args := []string{}
+		for _, arg := range e.args {
+			args = append(args, expr(arg))
+		}
+		callee := fmt.Sprint(e.deco["label"])
+		if e.deco["indirect"] == true {
+			callee = pyName(e.name)
+		}
+		return fmt.Sprintf("%s(%s)", callee, strings.Join(args, ", "))
+	default:
+		panic(fmt.Sprint("Unknown expression type", e))
+	}
+}
+
+func indent(array []string) string {
+	var multiline string
+	for _, e := range array {
+		multiline += e
+	}
+
+	lines := strings.Split(multiline, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var res string
+	for _, line := range lines {
+		res += "\t" + line + "\n"
+	}
+	return res
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The registers, the stack and the display of the program without variables. A frame is laid out like in the
+// assembly: the arguments, then the local variables, the word i of the frame is stack[display[level]+i]. A long or
+// a float takes a single Python value, but two words as in the assembly, the second one is unused. A closure is
+// a Python function that plays the role of the thunk, the exported functions are closures as well.
+const pyNovarsRuntime = `eax, ebx = None, None
+stack = []
+
+def wend_closure(fun, level, varcnt, argwords, captured):
+	def thunk(*args):
+		global eax
+		saved = [(lvl, display[lvl]) for lvl, _ in captured]
+		for lvl, entry in captured: # restore the static context captured by the closure
+			display[lvl] = entry
+		stack.extend(args)
+		stack.extend([None] * varcnt)
+		stack.append(display[level])
+		display[level] = len(stack) - 1 - argwords - varcnt
+		eax = fun()
+		display[level] = stack.pop()
+		del stack[len(stack) - argwords - varcnt:]
+		for lvl, entry in saved:
+			display[lvl] = entry
+		return eax
+	return thunk
+
+`
+
+func argWords(argtypes []Type) int {
+	words := 0
+	for _, t := range argtypes {
+		words += t.size()
+	}
+	return words
+}
+
+func transnovars(root Function) string {
+	str := pyRuntime + pyBuiltins(root) + pyImports(root) + pyNovarsRuntime
+	str += fmt.Sprintf("display = [None] * %d\n\n", root.deco["levelCnt"])
+	if root.deco["library"] != true {
+		str += funnovars(root) + fmt.Sprintf("\ndisplay[%d] = 0 # frame of %s\n", root.deco["level"], root.name)
+		if root.deco["varCnt"].(int) > 0 {
+			str += fmt.Sprintf("stack.extend([None] * %d)\n", root.deco["varCnt"])
+		}
+		return str + fmt.Sprintf("%s()\n", root.deco["label"]) + pyGfxExit(root)
+	}
+	for _, f := range root.fun {
+		str += funnovars(f) + "\n"
+	}
+	for _, f := range root.fun {
+		if global, ok := f.deco["global"]; ok {
+			str += fmt.Sprintf("%s = wend_closure(%s, %d, %d, %d, ())\n", global, f.deco["label"], f.deco["level"],
+				f.deco["varCnt"], argWords(f.deco["argtypes"].([]Type)))
+		}
+	}
+	return str
+}
+
+func funnovars(n Function) string {
+	lines := []string{"global eax, ebx\n"}
+	for _, f := range n.fun {
+		lines = append(lines, funnovars(f))
+	}
+	for _, s := range n.body {
+		lines = append(lines, statnovars(s))
+	}
+	return fmt.Sprintf("def %s():\n", n.deco["label"]) + indent(lines)
+}
+
+func statnovars(n Statement) string {
+	block := func(ss []Statement, tail string) string {
+		body := []string{}
+		for _, s := range ss {
+			body = append(body, statnovars(s))
+		}
+		body = append(body, tail)
+		if strings.TrimSpace(strings.Join(body, "")) == "" {
+			body = []string{"pass\n"}
+		}
+		return indent(body)
+	}
+
+	switch e := n.(type) {
+	case Print:
+		return fmt.Sprintf("%swend_print(eax, %s)\n", exprnovars(e.expr), pyNewline(e.newline))
+	case Return:
+		var expr string
+		if e.expr != nil {
+			expr = exprnovars(e.expr)
+		}
+		return expr + "return eax\n"
+	case Assign:
+		return fmt.Sprintf("%sstack[display[%d]+%d] = eax # %s\n", exprnovars(e.expr), e.deco["level"], e.deco["offset"], e.name)
+	case Store:
+		return exprnovars(e.array) + "stack.append(eax) # stash the array\n" + exprnovars(e.index) + "stack.append(eax)\n" +
+			exprnovars(e.expr) + "ebx = stack.pop()\n" + "wend_set(stack.pop(), ebx, eax)\n"
+	case FunCall:
+		return exprnovars(e)
+	case While: // the condition is evaluated again at the end of the body
+		return fmt.Sprintf("%swhile eax:\n%s", exprnovars(e.expr), block(e.body, exprnovars(e.expr)))
+	case IfThenElse:
+		str := fmt.Sprintf("%sif eax:\n%s", exprnovars(e.expr), block(e.ibody, ""))
+		if len(e.ebody) > 0 {
+			str += "else:\n" + block(e.ebody, "")
+		}
+		return str
+	case Switch: // the tests do not change eax
+		return exprnovars(e.expr) + pyCases(e, "eax", func(ss []Statement) string { return block(ss, "") })
+	default:
+		panic(fmt.Sprint("Unknown statement type", e))
+	}
+}
+
+// convention: all expressions save their results to eax
+func exprnovars(n Expression) string {
+	switch e := n.(type) {
+	case ArithOp:
+		return binarynovars(e.op, e.deco["type"].(Type), e.left, e.right)
+	case LogicOp:
+		if e.op == "&&" || e.op == "||" { // short-circuit: the right operand is evaluated only if the left one does not decide
+			cond := map[string]string{"&&": "if eax:\n", "||": "if not eax:\n"}[e.op]
+			return exprnovars(e.left) + cond + indent([]string{exprnovars(e.right)})
+		}
+		return binarynovars(e.op, e.left.getDeco()["type"].(Type), e.left, e.right)
+	case Integer, Float, Boolean, String:
+		return fmt.Sprintf("eax = %s\n", pyLiteral(e))
+	case Var:
+		return fmt.Sprintf("eax = stack[display[%d]+%d] # %s\n", e.deco["level"], e.deco["offset"], e.name)
+	case Convert:
+		from, to := e.expr.getDeco()["type"].(Type), e.deco["type"].(Type)
+		if conv := pyConvert(from, to, "eax"); conv != "eax" {
+			return exprnovars(e.expr) + fmt.Sprintf("eax = %s\n", conv)
+		}
+		return exprnovars(e.expr)
+	case FunRef:
+		fundeco := e.deco["fundeco"].(map[string]any)
+		if fundeco["import"] == true {
+			return fmt.Sprintf("eax = %s\n", fundeco["label"])
+		}
+		captured := []string{}
+		for _, level := range fundeco["ancestors"].([]int) {
+			captured = append(captured, fmt.Sprintf("(%d, display[%d]), ", level, level))
+		}
+		return fmt.Sprintf("eax = wend_closure(%s, %d, %d, %d, (%s)) # %s\n", fundeco["label"], fundeco["level"],
+			fundeco["varCnt"], argWords(fundeco["argtypes"].([]Type)), strings.Join(captured, ""), e.name)
+	case New:
+		t := e.deco["type"].(Type)
+		return exprnovars(e.size) + fmt.Sprintf("eax = wend_new(eax, %s, %d)\n", pyZero(t.elem()), elemSize(t))
+	case Index:
+		return exprnovars(e.array) + "stack.append(eax) # stash the array\n" + exprnovars(e.index) + "eax = wend_get(stack.pop(), eax)\n"
+	case FunCall:
+		if e.deco["extern"] == true {
+			pyExtern(e)
+		}
+		allocargs := fmt.Sprintf("# prepare %s() call\n", e.name)
+		argwords := 0
+		for _, arg := range e.args {
+			allocargs += exprnovars(arg) + "stack.append(eax)\n"
+			if arg.getDeco()["type"].(Type).size() == 2 {
+				allocargs += "stack.append(None)\n"
+			}
+			argwords += arg.getDeco()["type"].(Type).size()
+		}
+		if e.deco["indirect"] == true || e.deco["import"] == true || e.deco["builtin"] == true { // the closure, the exported function or the builtin pops the arguments
+			closure := fmt.Sprintf("eax = %s\n", e.deco["label"])
+			if e.deco["indirect"] == true {
+				closure = exprnovars(e.deco["closure"].(Var))
+			}
+			return allocargs + closure +
+				fmt.Sprintf("eax = eax(*stack[len(stack)-%d:])\n", argwords) +
+				fmt.Sprintf("del stack[len(stack)-%d:]\n", argwords)
+		}
+		fundeco := e.deco["fundeco"].(map[string]any)
+		level := fundeco["level"].(int)
+		framesize := argwords + fundeco["varCnt"].(int)
+		if fundeco["varCnt"].(int) > 0 {
+			allocargs += fmt.Sprintf("stack.extend([None] * %d) # local variables\n", fundeco["varCnt"])
+		}
+		return allocargs +
+			fmt.Sprintf("stack.append(display[%d]) # save old frame pointer\n", level) +
+			fmt.Sprintf("display[%d] = len(stack)-%d # activate new frame pointer\n", level, framesize+1) +
+			fmt.Sprintf("eax = %s()\n", fundeco["label"]) +
+			fmt.Sprintf("display[%d] = stack.pop() # restore old frame pointer\n", level) +
+			fmt.Sprintf("del stack[len(stack)-%d:] # delete fun args and local vars, thus finishing %s() call\n", framesize, e.name)
+	default:
+		panic(fmt.Sprint("Unknown expression type", e))
+	}
+}
+
+func binarynovars(op string, t Type, left, right Expression) string {
+	return exprnovars(left) + "stack.append(eax) # evaluate left argument and stash it\n" +
+		exprnovars(right) + "ebx = eax # evaluate right arg\n" + "eax = stack.pop() # recall left arg\n" +
+		fmt.Sprintf("eax = %s # binary operation\n", pyBinary(op, t, "eax", "ebx"))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// both Python backends must print the same as the compiled programs
+func TestTranspy(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+
+	expectedFiles, _ := filepath.Glob(filepath.Join(rootpath, "test-programs", "*", "*.expected"))
+	for _, expectedFile := range expectedFiles {
+		base := strings.TrimSuffix(filepath.Base(expectedFile), ".expected")
+		for _, emit := range []string{"py", "py-novars"} {
+			t.Run(base+"/"+emit, func(t *testing.T) {
+				expected, err := os.ReadFile(expectedFile)
+				if err != nil {
+					t.Fatalf("Error read expected file: %s\n", err)
+				}
+				program, _, err := build(strings.TrimSuffix(expectedFile, ".expected")+".wend", t.TempDir(), buildOptions{emit: emit})
+				if err != nil {
+					t.Fatalf("build failed for %s: %s", base, err)
+				}
+				out, err := exec.Command(python, program).Output()
+				if err != nil {
+					t.Fatalf("fail to exec program %s: %s\n", base, err)
+				}
+				if string(out) != string(expected) {
+					t.Errorf("expected: %s, got: %s\n", expected, out)
+				}
+			})
+		}
+	}
+}
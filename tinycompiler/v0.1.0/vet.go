@@ -0,0 +1,494 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// static checks over the decorated syntax tree, the warnings do not prevent the compilation
+type Warning struct {
+	lineno int
+	msg    string
+}
+
+// a variable is identified by its frame (scope) and its slot in the frame (offset)
+type varKey struct {
+	scope  int
+	offset int
+}
+
+func keyOf(deco map[string]any) varKey {
+	return varKey{deco["scope"].(int), deco["offset"].(int)}
+}
+
+type vetter struct {
+	warnings  []Warning
+	read      map[varKey]bool   // variables read anywhere in the program
+	outerVars []map[string]int  // variables of the enclosing scopes, name -> line of the declaration
+	outerFuns []map[string]int  // functions of the enclosing scopes, signature -> line of the declaration
+	uninit    map[varKey]string // locals of the current function that may be read before assignment
+}
+
+func (v *vetter) warn(lineno any, format string, args ...any) {
+	v.warnings = append(v.warnings, Warning{lineno.(int), fmt.Sprintf(format, args...)})
+}
+
+func vet(n Function) []Warning {
+	v := &vetter{read: map[varKey]bool{}}
+	var collect func(f Function)
+	collect = func(f Function) {
+		visitExprs(f.body, func(e Expression) {
+			if e, ok := e.(Var); ok {
+				v.read[keyOf(e.deco)] = true
+			}
+		})
+		for _, nested := range f.fun {
+			collect(nested)
+		}
+	}
+	collect(n)
+	v.vetFun(n)
+	if g, ok := n.deco["callgraph"].(*callGraph); ok {
+		v.warnings = append(v.warnings, g.unused()...)
+	}
+	sort.SliceStable(v.warnings, func(i, j int) bool { return v.warnings[i].lineno < v.warnings[j].lineno })
+	return v.warnings
+}
+
+// drop the warnings for the lines marked with a "vet:ignore" comment, a comment on its own line covers the next line
+func suppress(source string, warnings []Warning) []Warning {
+	ignored := map[int]bool{}
+	for lineno, line := range strings.Split(source, "\n") {
+		comment := strings.Index(line, "//")
+		if comment < 0 || !strings.Contains(line[comment:], "vet:ignore") {
+			continue
+		}
+		ignored[lineno] = true
+		if strings.TrimSpace(line[:comment]) == "" {
+			ignored[lineno+1] = true
+		}
+	}
+	result := []Warning{}
+	for _, w := range warnings {
+		if !ignored[w.lineno] {
+			result = append(result, w)
+		}
+	}
+	return result
+}
+
+func (v *vetter) vetFun(f Function) {
+	declared := map[string]int{}
+	for _, va := range append(append([]Var{}, f.args...), f.vars...) {
+		for _, scope := range v.outerVars {
+			if lineno, ok := scope[va.name]; ok {
+				v.warn(va.deco["lineno"], "declaration of %s shadows the variable declared at line %d", va.name, lineno+1)
+				break
+			}
+		}
+		declared[va.name] = va.deco["lineno"].(int)
+	}
+	for _, arg := range f.args {
+		if !v.read[keyOf(arg.deco)] {
+			v.warn(arg.deco["lineno"], "parameter %s of %s is never used", arg.name, f.name)
+		}
+	}
+	for _, va := range f.vars {
+		if !v.read[keyOf(va.deco)] {
+			v.warn(va.deco["lineno"], "variable %s is declared but never used", va.name)
+		}
+	}
+
+	functions := map[string]int{}
+	for _, nested := range f.fun {
+		signature := Signature{nested.name, nested.deco["argtypes"].([]Type)}.String()
+		for _, scope := range v.outerFuns {
+			if lineno, ok := scope[signature]; ok {
+				v.warn(nested.deco["lineno"], "function %s shadows the function declared at line %d", nested.name, lineno+1)
+				break
+			}
+		}
+		functions[signature] = nested.deco["lineno"].(int)
+	}
+
+	if f.deco["type"] != VOID && !terminatesList(f.body) {
+		v.warn(f.deco["lineno"], "function %s can reach the end without a return statement", f.name)
+	}
+	v.vetStats(f.body)
+
+	// locals assigned by nested functions may be initialized by any call, they are not tracked
+	v.uninit = map[varKey]string{}
+	for _, va := range f.vars {
+		v.uninit[keyOf(va.deco)] = va.name
+	}
+	for _, nested := range f.fun {
+		visitFuns(nested, func(g Function) {
+			visitStats(g.body, func(s Statement) {
+				if s, ok := s.(Assign); ok {
+					delete(v.uninit, keyOf(s.deco))
+				}
+			})
+		})
+	}
+	v.flow(f.body, map[varKey]bool{})
+
+	v.outerVars = append(v.outerVars, declared)
+	v.outerFuns = append(v.outerFuns, functions)
+	for _, nested := range f.fun {
+		v.vetFun(nested)
+	}
+	v.outerVars = v.outerVars[:len(v.outerVars)-1]
+	v.outerFuns = v.outerFuns[:len(v.outerFuns)-1]
+}
+
+// unreachable statements and constant conditions
+func (v *vetter) vetStats(ss []Statement) {
+	for i, s := range ss {
+		if i > 0 && terminates(ss[i-1]) {
+			v.warn(statDeco(s)["lineno"], "unreachable statement")
+			break
+		}
+	}
+	for _, s := range ss {
+		switch e := s.(type) {
+		case While:
+			if value, ok := constEval(e.expr); ok {
+				if _, literal := e.expr.(Boolean); !literal || value == 0 { // "while true" is the way to write an endless loop
+					v.warn(e.deco["lineno"], "loop condition is always %t", value != 0)
+				}
+			}
+			v.vetStats(e.body)
+		case IfThenElse:
+			if value, ok := constEval(e.expr); ok {
+				v.warn(e.deco["lineno"], "if condition is always %t", value != 0)
+			}
+			v.vetStats(e.ibody)
+			v.vetStats(e.ebody)
+		case Switch:
+			for _, c := range e.cases {
+				v.vetStats(c.body)
+			}
+		}
+	}
+}
+
+// definite assignment analysis: assigned is the set of locals assigned on every path to the current statement,
+// the result tells if the statement list never completes normally
+func (v *vetter) flow(ss []Statement, assigned map[varKey]bool) bool {
+	check := func(e Expression) {
+		visitExpr(e, func(e Expression) {
+			if e, ok := e.(Var); ok {
+				key := keyOf(e.deco)
+				if name, tracked := v.uninit[key]; tracked && !assigned[key] {
+					v.warn(e.deco["lineno"], "variable %s may be read before assignment", name)
+					delete(v.uninit, key) // report once
+				}
+			}
+		})
+	}
+	for _, s := range ss {
+		switch e := s.(type) {
+		case Print:
+			check(e.expr)
+		case Return:
+			if e.expr != nil {
+				check(e.expr)
+			}
+			return true
+		case Assign:
+			check(e.expr)
+			assigned[keyOf(e.deco)] = true
+		case Store:
+			check(e.array)
+			check(e.index)
+			check(e.expr)
+		case FunCall:
+			check(e)
+		case While:
+			check(e.expr)
+			v.flow(e.body, copySet(assigned))
+			if terminates(e) {
+				return true
+			}
+		case IfThenElse:
+			check(e.expr)
+			iassigned, eassigned := copySet(assigned), copySet(assigned)
+			iterm, eterm := v.flow(e.ibody, iassigned), v.flow(e.ebody, eassigned)
+			switch {
+			case iterm && eterm:
+				return true
+			case iterm:
+				iassigned = eassigned
+			case eterm:
+				eassigned = iassigned
+			}
+			for key := range iassigned {
+				if eassigned[key] {
+					assigned[key] = true
+				}
+			}
+		case Switch: // the locals assigned by every case that completes, and before the switch without a default
+			check(e.expr)
+			var common map[varKey]bool
+			if !e.hasDefault() {
+				common = copySet(assigned)
+			}
+			for _, c := range e.cases {
+				cassigned := copySet(assigned)
+				if v.flow(c.body, cassigned) {
+					continue
+				}
+				if common == nil {
+					common = cassigned
+				}
+				for key := range common {
+					if !cassigned[key] {
+						delete(common, key)
+					}
+				}
+			}
+			if common == nil {
+				return true
+			}
+			for key := range common {
+				assigned[key] = true
+			}
+		}
+	}
+	return false
+}
+
+func copySet(set map[varKey]bool) map[varKey]bool {
+	result := map[varKey]bool{}
+	for k := range set {
+		result[k] = true
+	}
+	return result
+}
+
+// a statement terminates if the control never passes to the next statement
+func terminates(s Statement) bool {
+	switch e := s.(type) {
+	case Return:
+		return true
+	case IfThenElse:
+		return terminatesList(e.ibody) && terminatesList(e.ebody)
+	case Switch:
+		for _, c := range e.cases {
+			if !terminatesList(c.body) {
+				return false
+			}
+		}
+		return e.hasDefault()
+	case While: // there is no break statement
+		value, ok := constEval(e.expr)
+		return ok && value != 0
+	}
+	return false
+}
+
+func terminatesList(ss []Statement) bool {
+	for _, s := range ss {
+		if terminates(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate an expression made of literals only, booleans are represented by 0 and 1
+func constEval(n Expression) (int32, bool) {
+	switch e := n.(type) {
+	case Integer:
+		if e.deco["type"] == LONG {
+			return 0, false
+		}
+		return int32(e.value), true
+	case Boolean:
+		if e.value {
+			return 1, true
+		}
+		return 0, true
+	case ArithOp, LogicOp:
+		var op string
+		var left, right Expression
+		if a, ok := e.(ArithOp); ok {
+			op, left, right = a.op, a.left, a.right
+		} else {
+			l := e.(LogicOp)
+			op, left, right = l.op, l.left, l.right
+		}
+		a, ok1 := constEval(left)
+		b, ok2 := constEval(right)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		btoi := func(c bool) int32 {
+			if c {
+				return 1
+			}
+			return 0
+		}
+		switch op {
+		case "+":
+			return a + b, true
+		case "-":
+			return a - b, true
+		case "*":
+			return a * b, true
+		case "/", "%":
+			if b == 0 {
+				return 0, false
+			}
+			if op == "/" {
+				return a / b, true
+			}
+			return a % b, true
+		case "&":
+			return a & b, true
+		case "|":
+			return a | b, true
+		case "^":
+			return a ^ b, true
+		case "<<":
+			return a << (b & 31), true
+		case ">>":
+			return a >> (b & 31), true
+		case ">>>":
+			return int32(uint32(a) >> (b & 31)), true
+		case "<":
+			return btoi(a < b), true
+		case "<=":
+			return btoi(a <= b), true
+		case ">":
+			return btoi(a > b), true
+		case ">=":
+			return btoi(a >= b), true
+		case "==":
+			return btoi(a == b), true
+		case "!=":
+			return btoi(a != b), true
+		case "&&":
+			return btoi(a != 0 && b != 0), true
+		case "||":
+			return btoi(a != 0 || b != 0), true
+		}
+	}
+	return 0, false
+}
+
+func statDeco(n Statement) map[string]any {
+	switch e := n.(type) {
+	case Print:
+		return e.deco
+	case Return:
+		return e.deco
+	case Assign:
+		return e.deco
+	case Store:
+		return e.deco
+	case FunCall:
+		return e.deco
+	case While:
+		return e.deco
+	case IfThenElse:
+		return e.deco
+	case Switch:
+		return e.deco
+	case Inline:
+		return e.deco
+	}
+	panic(fmt.Sprint("Unknown statement type", n))
+}
+
+// call visit for the function and all its nested functions
+func visitFuns(f Function, visit func(Function)) {
+	visit(f)
+	for _, nested := range f.fun {
+		visitFuns(nested, visit)
+	}
+}
+
+// call visit for every statement of the list including the nested blocks, nested functions are not entered
+func visitStats(ss []Statement, visit func(Statement)) {
+	for _, s := range ss {
+		visit(s)
+		switch e := s.(type) {
+		case While:
+			visitStats(e.body, visit)
+		case IfThenElse:
+			visitStats(e.ibody, visit)
+			visitStats(e.ebody, visit)
+		case Switch:
+			for _, c := range e.cases {
+				visitStats(c.body, visit)
+			}
+		case Inline:
+			visitStats(e.body, visit)
+		}
+	}
+}
+
+// call visit for every expression and subexpression of the statement list, nested functions are not entered
+func visitExprs(ss []Statement, visit func(Expression)) {
+	visitStats(ss, func(s Statement) {
+		switch e := s.(type) {
+		case Print:
+			visitExpr(e.expr, visit)
+		case Return:
+			if e.expr != nil {
+				visitExpr(e.expr, visit)
+			}
+		case Assign:
+			visitExpr(e.expr, visit)
+		case Store:
+			visitExpr(e.array, visit)
+			visitExpr(e.index, visit)
+			visitExpr(e.expr, visit)
+		case FunCall:
+			visitExpr(e, visit)
+		case While:
+			visitExpr(e.expr, visit)
+		case IfThenElse:
+			visitExpr(e.expr, visit)
+		case Switch:
+			visitExpr(e.expr, visit)
+		case Inline:
+			if e.expr != nil {
+				visitExpr(e.expr, visit)
+			}
+		}
+	})
+}
+
+func visitExpr(n Expression, visit func(Expression)) {
+	visit(n)
+	switch e := n.(type) {
+	case ArithOp:
+		visitExpr(e.left, visit)
+		visitExpr(e.right, visit)
+	case LogicOp:
+		visitExpr(e.left, visit)
+		visitExpr(e.right, visit)
+	case Convert:
+		visitExpr(e.expr, visit)
+	case New:
+		visitExpr(e.size, visit)
+	case Index:
+		visitExpr(e.array, visit)
+		visitExpr(e.index, visit)
+	case Inline:
+		visitExprs(e.body, visit)
+		if e.expr != nil {
+			visitExpr(e.expr, visit)
+		}
+	case FunCall:
+		for _, arg := range e.args {
+			visitExpr(arg, visit)
+		}
+		if closure, ok := e.deco["closure"].(Var); ok {
+			visitExpr(closure, visit)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestVet(t *testing.T) {
+	source := `main() {
+    int x;
+    int unused;
+    int late;
+
+    int sign(int v) {
+        if v < 0 {
+            return -1;
+        }
+    }
+
+    int first(int a, int b) {
+        int x;
+        x = a;
+        return x;
+        println x;
+    }
+
+    x = 1;
+    println late;
+    late = 2;
+    if 1 < 2 {
+        println first(x, sign(late));
+    }
+    while 2 + 2 == 5 { // vet:ignore
+        x = x + 1;
+    }
+}
+`
+	expected := []string{
+		"3: variable unused is declared but never used",
+		"6: function sign can reach the end without a return statement",
+		"12: parameter b of first is never used",
+		"13: declaration of x shadows the variable declared at line 2",
+		"16: unreachable statement",
+		"20: variable late may be read before assignment",
+		"22: if condition is always true",
+	}
+
+	tokens := tokenize(source)
+	ast := (&WendParser{}).Parse(tokens)
+	buildSymtable(ast)
+	warnings := suppress(source, vet(ast.(Function)))
+
+	if len(warnings) != len(expected) {
+		t.Fatalf("expected %d warnings, got %v", len(expected), warnings)
+	}
+	for i, w := range warnings {
+		if got := fmt.Sprintf("%d: %s", w.lineno+1, w.msg); got != expected[i] {
+			t.Errorf("expected: %s, got: %s", expected[i], got)
+		}
+	}
+}